@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/internal/server"
+	"github.com/spf13/cobra"
+)
+
+// NewAuthServiceCmd runs only the authentication routes, including the
+// forward-auth endpoint used by the reverse proxy. It listens on all
+// interfaces so the proxy can reach it from another container.
+func NewAuthServiceCmd() *cobra.Command {
+	var (
+		host string
+		port int
+	)
+	cmd := &cobra.Command{
+		Use: "auth-service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			cfg.Host = host
+			cfg.Port = port
+			srv, err := server.SetupAuthServer(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			return srv.ListenAndServe()
+		},
+	}
+	cmd.Flags().StringVar(&host, "host", "", "interface to listen on, empty for all")
+	cmd.Flags().IntVar(&port, "port", 8081, "port to listen on")
+
+	return cmd
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/internal/server"
+	"github.com/spf13/cobra"
+)
+
+// NewRetentionCmd groups the commands that manage data retention.
+func NewRetentionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retention",
+		Short: "Manage data retention",
+	}
+	cmd.AddCommand(newRetentionRunCmd())
+
+	return cmd
+}
+
+// newRetentionRunCmd applies the configured retention rules once. With
+// --dry-run it only reports what would be removed.
+func newRetentionRunCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Apply the retention rules once",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			retention, err := server.SetupRetention(cfg)
+			if err != nil {
+				return err
+			}
+			report, err := retention.Run(cmd.Context(), dryRun)
+			verb := "removed"
+			if dryRun {
+				verb = "would remove"
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "%s %d expired messages\n", verb, report.Expired)
+			fmt.Fprintf(out, "%s %d messages past the maximum age\n", verb, report.Messages)
+			fmt.Fprintf(out, "%s %d deleted accounts\n", verb, report.Accounts)
+			return err
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report what would be removed without removing it")
+
+	return cmd
+}
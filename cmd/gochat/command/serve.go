@@ -14,7 +14,7 @@ func NewServeCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			srv, err := server.SetupServer(cfg)
+			srv, err := server.SetupServer(cmd.Context(), cfg)
 			if err != nil {
 				return err
 			}
@@ -29,6 +29,8 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(cmd.NewServeCmd())
+	rootCmd.AddCommand(cmd.NewAuthServiceCmd())
+	rootCmd.AddCommand(cmd.NewRetentionCmd())
 }
 
 func main() {
@@ -2,14 +2,18 @@ module example.com/elug3/chatapp/cmd/message-store
 
 go 1.24.2
 
-require github.com/jackc/pgx/v5 v5.7.4
+require (
+	github.com/elug3/gochat v0.0.0
+	github.com/jackc/pgx/v5 v5.7.4
+)
 
 require (
-	github.com/elug3/chatapp/pkg/appresp v0.0.0-20250415075918-a09be6a8b1ec // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/puddle/v2 v2.2.2 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/crypto v0.32.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 )
+
+replace github.com/elug3/gochat => ../..
@@ -1,26 +1,63 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
+
+	"github.com/elug3/gochat/pkg/jwt"
 )
 
+type contextKey string
+
+const claimsKey contextKey = "claims"
+
+// requireAuth verifies the bearer token with the keys published by gochat,
+// so requests are authenticated without asking the user database.
+func requireAuth(verifier *jwt.Verifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		claims, err := verifier.Verify(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	}
+}
+
 // GET rooms/{roomid}/messages
 func handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	roomId := r.PathValue("roomid")
 	messages, err := messageService.GetMessages(r.Context(), roomId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	json.NewEncoder(w).Encode(messages)
 }
 
 // POST rooms/{roomid}/messages
 func handlePostMessage(w http.ResponseWriter, r *http.Request) {
-	roomId := r.PathValue("roomid")
+	claims := r.Context().Value(claimsKey).(*jwt.Claims)
 
-	err := messageService.GetMessages()
-	if err != nil {
-		http.Error
+	var message Message
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	message.RoomId = r.PathValue("roomid")
+	message.SenderId = claims.Subject
+
+	if err := messageService.PostMessage(r.Context(), &message); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(http.StatusCreated)
 }
@@ -3,15 +3,27 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
+
+	"github.com/elug3/gochat/pkg/jwt"
 )
 
 var messageService MessageService
 
 func main() {
+	jwksURL := os.Getenv("JWKS_URL")
+	if jwksURL == "" {
+		log.Fatal("JWKS_URL must be set")
+	}
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "gochat"
+	}
 	messageService = *NewMessageService()
+	verifier := jwt.NewVerifier(jwksURL, issuer)
 
-	http.HandleFunc("GET /rooms/{roomid}/messages", handleGetMessages)
-	http.HandleFunc("POST /rooms/{roomid}/messages", handlePostMessage)
+	http.HandleFunc("GET /rooms/{roomid}/messages", requireAuth(verifier, handleGetMessages))
+	http.HandleFunc("POST /rooms/{roomid}/messages", requireAuth(verifier, handlePostMessage))
 
 	err := http.ListenAndServe(":8083", nil)
 	if err != nil {
@@ -3,6 +3,9 @@ module exmaple.com/chatapp/cmd/notification
 go 1.24.2
 
 require (
-	github.com/coder/websocket v1.8.13 // indirect
-	golang.org/x/time v0.11.0 // indirect
+	github.com/coder/websocket v1.8.13
+	github.com/elug3/gochat v0.0.0
+	golang.org/x/time v0.11.0
 )
+
+replace github.com/elug3/gochat => ../..
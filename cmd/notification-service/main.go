@@ -8,6 +8,8 @@ import (
 	"os"
 	"os/signal"
 	"time"
+
+	"github.com/elug3/gochat/pkg/jwt"
 )
 
 func main() {
@@ -26,7 +28,16 @@ func run() error {
 		return err
 	}
 
-	ns := NewChatServer()
+	// tokens are verified against the keys published by gochat
+	var verifier *jwt.Verifier
+	if url := os.Getenv("JWKS_URL"); url != "" {
+		issuer := os.Getenv("JWT_ISSUER")
+		if issuer == "" {
+			issuer = "gochat"
+		}
+		verifier = jwt.NewVerifier(url, issuer)
+	}
+	ns := NewChatServer(verifier)
 	s := &http.Server{
 		Handler:      ns,
 		ReadTimeout:  time.Second * 5,
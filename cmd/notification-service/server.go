@@ -7,10 +7,12 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/coder/websocket"
+	"github.com/elug3/gochat/pkg/jwt"
 	"golang.org/x/time/rate"
 )
 
@@ -29,14 +31,18 @@ type ChatServer struct {
 
 	subscriberMu sync.Mutex
 	subscribers  map[*Subscriber]struct{}
+
+	// verifier checks access tokens of subscribers, nil disables auth
+	verifier *jwt.Verifier
 }
 
-func NewChatServer() *ChatServer {
+func NewChatServer(verifier *jwt.Verifier) *ChatServer {
 	cs := &ChatServer{
 		subscriberMesageBuffer: 16,
 		logf:                   log.Printf,
 		subscribers:            make(map[*Subscriber]struct{}),
 		publishLimiter:         rate.NewLimiter(rate.Every(time.Millisecond*100), 8),
+		verifier:               verifier,
 	}
 	cs.serveMux.HandleFunc("/subscribe", cs.subscribeHandler)
 	return cs
@@ -46,7 +52,27 @@ func (cs *ChatServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cs.serveMux.ServeHTTP(w, r)
 }
 
+// authenticate verifies the bearer token of the request. Browsers cannot set
+// headers on websocket requests, so the token may also be passed as a query
+// parameter.
+func (cs *ChatServer) authenticate(r *http.Request) (*jwt.Claims, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return nil, errors.New("missing token")
+	}
+	return cs.verifier.Verify(token)
+}
+
 func (cs *ChatServer) subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if cs.verifier != nil {
+		if _, err := cs.authenticate(r); err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
 	err := cs.subscribe(w, r)
 	if errors.Is(err, context.Canceled) {
 		return
@@ -2,17 +2,15 @@ package config
 
 import (
 	"os"
+	"time"
 
+	"github.com/elug3/gochat/pkg/config"
 	"github.com/spf13/viper"
 )
 
-type Config struct {
-	Port    int    `mapstructure:"port"`
-	SaveDir string `mapstructure:"saveDir"`
-	NoSave  bool   `mapstructure:"noSave"`
-}
-
-func LoadConfig() (*Config, error) {
+// LoadConfig reads config.yaml from the working directory, if there is one,
+// over the defaults.
+func LoadConfig() (*config.Config, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return nil, err
@@ -25,8 +23,44 @@ func LoadConfig() (*Config, error) {
 	viper.AddConfigPath(".")
 	viper.SetConfigType("yaml")
 
+	viper.SetDefault("host", "localhost")
 	viper.SetDefault("port", 8080)
 	viper.SetDefault("saveDir", localDir+"/data")
+	viper.SetDefault("auth.accessTokenTTL", 15*time.Minute)
+	viper.SetDefault("auth.refreshTokenTTL", 30*24*time.Hour)
+	viper.SetDefault("auth.slidingExpiration", false)
+	viper.SetDefault("auth.sweepInterval", time.Hour)
+	viper.SetDefault("auth.jwt.enabled", false)
+	viper.SetDefault("auth.jwt.issuer", "gochat")
+	viper.SetDefault("auth.password.minLength", 8)
+	viper.SetDefault("auth.resetCodeTTL", 15*time.Minute)
+	viper.SetDefault("auth.resetRate", 1)
+	viper.SetDefault("auth.resetBurst", 3)
+	viper.SetDefault("auth.lockout.maxAttempts", 10)
+	viper.SetDefault("auth.lockout.baseDelay", time.Second)
+	viper.SetDefault("auth.lockout.maxDelay", time.Minute)
+	viper.SetDefault("auth.lockout.duration", 15*time.Minute)
+	viper.SetDefault("auth.lockout.window", time.Hour)
+	viper.SetDefault("auth.twoFactor.issuer", "gochat")
+	viper.SetDefault("auth.twoFactor.challengeTTL", 5*time.Minute)
+	viper.SetDefault("auth.oidc.enabled", false)
+	viper.SetDefault("auth.oidc.scopes", []string{"openid", "profile", "email"})
+	viper.SetDefault("auth.oidc.stateTTL", 10*time.Minute)
+	viper.SetDefault("notifier.path", localDir+"/notifications.log")
+	viper.SetDefault("webhook.timeout", 10*time.Second)
+	viper.SetDefault("webhook.pollInterval", 5*time.Second)
+	viper.SetDefault("webhook.maxAttempts", 8)
+	viper.SetDefault("webhook.baseDelay", 30*time.Second)
+	viper.SetDefault("webhook.maxDelay", time.Hour)
+	viper.SetDefault("webhook.disableAfter", 5)
+	viper.SetDefault("webhook.incomingRate", 60)
+	viper.SetDefault("webhook.incomingBurst", 10)
+	viper.SetDefault("webhook.allowInternal", false)
+	viper.SetDefault("command.timeout", 3*time.Second)
+	viper.SetDefault("command.allowInternal", false)
+	viper.SetDefault("schedule.pollInterval", 5*time.Second)
+	viper.SetDefault("retention.interval", time.Minute)
+	viper.SetDefault("retention.accountGracePeriod", 30*24*time.Hour)
 
 	if err = viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
@@ -34,7 +68,7 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
-	var cfg Config
+	var cfg config.Config
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, err
 	}
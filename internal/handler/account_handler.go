@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	Accounts *service.AccountService
+}
+
+func NewAccountHandler(accounts *service.AccountService) (*AccountHandler, error) {
+	return &AccountHandler{Accounts: accounts}, nil
+}
+
+// HandleDeleteAccount deletes the account of the authenticated user.
+func (h *AccountHandler) HandleDeleteAccount(c *gin.Context) {
+	if err := h.Accounts.Delete(c.GetInt("userId")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleExport downloads a ZIP archive of the data of the authenticated user.
+func (h *AccountHandler) HandleExport(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := h.Accounts.Export(c.GetInt("userId"), &buf); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="gochat-export.zip"`)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
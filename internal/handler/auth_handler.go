@@ -1,11 +1,17 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/elug3/gochat/pkg/model"
 	"github.com/elug3/gochat/pkg/service"
+	"github.com/elug3/gochat/pkg/store"
 	"github.com/gin-gonic/gin"
 )
 
@@ -27,24 +33,104 @@ func parseToken(r *http.Request) (string, error) {
 }
 
 // AuthMiddleware is a middleware that checks for a valid authentication token
-// if the token is valid, it sets the userId in the context
+// if the token is valid, it sets the userId in the context,
+// otherwise the reason is kept as authError for authRequired
 func AuthMiddleware(s *service.UserService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if tokenString, err := parseToken(c.Request); err == nil {
 			if token, err := s.Authenticate(c.Request.Context(), tokenString); err == nil {
 				c.Set("userId", token.UserId)
+				c.Set("sessionId", token.SessionId)
+				if token.APIKey != nil {
+					c.Set("apiKey", token.APIKey)
+				}
+			} else {
+				c.Set("authError", err)
 			}
 		}
 		c.Next()
 	}
 }
 
+// authErrorCode describes why the request is not authenticated.
+func authErrorCode(c *gin.Context) string {
+	v, exists := c.Get("authError")
+	if !exists {
+		return "missing_token"
+	}
+	if err, ok := v.(error); ok && errors.Is(err, store.ErrExpired) {
+		return "token_expired"
+	}
+	return "invalid_token"
+}
+
 func NewAuthHandler(userService *service.UserService) (*AuthHandler, error) {
 	return &AuthHandler{users: userService}, nil
 }
 
+// HandleGetAuthorizations lists the active sessions of the authenticated user.
 func (h *AuthHandler) HandleGetAuthorizations(c *gin.Context) {
+	sessions, err := h.users.ListSessions(c.Request.Context(), c.GetInt("userId"), c.GetInt("sessionId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// HandleRevokeAuthorization revokes one session of the authenticated user.
+func (h *AuthHandler) HandleRevokeAuthorization(c *gin.Context) {
+	sessionId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.users.RevokeSession(c.Request.Context(), c.GetInt("userId"), sessionId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
 
+// HandleRevokeOtherAuthorizations revokes every session of the authenticated
+// user except the current one.
+func (h *AuthHandler) HandleRevokeOtherAuthorizations(c *gin.Context) {
+	n, err := h.users.RevokeOtherSessions(c.Request.Context(), c.GetInt("userId"), c.GetInt("sessionId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": n})
+}
+
+// requestDevice describes the client of the request.
+func requestDevice(c *gin.Context) model.Device {
+	return model.Device{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+}
+
+// abortThrottled writes a 429 response with Retry-After if err is a
+// throttled login.
+func abortThrottled(c *gin.Context, err error) bool {
+	var throttled *service.ThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	abortTooManyRequests(c, throttled.RetryAfter, "Too many failed logins")
+	return true
+}
+
+// abortTooManyRequests writes a 429 response with Retry-After.
+func abortTooManyRequests(c *gin.Context, wait time.Duration, message string) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"code":        http.StatusTooManyRequests,
+		"message":     message,
+		"retry_after": retryAfter,
+	})
 }
 
 func (h *AuthHandler) HandleLogin(c *gin.Context) {
@@ -56,7 +142,15 @@ func (h *AuthHandler) HandleLogin(c *gin.Context) {
 		})
 		return
 	}
-	token, err := h.users.Login(c.Request.Context(), username, password)
+	token, err := h.users.Login(c.Request.Context(), username, password, requestDevice(c))
+	if abortThrottled(c, err) {
+		return
+	}
+	var challenge *service.ChallengeError
+	if errors.As(err, &challenge) {
+		c.JSON(http.StatusOK, gin.H{"challenge": challenge.Challenge})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"code":    http.StatusUnauthorized,
@@ -66,3 +160,200 @@ func (h *AuthHandler) HandleLogin(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"token": token})
 }
+
+// HandleRefresh exchanges a refresh token for a new token pair.
+func (h *AuthHandler) HandleRefresh(c *gin.Context) {
+	var params struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	token, err := h.users.Refresh(c.Request.Context(), params.RefreshToken)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// HandleLogout revokes the session of the presented token.
+func (h *AuthHandler) HandleLogout(c *gin.Context) {
+	if err := h.users.Logout(c.Request.Context(), c.GetInt("sessionId")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleJWKS publishes the public keys verifying signed access tokens.
+func (h *AuthHandler) HandleJWKS(c *gin.Context) {
+	jwks, ok := h.users.JWKS()
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "signed tokens are disabled",
+		})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
+
+// HandleVerify answers forward-auth requests of a reverse proxy. The identity
+// of the token is passed on to the upstream service in headers.
+func (h *AuthHandler) HandleVerify(c *gin.Context) {
+	c.Header("X-Auth-User", strconv.Itoa(c.GetInt("userId")))
+	c.Header("X-Auth-Session", strconv.Itoa(c.GetInt("sessionId")))
+	c.Status(http.StatusOK)
+}
+
+// HandleChangePassword replaces the password of the authenticated user and
+// signs out all other sessions.
+func (h *AuthHandler) HandleChangePassword(c *gin.Context) {
+	var params struct {
+		OldPassword string `json:"old_password" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	err := h.users.ChangePassword(c.Request.Context(), c.GetInt("userId"), c.GetInt("sessionId"), params.OldPassword, params.NewPassword)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleRequestPasswordReset sends a reset code to the user. The response is
+// the same whether or not the user exists.
+func (h *AuthHandler) HandleRequestPasswordReset(c *gin.Context) {
+	var params struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	if err := h.users.RequestPasswordReset(c.Request.Context(), params.Username); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// HandleResetPassword sets a new password using a reset code.
+func (h *AuthHandler) HandleResetPassword(c *gin.Context) {
+	var params struct {
+		Code        string `json:"code" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	if err := h.users.ResetPassword(c.Request.Context(), params.Code, params.NewPassword); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleGetFailedLogins lists recent failed login attempts on the account of
+// the authenticated user.
+func (h *AuthHandler) HandleGetFailedLogins(c *gin.Context) {
+	audits, err := h.users.ListFailedLogins(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, audits)
+}
+
+// HandleCompleteLogin exchanges a login challenge and a second factor code
+// for a token pair.
+func (h *AuthHandler) HandleCompleteLogin(c *gin.Context) {
+	var params struct {
+		Challenge string `json:"challenge" binding:"required"`
+		Code      string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	token, err := h.users.CompleteLogin(c.Request.Context(), params.Challenge, params.Code, requestDevice(c))
+	if abortThrottled(c, err) {
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// HandleEnrollTOTP starts setting up an authenticator app.
+func (h *AuthHandler) HandleEnrollTOTP(c *gin.Context) {
+	enrollment, err := h.users.EnrollTOTP(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// bindCode reads the second factor code of the request body.
+func bindCode(c *gin.Context) (string, bool) {
+	var params struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return "", false
+	}
+	return params.Code, true
+}
+
+// HandleActivateTOTP enables 2FA with a code of the enrolled authenticator.
+func (h *AuthHandler) HandleActivateTOTP(c *gin.Context) {
+	code, ok := bindCode(c)
+	if !ok {
+		return
+	}
+	codes, err := h.users.ActivateTOTP(c.Request.Context(), c.GetInt("userId"), code)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// HandleRegenerateRecoveryCodes replaces the recovery codes of the user.
+func (h *AuthHandler) HandleRegenerateRecoveryCodes(c *gin.Context) {
+	code, ok := bindCode(c)
+	if !ok {
+		return
+	}
+	codes, err := h.users.RegenerateRecoveryCodes(c.Request.Context(), c.GetInt("userId"), code)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// HandleDisableTOTP turns 2FA off.
+func (h *AuthHandler) HandleDisableTOTP(c *gin.Context) {
+	code, ok := bindCode(c)
+	if !ok {
+		return
+	}
+	if err := h.users.DisableTOTP(c.Request.Context(), c.GetInt("userId"), code); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
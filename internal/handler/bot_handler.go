@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type BotHandler struct {
+	users    *service.UserService
+	contacts *service.ContactsService
+}
+
+func NewBotHandler(users *service.UserService, contacts *service.ContactsService) (*BotHandler, error) {
+	return &BotHandler{users: users, contacts: contacts}, nil
+}
+
+// HandleCreateBot creates a bot owned by the authenticated user, with a
+// profile flagged as a bot.
+func (h *BotHandler) HandleCreateBot(c *gin.Context) {
+	var params struct {
+		Username string `json:"username" binding:"required"`
+		Name     string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	if params.Name == "" {
+		params.Name = params.Username
+	}
+	ownerId := c.GetInt("userId")
+	bot, err := h.users.CreateBot(c.Request.Context(), ownerId, params.Username)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	profile, err := h.contacts.CreateBotProfile(bot.Id, params.Name)
+	if err != nil {
+		if err := h.users.DeleteBot(c.Request.Context(), ownerId, bot.Id); err != nil {
+			log.Error().Err(err).Int("botId", bot.Id).Msg("delete bot")
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": bot, "profile": profile})
+}
+
+// HandleGetBots lists the bots of the authenticated user.
+func (h *BotHandler) HandleGetBots(c *gin.Context) {
+	bots, err := h.users.ListBots(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, bots)
+}
+
+// HandleDeleteBot deletes a bot of the authenticated user with its keys and profile.
+func (h *BotHandler) HandleDeleteBot(c *gin.Context) {
+	botId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.users.DeleteBot(c.Request.Context(), c.GetInt("userId"), botId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err = h.contacts.DeleteProfile(botId); err != nil {
+		log.Error().Err(err).Int("botId", botId).Msg("delete bot profile")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleCreateAPIKey issues an API key for a bot. The key is only shown in
+// this response.
+func (h *BotHandler) HandleCreateAPIKey(c *gin.Context) {
+	botId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	var params struct {
+		Name     string   `json:"name"`
+		Scopes   []string `json:"scopes" binding:"required"`
+		GroupIds []int    `json:"group_ids"`
+	}
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	key, err := h.users.CreateAPIKey(c.Request.Context(), c.GetInt("userId"), botId, params.Name, params.Scopes, params.GroupIds)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// HandleGetAPIKeys lists the API keys of a bot.
+func (h *BotHandler) HandleGetAPIKeys(c *gin.Context) {
+	botId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	keys, err := h.users.ListAPIKeys(c.Request.Context(), c.GetInt("userId"), botId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// HandleRevokeAPIKey revokes an API key of a bot.
+func (h *BotHandler) HandleRevokeAPIKey(c *gin.Context) {
+	botId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	keyId, err := parseIdParam(c, "keyId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.users.RevokeAPIKey(c.Request.Context(), c.GetInt("userId"), botId, keyId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+)
+
+type CommandHandler struct {
+	Commands *service.CommandService
+}
+
+func NewCommandHandler(commands *service.CommandService) (*CommandHandler, error) {
+	return &CommandHandler{Commands: commands}, nil
+}
+
+// HandleGetCommands lists the commands of a group, optionally only those
+// starting with the prefix query parameter, for help and autocompletion.
+func (h *CommandHandler) HandleGetCommands(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	commands, err := h.Commands.List(c.GetInt("userId"), groupId, c.Query("prefix"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, commands)
+}
+
+// HandleCreateCommand adds an external command to a group. The signing secret
+// is only shown in this response.
+func (h *CommandHandler) HandleCreateCommand(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params service.CommandParams
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	command, err := h.Commands.CreateCommand(c.GetInt("userId"), groupId, params)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, command)
+}
+
+// HandleDeleteCommand removes an external command from a group.
+func (h *CommandHandler) HandleDeleteCommand(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	commandId, err := parseIdParam(c, "commandId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.Commands.DeleteCommand(c.GetInt("userId"), groupId, commandId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
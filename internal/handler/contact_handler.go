@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type ContactHandler struct {
+	Contacts *service.ContactsService
+}
+
+func NewContactHandler(contacts *service.ContactsService) (*ContactHandler, error) {
+	return &ContactHandler{Contacts: contacts}, nil
+}
+
+// presenceMiddleware records activity of the authenticated user.
+func presenceMiddleware(contacts *service.ContactsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if userId := c.GetInt("userId"); userId != 0 {
+			if err := contacts.Touch(userId); err != nil {
+				log.Error().Err(err).Int("userId", userId).Msg("touch presence")
+			}
+		}
+		c.Next()
+	}
+}
+
+// HandleGetContacts lists the contacts of the authenticated user with their presence.
+func (h *ContactHandler) HandleGetContacts(c *gin.Context) {
+	contacts, err := h.Contacts.ListContacts(c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, contacts)
+}
+
+// HandleDeleteContact removes a contact of the authenticated user.
+func (h *ContactHandler) HandleDeleteContact(c *gin.Context) {
+	contactId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.Contacts.RemoveContact(c.GetInt("userId"), contactId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleOpenDirect returns the direct conversation with a user.
+func (h *ContactHandler) HandleOpenDirect(c *gin.Context) {
+	peerId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	group, err := h.Contacts.OpenDirect(c.GetInt("userId"), peerId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// HandleUpdateSettings changes the contact privacy settings of the authenticated user.
+func (h *ContactHandler) HandleUpdateSettings(c *gin.Context) {
+	var params struct {
+		ContactsOnly *bool `json:"contacts_only" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	userId := c.GetInt("userId")
+	if err := h.Contacts.SetContactsOnly(userId, *params.ContactsOnly); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	profile, err := h.Contacts.GetProfile(userId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// HandleGetRequests lists pending contact requests sent or received by the authenticated user.
+func (h *ContactHandler) HandleGetRequests(c *gin.Context) {
+	reqs, err := h.Contacts.ListContactRequests(c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reqs)
+}
+
+// HandleSendRequest sends a contact request to another user.
+func (h *ContactHandler) HandleSendRequest(c *gin.Context) {
+	var params struct {
+		UserId int `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	req, err := h.Contacts.SendContactRequest(c.GetInt("userId"), params.UserId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// HandleAcceptRequest accepts a received contact request.
+func (h *ContactHandler) HandleAcceptRequest(c *gin.Context) {
+	requestId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	req, err := h.Contacts.AcceptContactRequest(c.GetInt("userId"), requestId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// HandleRejectRequest rejects a received contact request or withdraws a sent one.
+func (h *ContactHandler) HandleRejectRequest(c *gin.Context) {
+	requestId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	req, err := h.Contacts.RejectContactRequest(c.GetInt("userId"), requestId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// HandleGetBlocks lists the users blocked by the authenticated user.
+func (h *ContactHandler) HandleGetBlocks(c *gin.Context) {
+	blocks, err := h.Contacts.ListBlocks(c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, blocks)
+}
+
+// HandleBlock blocks a user.
+func (h *ContactHandler) HandleBlock(c *gin.Context) {
+	targetId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	block, err := h.Contacts.Block(c.GetInt("userId"), targetId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, block)
+}
+
+// HandleUnblock removes a block.
+func (h *ContactHandler) HandleUnblock(c *gin.Context) {
+	targetId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.Contacts.Unblock(c.GetInt("userId"), targetId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/gin-gonic/gin"
+)
+
+// errorStatus maps store errors to the HTTP status returned to the client.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrUnauthorized), errors.Is(err, store.ErrExpired):
+		return http.StatusUnauthorized
+	case errors.Is(err, store.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, store.ErrTooManyRequests):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// abortWithError writes err as a JSON error response.
+func abortWithError(c *gin.Context, err error) {
+	code := errorStatus(err)
+	c.AbortWithStatusJSON(code, gin.H{
+		"code":    code,
+		"message": err.Error(),
+	})
+}
+
+// abortWithBadRequest writes a 400 response for malformed input.
+func abortWithBadRequest(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+		"code":    http.StatusBadRequest,
+		"message": message,
+	})
+}
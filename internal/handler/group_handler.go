@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/elug3/gochat/pkg/model"
 	"github.com/elug3/gochat/pkg/service"
 	"github.com/gin-gonic/gin"
 )
@@ -19,14 +23,19 @@ func NewGroupHandler(contacts *service.ContactsService) (*GroupHandler, error) {
 
 // parseGroupId extracts and validates the group ID from the request context.
 func parseGroupId(c *gin.Context) (int, error) {
-	groupId, err := strconv.Atoi(c.Param("id"))
+	return parseIdParam(c, "id")
+}
+
+// parseIdParam extracts and validates a positive integer path parameter.
+func parseIdParam(c *gin.Context, name string) (int, error) {
+	id, err := strconv.Atoi(c.Param(name))
 	if err != nil {
 		return 0, err
 	}
-	if groupId <= 0 {
-		return 0, fmt.Errorf("invalid group ID: %d", groupId)
+	if id <= 0 {
+		return 0, fmt.Errorf("invalid %s: %d", name, id)
 	}
-	return groupId, nil
+	return id, nil
 }
 
 // HandleGetGroups retrieves all groups for the authenticated user.
@@ -93,3 +102,278 @@ func (h *GroupHandler) HandleCreateGroup(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, group)
 }
+
+// HandleUpdateGroup changes the name, description, avatar or settings of a
+// group.
+func (h *GroupHandler) HandleUpdateGroup(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params service.GroupUpdate
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	group, err := h.Contacts.UpdateGroup(groupId, c.GetInt("userId"), params)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// HandleMute suppresses notifications of a group for the authenticated user.
+func (h *GroupHandler) HandleMute(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		Until *time.Time `json:"until"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil && !errors.Is(err, io.EOF) {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	mute, err := h.Contacts.Mute(c.GetInt("userId"), groupId, params.Until)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, mute)
+}
+
+// HandleUnmute restores notifications of a group for the authenticated user.
+func (h *GroupHandler) HandleUnmute(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	if err = h.Contacts.Unmute(c.GetInt("userId"), groupId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleGetMembers lists the members of a group of the authenticated user.
+func (h *GroupHandler) HandleGetMembers(c *gin.Context) {
+	userId := c.GetInt("userId")
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	if _, err = h.Contacts.GetGroup(groupId, userId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	members, err := h.Contacts.ListMember(groupId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// HandleAddMember adds a user to a group.
+func (h *GroupHandler) HandleAddMember(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		UserId int `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	member, err := h.Contacts.Invite(groupId, c.GetInt("userId"), params.UserId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, member)
+}
+
+// HandleDeleteMember removes a member from a group, or leaves the group if
+// the member is the authenticated user.
+func (h *GroupHandler) HandleDeleteMember(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	targetId, err := parseIdParam(c, "userId")
+	if err != nil {
+		abortWithBadRequest(c, "invalid user ID")
+		return
+	}
+	if err = h.Contacts.DeleteMember(groupId, c.GetInt("userId"), targetId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleSetRole changes the role of a member of a group.
+func (h *GroupHandler) HandleSetRole(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	targetId, err := parseIdParam(c, "userId")
+	if err != nil {
+		abortWithBadRequest(c, "invalid user ID")
+		return
+	}
+	var params struct {
+		Role model.Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	member, err := h.Contacts.SetRole(groupId, c.GetInt("userId"), targetId, params.Role)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// HandleRestrict revokes the permission of a member to post in a group.
+func (h *GroupHandler) HandleRestrict(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	targetId, err := parseIdParam(c, "userId")
+	if err != nil {
+		abortWithBadRequest(c, "invalid user ID")
+		return
+	}
+	var params struct {
+		Until *time.Time `json:"until"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil && !errors.Is(err, io.EOF) {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	restriction, err := h.Contacts.Restrict(groupId, c.GetInt("userId"), targetId, params.Until)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, restriction)
+}
+
+// HandleUnrestrict lets a restricted member post in a group again.
+func (h *GroupHandler) HandleUnrestrict(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	targetId, err := parseIdParam(c, "userId")
+	if err != nil {
+		abortWithBadRequest(c, "invalid user ID")
+		return
+	}
+	if err = h.Contacts.Unrestrict(groupId, c.GetInt("userId"), targetId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleDiscover searches public groups.
+func (h *GroupHandler) HandleDiscover(c *gin.Context) {
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			abortWithBadRequest(c, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	groups, err := h.Contacts.Discover(c.Query("q"), limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// HandleJoin joins a public group, or files a join request if the group
+// requires approval.
+func (h *GroupHandler) HandleJoin(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	member, req, err := h.Contacts.Join(groupId, c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if req != nil {
+		c.JSON(http.StatusAccepted, req)
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// HandleGetJoinRequests lists the pending requests to join a group.
+func (h *GroupHandler) HandleGetJoinRequests(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	reqs, err := h.Contacts.JoinRequests(groupId, c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reqs)
+}
+
+// HandleApproveJoinRequest adds the requester to the group.
+func (h *GroupHandler) HandleApproveJoinRequest(c *gin.Context) {
+	h.decideJoinRequest(c, true)
+}
+
+// HandleDenyJoinRequest rejects a request to join the group.
+func (h *GroupHandler) HandleDenyJoinRequest(c *gin.Context) {
+	h.decideJoinRequest(c, false)
+}
+
+func (h *GroupHandler) decideJoinRequest(c *gin.Context, approve bool) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	requestId, err := parseIdParam(c, "requestId")
+	if err != nil {
+		abortWithBadRequest(c, "invalid request ID")
+		return
+	}
+	req, err := h.Contacts.DecideJoinRequest(groupId, c.GetInt("userId"), requestId, approve)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
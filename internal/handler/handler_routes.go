@@ -1,48 +1,263 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/elug3/gochat/pkg/model"
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(userHandler *UserHandler, authHandler *AuthHandler, contactsHandler *GroupHandler) *gin.Engine {
+func SetupRoutes(userHandler *UserHandler, authHandler *AuthHandler, oidcHandler *OIDCHandler, botHandler *BotHandler, contactsHandler *GroupHandler, contactHandler *ContactHandler, messageHandler *MessageHandler, webhookHandler *WebhookHandler, commandHandler *CommandHandler, pollHandler *PollHandler, scheduleHandler *ScheduleHandler, accountHandler *AccountHandler) *gin.Engine {
 	r := gin.Default()
+	r.GET("/.well-known/jwks.json", authHandler.HandleJWKS)
 	v1 := r.Group("/api/v1")
 	v1.Use(AuthMiddleware(userHandler.userService))
+	v1.Use(presenceMiddleware(contactHandler.Contacts))
 	{
 		addRoutes(v1, "/users", usersRoutes(userHandler))
+		addRoutes(v1, "/users/me", accountRoutes(accountHandler), authRequired)
 		addRoutes(v1, "/auth", authRoutes(authHandler))
+		addRoutes(v1, "/auth/oidc", oidcRoutes(oidcHandler))
+		addRoutes(v1, "/bots", botRoutes(botHandler), authRequired)
 		addRoutes(v1, "/groups", groupRoutes(contactsHandler), authRequired)
+		addRoutes(v1, "/contacts", contactRoutes(contactHandler), authRequired)
+		addRoutes(v1, "/blocks", blockRoutes(contactHandler), authRequired)
+		addRoutes(v1, "/groups/:id/messages", messageRoutes(messageHandler))
+		addRoutes(v1, "/groups/:id/webhooks", webhookRoutes(webhookHandler), authRequired)
+		addRoutes(v1, "/groups/:id/incoming-webhooks", incomingWebhookRoutes(webhookHandler), authRequired)
+		addRoutes(v1, "/groups/:id/commands", commandRoutes(commandHandler), authRequired)
+		addRoutes(v1, "/groups/:id/polls", pollRoutes(pollHandler))
+		addRoutes(v1, "/scheduled", scheduleRoutes(scheduleHandler), authRequired)
+		v1.GET("/reminders", authRequired, scheduleHandler.HandleGetReminders)
+		v1.DELETE("/reminders/:id", authRequired, scheduleHandler.HandleDismissReminder)
+		v1.POST("/groups/:id/scheduled", authRequired, scheduleHandler.HandleScheduleMessage)
+		v1.POST("/groups/:id/messages/:messageId/reminders", authRequired, scheduleHandler.HandleRemind)
+		v1.PUT("/groups/:id/retention", authRequired, messageHandler.HandleSetRetention)
+		v1.POST("/hooks/:token", webhookHandler.HandlePostIncoming)
+		v1.GET("/events", authRequired, messageHandler.HandleEvents)
 	}
 
 	return r
 }
 
+// SetupAuthRoutes serves only the authentication routes, for running the auth
+// service on its own behind a reverse proxy.
+func SetupAuthRoutes(authHandler *AuthHandler, oidcHandler *OIDCHandler) *gin.Engine {
+	r := gin.Default()
+	r.GET("/.well-known/jwks.json", authHandler.HandleJWKS)
+	// the forward-auth address of the reverse proxy
+	r.GET("/auth/verify", AuthMiddleware(authHandler.users), authRequired, authHandler.HandleVerify)
+	v1 := r.Group("/api/v1")
+	v1.Use(AuthMiddleware(authHandler.users))
+	{
+		addRoutes(v1, "/auth", authRoutes(authHandler))
+		addRoutes(v1, "/auth/oidc", oidcRoutes(oidcHandler))
+	}
+
+	return r
+}
+
+// authRequired rejects requests without a valid token. API keys are only
+// accepted by routes guarded with scopeRequired.
 func authRequired(c *gin.Context) {
-	if _, exists := c.Get("userId"); !exists {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"code":    http.StatusUnauthorized,
-			"message": "Unauthorized",
+	if !authenticated(c) {
+		return
+	}
+	if _, isKey := c.Get("apiKey"); isKey {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "api keys cannot access this resource",
 		})
 		return
 	}
 	c.Next()
 }
 
+// scopeRequired accepts user tokens and API keys that grant the scope on the
+// group of the id path parameter.
+func scopeRequired(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticated(c) {
+			return
+		}
+		if v, isKey := c.Get("apiKey"); isKey {
+			groupId, _ := strconv.Atoi(c.Param("id"))
+			if key, ok := v.(*model.APIKey); !ok || !key.Allows(scope, groupId) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"code":    http.StatusForbidden,
+					"message": fmt.Sprintf("api key lacks scope %q for this group", scope),
+				})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// authenticated aborts the request with 401 if it carries no valid token.
+func authenticated(c *gin.Context) bool {
+	if _, exists := c.Get("userId"); exists {
+		return true
+	}
+	errCode := authErrorCode(c)
+	if errCode != "missing_token" {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, errCode))
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"code":    http.StatusUnauthorized,
+		"message": "Unauthorized",
+		"error":   errCode,
+	})
+	return false
+}
+
 func groupRoutes(h *GroupHandler) func(gin.IRouter) {
 	return func(r gin.IRouter) {
 		r.POST("", h.HandleCreateGroup)
 		r.GET("", h.HandleGetGroups)
+		r.GET("discover", h.HandleDiscover)
 		r.GET(":id", h.HandleGetGroup)
+		r.PATCH(":id", h.HandleUpdateGroup)
+		r.PUT(":id/mute", h.HandleMute)
+		r.DELETE(":id/mute", h.HandleUnmute)
+		r.GET(":id/members", h.HandleGetMembers)
+		r.POST(":id/members", h.HandleAddMember)
+		r.DELETE(":id/members/:userId", h.HandleDeleteMember)
+		r.PUT(":id/members/:userId/role", h.HandleSetRole)
+		r.PUT(":id/members/:userId/restriction", h.HandleRestrict)
+		r.DELETE(":id/members/:userId/restriction", h.HandleUnrestrict)
+		r.POST(":id/join", h.HandleJoin)
+		r.GET(":id/join-requests", h.HandleGetJoinRequests)
+		r.POST(":id/join-requests/:requestId/approve", h.HandleApproveJoinRequest)
+		r.POST(":id/join-requests/:requestId/deny", h.HandleDenyJoinRequest)
+	}
+}
+
+func messageRoutes(h *MessageHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.GET("", scopeRequired(model.ScopeMessagesRead), h.HandleGetMessages)
+		r.POST("", scopeRequired(model.ScopeMessagesWrite), h.HandlePostMessage)
+		r.PATCH("/:messageId", scopeRequired(model.ScopeMessagesWrite), h.HandleEditMessage)
+		r.DELETE("/:messageId", scopeRequired(model.ScopeMessagesWrite), h.HandleDeleteMessage)
+		r.GET("/pinned", scopeRequired(model.ScopeMessagesRead), h.HandleGetPinned)
+		r.PUT("/:messageId/pin", scopeRequired(model.ScopeMessagesWrite), h.HandlePin)
+		r.DELETE("/:messageId/pin", scopeRequired(model.ScopeMessagesWrite), h.HandleUnpin)
+	}
+}
+
+func pollRoutes(h *PollHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.POST("", scopeRequired(model.ScopeMessagesWrite), h.HandleCreatePoll)
+		r.GET("/:pollId", scopeRequired(model.ScopeMessagesRead), h.HandleGetPoll)
+		r.POST("/:pollId/close", scopeRequired(model.ScopeMessagesWrite), h.HandleClosePoll)
+		r.PUT("/:pollId/votes/:optionId", scopeRequired(model.ScopeMessagesWrite), h.HandleVote)
+		r.DELETE("/:pollId/votes/:optionId", scopeRequired(model.ScopeMessagesWrite), h.HandleUnvote)
+	}
+}
+
+func scheduleRoutes(h *ScheduleHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.GET("", h.HandleGetScheduled)
+		r.PATCH("/:id", h.HandleUpdateScheduled)
+		r.DELETE("/:id", h.HandleCancelScheduled)
+	}
+}
+
+func webhookRoutes(h *WebhookHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.POST("", h.HandleCreateWebhook)
+		r.GET("", h.HandleGetWebhooks)
+		r.PATCH("/:webhookId", h.HandleUpdateWebhook)
+		r.DELETE("/:webhookId", h.HandleDeleteWebhook)
+		r.GET("/:webhookId/deliveries", h.HandleGetDeliveries)
+	}
+}
+
+func incomingWebhookRoutes(h *WebhookHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.POST("", h.HandleCreateIncoming)
+		r.GET("", h.HandleGetIncoming)
+		r.POST("/:webhookId/rotate", h.HandleRotateIncoming)
+		r.DELETE("/:webhookId", h.HandleRevokeIncoming)
+	}
+}
+
+func commandRoutes(h *CommandHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.GET("", h.HandleGetCommands)
+		r.POST("", h.HandleCreateCommand)
+		r.DELETE("/:commandId", h.HandleDeleteCommand)
+	}
+}
+
+func botRoutes(h *BotHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.POST("", h.HandleCreateBot)
+		r.GET("", h.HandleGetBots)
+		r.DELETE("/:id", h.HandleDeleteBot)
+		r.POST("/:id/keys", h.HandleCreateAPIKey)
+		r.GET("/:id/keys", h.HandleGetAPIKeys)
+		r.DELETE("/:id/keys/:keyId", h.HandleRevokeAPIKey)
+	}
+}
+
+func blockRoutes(h *ContactHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.GET("", h.HandleGetBlocks)
+		r.PUT("/:id", h.HandleBlock)
+		r.DELETE("/:id", h.HandleUnblock)
+	}
+}
+
+func contactRoutes(h *ContactHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.GET("", h.HandleGetContacts)
+		r.PUT("/settings", h.HandleUpdateSettings)
+		r.GET("/requests", h.HandleGetRequests)
+		r.POST("/requests", h.HandleSendRequest)
+		r.POST("/requests/:id/accept", h.HandleAcceptRequest)
+		r.POST("/requests/:id/reject", h.HandleRejectRequest)
+		r.DELETE("/:id", h.HandleDeleteContact)
+		r.POST("/:id/direct", h.HandleOpenDirect)
 	}
 }
 
 func authRoutes(h *AuthHandler) func(gin.IRouter) {
 	return func(r gin.IRouter) {
 		r.POST("/login", h.HandleLogin)
+		r.POST("/login/2fa", h.HandleCompleteLogin)
+		r.POST("/refresh", h.HandleRefresh)
+		r.GET("/verify", authRequired, h.HandleVerify)
+		r.POST("/logout", authRequired, h.HandleLogout)
+		r.GET("/sessions", authRequired, h.HandleGetAuthorizations)
+		r.DELETE("/sessions", authRequired, h.HandleRevokeOtherAuthorizations)
+		r.DELETE("/sessions/:id", authRequired, h.HandleRevokeAuthorization)
+		r.GET("/failed-logins", authRequired, h.HandleGetFailedLogins)
+		r.PUT("/password", authRequired, h.HandleChangePassword)
+		r.POST("/password/reset-request", h.HandleRequestPasswordReset)
+		r.POST("/password/reset", h.HandleResetPassword)
+		r.POST("/2fa/totp", authRequired, h.HandleEnrollTOTP)
+		r.POST("/2fa/totp/activate", authRequired, h.HandleActivateTOTP)
+		r.DELETE("/2fa/totp", authRequired, h.HandleDisableTOTP)
+		r.POST("/2fa/recovery-codes", authRequired, h.HandleRegenerateRecoveryCodes)
 	}
 }
+func oidcRoutes(h *OIDCHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.GET("/login", h.HandleLogin)
+		r.GET("/callback", h.HandleCallback)
+	}
+}
+
+func accountRoutes(h *AccountHandler) func(gin.IRouter) {
+	return func(r gin.IRouter) {
+		r.DELETE("", h.HandleDeleteAccount)
+		r.GET("/export", h.HandleExport)
+	}
+}
+
 func usersRoutes(h *UserHandler) func(gin.IRouter) {
 	return func(r gin.IRouter) {
 		r.POST("", h.HandleCreateUser)
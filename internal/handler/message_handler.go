@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+)
+
+type MessageHandler struct {
+	Messages *service.MessageService
+	Commands *service.CommandService
+}
+
+func NewMessageHandler(messages *service.MessageService, commands *service.CommandService) (*MessageHandler, error) {
+	return &MessageHandler{Messages: messages, Commands: commands}, nil
+}
+
+// HandleGetMessages lists the latest messages of a group.
+func (h *MessageHandler) HandleGetMessages(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	msgs, err := h.Messages.List(c.GetInt("userId"), groupId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msgs)
+}
+
+// HandlePostMessage sends a message to a group. Messages starting with "/"
+// run a command and respond with its reply instead.
+func (h *MessageHandler) HandlePostMessage(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	if name, text, ok := service.ParseCommand(params.Content); ok && h.Commands != nil {
+		resp, err := h.Commands.Execute(c.Request.Context(), c.GetInt("userId"), groupId, name, text)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	content := params.Content
+	if strings.HasPrefix(content, "//") {
+		content = content[1:]
+	}
+	msg, err := h.Messages.Send(c.GetInt("userId"), service.SendParams{
+		ChatId:  groupId,
+		Content: content,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+// HandleEditMessage replaces the content of a message sent by the authenticated user.
+func (h *MessageHandler) HandleEditMessage(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	msg, err := h.Messages.Edit(c.GetInt("userId"), groupId, c.Param("messageId"), params.Content)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+// HandleDeleteMessage deletes a message sent by the authenticated user.
+func (h *MessageHandler) HandleDeleteMessage(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	if err = h.Messages.Delete(c.GetInt("userId"), groupId, c.Param("messageId")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleGetPinned lists the pinned messages of a group.
+func (h *MessageHandler) HandleGetPinned(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	msgs, err := h.Messages.ListPinned(c.GetInt("userId"), groupId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msgs)
+}
+
+// HandlePin pins a message of a group.
+func (h *MessageHandler) HandlePin(c *gin.Context) {
+	h.handlePin(c, h.Messages.Pin)
+}
+
+// HandleUnpin unpins a message of a group.
+func (h *MessageHandler) HandleUnpin(c *gin.Context) {
+	h.handlePin(c, h.Messages.Unpin)
+}
+
+func (h *MessageHandler) handlePin(c *gin.Context, fn func(userId, groupId int, messageId string) (*model.Message, error)) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	msg, err := fn(c.GetInt("userId"), groupId, c.Param("messageId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+// HandleSetRetention changes after how many seconds new messages of a group
+// disappear. A retention of 0 keeps them forever.
+func (h *MessageHandler) HandleSetRetention(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		Retention *int `json:"retention" binding:"required"`
+	}
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	retention := time.Duration(*params.Retention) * time.Second
+	group, err := h.Messages.SetRetention(c.GetInt("userId"), groupId, retention)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// HandleEvents streams feed events of the authenticated user as server-sent
+// events. The stream ends when the client falls behind, so it reconnects.
+func (h *MessageHandler) HandleEvents(c *gin.Context) {
+	ctx := c.Request.Context()
+	events := make(chan model.ChatEvent, 16)
+
+	stopped := h.Messages.Subscribe(ctx, c.GetInt("userId"), func(e model.ChatEvent) error {
+		select {
+		case events <- e:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e := <-events:
+			c.SSEvent(e.Type, e)
+			return true
+		case <-stopped:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
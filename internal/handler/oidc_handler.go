@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	users *service.UserService
+}
+
+func NewOIDCHandler(users *service.UserService) (*OIDCHandler, error) {
+	return &OIDCHandler{users: users}, nil
+}
+
+// HandleLogin redirects to the login page of the identity provider.
+func (h *OIDCHandler) HandleLogin(c *gin.Context) {
+	authURL, err := h.users.BeginOIDC(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleCallback completes the login when the provider redirects back.
+func (h *OIDCHandler) HandleCallback(c *gin.Context) {
+	if errCode := c.Query("error"); errCode != "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": "Unauthorized",
+			"error":   errCode,
+		})
+		return
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		abortWithBadRequest(c, "missing state or code")
+		return
+	}
+	login, err := h.users.CompleteOIDC(c.Request.Context(), state, code, requestDevice(c))
+	var challenge *service.ChallengeError
+	if errors.As(err, &challenge) {
+		c.JSON(http.StatusOK, gin.H{"challenge": challenge.Challenge})
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": login.Token})
+}
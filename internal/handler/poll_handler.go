@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+)
+
+type PollHandler struct {
+	Polls *service.PollService
+}
+
+func NewPollHandler(polls *service.PollService) (*PollHandler, error) {
+	return &PollHandler{Polls: polls}, nil
+}
+
+// HandleCreatePoll posts a poll to a group.
+func (h *PollHandler) HandleCreatePoll(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params service.PollParams
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	poll, err := h.Polls.Create(c.GetInt("userId"), groupId, params)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, poll)
+}
+
+// HandleGetPoll returns a poll with its current tally.
+func (h *PollHandler) HandleGetPoll(c *gin.Context) {
+	h.handlePoll(c, h.Polls.Get)
+}
+
+// HandleClosePoll closes a poll to further votes.
+func (h *PollHandler) HandleClosePoll(c *gin.Context) {
+	h.handlePoll(c, h.Polls.Close)
+}
+
+// HandleVote votes for an option of a poll.
+func (h *PollHandler) HandleVote(c *gin.Context) {
+	h.handleVote(c, h.Polls.Vote)
+}
+
+// HandleUnvote withdraws a vote for an option of a poll.
+func (h *PollHandler) HandleUnvote(c *gin.Context) {
+	h.handleVote(c, h.Polls.Unvote)
+}
+
+func (h *PollHandler) handlePoll(c *gin.Context, fn func(userId, groupId, pollId int) (*model.Poll, error)) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	pollId, err := parseIdParam(c, "pollId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	poll, err := fn(c.GetInt("userId"), groupId, pollId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, poll)
+}
+
+func (h *PollHandler) handleVote(c *gin.Context, fn func(userId, groupId, pollId, optionId int) (*model.Poll, error)) {
+	optionId, err := parseIdParam(c, "optionId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	h.handlePoll(c, func(userId, groupId, pollId int) (*model.Poll, error) {
+		return fn(userId, groupId, pollId, optionId)
+	})
+}
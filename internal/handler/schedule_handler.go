@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+)
+
+type ScheduleHandler struct {
+	Schedules *service.ScheduleService
+}
+
+func NewScheduleHandler(schedules *service.ScheduleService) (*ScheduleHandler, error) {
+	return &ScheduleHandler{Schedules: schedules}, nil
+}
+
+// HandleScheduleMessage schedules a message to a group.
+func (h *ScheduleHandler) HandleScheduleMessage(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		Content string    `json:"content" binding:"required"`
+		SendAt  time.Time `json:"send_at" binding:"required"`
+	}
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	job, err := h.Schedules.ScheduleMessage(c.GetInt("userId"), groupId, params.Content, params.SendAt)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// HandleRemind sets a reminder on a message of a group.
+func (h *ScheduleHandler) HandleRemind(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		Note     string    `json:"note"`
+		RemindAt time.Time `json:"remind_at" binding:"required"`
+	}
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	job, err := h.Schedules.Remind(c.GetInt("userId"), groupId, c.Param("messageId"), params.Note, params.RemindAt)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// HandleGetScheduled lists the pending scheduled messages and reminders of
+// the authenticated user.
+func (h *ScheduleHandler) HandleGetScheduled(c *gin.Context) {
+	jobs, err := h.Schedules.List(c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// HandleUpdateScheduled changes the content or time of a pending job.
+func (h *ScheduleHandler) HandleUpdateScheduled(c *gin.Context) {
+	jobId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	var params service.JobUpdate
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	job, err := h.Schedules.Update(c.GetInt("userId"), jobId, params)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// HandleCancelScheduled cancels a pending job.
+func (h *ScheduleHandler) HandleCancelScheduled(c *gin.Context) {
+	jobId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.Schedules.Cancel(c.GetInt("userId"), jobId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleGetReminders lists the due reminders of the authenticated user that
+// were not dismissed.
+func (h *ScheduleHandler) HandleGetReminders(c *gin.Context) {
+	reminders, err := h.Schedules.Reminders(c.GetInt("userId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reminders)
+}
+
+// HandleDismissReminder dismisses a due reminder.
+func (h *ScheduleHandler) HandleDismissReminder(c *gin.Context) {
+	jobId, err := parseIdParam(c, "id")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.Schedules.Dismiss(c.GetInt("userId"), jobId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -31,10 +31,7 @@ func (h *UserHandler) HandleCreateUser(c *gin.Context) {
 
 	user, err := h.userService.Register(c.Request.Context(), params.Username, params.Password)
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{
-			"code":    http.StatusInternalServerError,
-			"message": err.Error(),
-		})
+		abortWithError(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, user)
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/elug3/gochat/pkg/service"
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	Webhooks *service.WebhookService
+}
+
+func NewWebhookHandler(webhooks *service.WebhookService) (*WebhookHandler, error) {
+	return &WebhookHandler{Webhooks: webhooks}, nil
+}
+
+// HandleCreateWebhook registers a webhook for a group. The signing secret is
+// only shown in this response.
+func (h *WebhookHandler) HandleCreateWebhook(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		URL    string   `json:"url" binding:"required"`
+		Events []string `json:"events"`
+	}
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	webhook, err := h.Webhooks.CreateWebhook(c.GetInt("userId"), groupId, params.URL, params.Events)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+// HandleGetWebhooks lists the webhooks of a group.
+func (h *WebhookHandler) HandleGetWebhooks(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	webhooks, err := h.Webhooks.ListWebhooks(c.GetInt("userId"), groupId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// HandleUpdateWebhook disables or re-enables a webhook.
+func (h *WebhookHandler) HandleUpdateWebhook(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	webhookId, err := parseIdParam(c, "webhookId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	var params struct {
+		Disabled *bool `json:"disabled" binding:"required"`
+	}
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	webhook, err := h.Webhooks.SetDisabled(c.GetInt("userId"), groupId, webhookId, *params.Disabled)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+// HandleDeleteWebhook deletes a webhook with its delivery log.
+func (h *WebhookHandler) HandleDeleteWebhook(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	webhookId, err := parseIdParam(c, "webhookId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.Webhooks.DeleteWebhook(c.GetInt("userId"), groupId, webhookId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleGetDeliveries lists the latest deliveries of a webhook.
+func (h *WebhookHandler) HandleGetDeliveries(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	webhookId, err := parseIdParam(c, "webhookId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	deliveries, err := h.Webhooks.ListDeliveries(c.GetInt("userId"), groupId, webhookId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// HandleCreateIncoming creates an incoming webhook for a group. The token is
+// only shown in this response.
+func (h *WebhookHandler) HandleCreateIncoming(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	var params struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err = c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	webhook, err := h.Webhooks.CreateIncoming(c.GetInt("userId"), groupId, params.Name)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+// HandleGetIncoming lists the incoming webhooks of a group.
+func (h *WebhookHandler) HandleGetIncoming(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	webhooks, err := h.Webhooks.ListIncoming(c.GetInt("userId"), groupId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// HandleRotateIncoming replaces the token of an incoming webhook.
+func (h *WebhookHandler) HandleRotateIncoming(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	webhookId, err := parseIdParam(c, "webhookId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	webhook, err := h.Webhooks.RotateIncoming(c.GetInt("userId"), groupId, webhookId)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+// HandleRevokeIncoming deletes an incoming webhook.
+func (h *WebhookHandler) HandleRevokeIncoming(c *gin.Context) {
+	groupId, err := parseGroupId(c)
+	if err != nil {
+		abortWithBadRequest(c, "invalid group ID")
+		return
+	}
+	webhookId, err := parseIdParam(c, "webhookId")
+	if err != nil {
+		abortWithBadRequest(c, err.Error())
+		return
+	}
+	if err = h.Webhooks.RevokeIncoming(c.GetInt("userId"), groupId, webhookId); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandlePostIncoming posts a message through an incoming webhook. The token
+// in the URL authorizes the request.
+func (h *WebhookHandler) HandlePostIncoming(c *gin.Context) {
+	var params service.IncomingParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		abortWithBadRequest(c, "invalid request")
+		return
+	}
+	msg, err := h.Webhooks.PostIncoming(c.Param("token"), params)
+	if err != nil {
+		var limited *service.RateLimitError
+		if errors.As(err, &limited) {
+			abortTooManyRequests(c, limited.RetryAfter, "Too many messages")
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
@@ -1,19 +1,25 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 
-	"github.com/elug3/gochat/internal/config"
 	"github.com/elug3/gochat/internal/handler"
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/notify"
 	"github.com/elug3/gochat/pkg/service"
 	cstore "github.com/elug3/gochat/pkg/store/contacts/sqlite"
+	mstore "github.com/elug3/gochat/pkg/store/message/sqlite"
 	ustore "github.com/elug3/gochat/pkg/store/user/sqlite"
 )
 
-func SetupServer(cfg *config.Config) (*http.Server, error) {
-	addr := net.JoinHostPort("localhost", fmt.Sprintf("%d", cfg.Port))
+// SetupServer wires the stores, services and handlers. Background jobs run
+// until ctx is done.
+func SetupServer(ctx context.Context, cfg *config.Config) (*http.Server, error) {
+	addr := net.JoinHostPort(cfg.Host, fmt.Sprintf("%d", cfg.Port))
 	// saveDir := cfg.SaveDir
 
 	// store
@@ -25,17 +31,74 @@ func SetupServer(cfg *config.Config) (*http.Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	messageStore, err := mstore.NewMessageStore(cfg)
+	if err != nil {
+		return nil, err
+	}
 	// event
+	events := event.NewEventHandler()
 
 	// service
-	userService, err := service.NewUserService(userStore)
+	notifier, err := notify.New(cfg.Notifier)
+	if err != nil {
+		return nil, err
+	}
+	userService, err := service.NewUserService(userStore, cfg.Auth, notifier)
 	if err != nil {
 		return nil, fmt.Errorf("NewUserService: %w", err)
 	}
-	contactsService, err := service.NewContactsService(contactsStore)
+	go userService.RunSweeper(ctx, cfg.Auth.SweepInterval)
+	contactsService, err := service.NewContactsService(contactsStore, events)
 	if err != nil {
 		return nil, fmt.Errorf("NewContactsService: %w", err)
 	}
+	userService.SetProfileCreator(contactsService)
+	messageService, err := service.NewMessageService(messageStore, contactsService, events)
+	if err != nil {
+		return nil, fmt.Errorf("NewMessageService: %w", err)
+	}
+	if err = messageService.Start(ctx); err != nil {
+		return nil, fmt.Errorf("messageService.Start: %w", err)
+	}
+	webhookService, err := service.NewWebhookService(contactsStore, messageService, events, cfg.Webhook)
+	if err != nil {
+		return nil, fmt.Errorf("NewWebhookService: %w", err)
+	}
+	if err = webhookService.Start(ctx); err != nil {
+		return nil, fmt.Errorf("webhookService.Start: %w", err)
+	}
+	commandService, err := service.NewCommandService(contactsStore, messageService, events, cfg.Command)
+	if err != nil {
+		return nil, fmt.Errorf("NewCommandService: %w", err)
+	}
+	pollService, err := service.NewPollService(contactsStore, messageService, events)
+	if err != nil {
+		return nil, fmt.Errorf("NewPollService: %w", err)
+	}
+	scheduleService, err := service.NewScheduleService(contactsStore, messageService, events, cfg.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("NewScheduleService: %w", err)
+	}
+	if err = commandService.RegisterPolls(pollService); err != nil {
+		return nil, fmt.Errorf("RegisterPolls: %w", err)
+	}
+	if err = commandService.RegisterReminders(scheduleService); err != nil {
+		return nil, fmt.Errorf("RegisterReminders: %w", err)
+	}
+	if err = scheduleService.Start(ctx); err != nil {
+		return nil, fmt.Errorf("scheduleService.Start: %w", err)
+	}
+	retentionService, err := service.NewRetentionService(messageService, userStore, cfg.Retention)
+	if err != nil {
+		return nil, fmt.Errorf("NewRetentionService: %w", err)
+	}
+	if err = retentionService.Start(ctx); err != nil {
+		return nil, fmt.Errorf("retentionService.Start: %w", err)
+	}
+	accountService, err := service.NewAccountService(userStore, messageService)
+	if err != nil {
+		return nil, fmt.Errorf("NewAccountService: %w", err)
+	}
 
 	userHandler, err := handler.NewUserHandler(userService)
 	if err != nil {
@@ -50,11 +113,59 @@ func SetupServer(cfg *config.Config) (*http.Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("NewContactsHandler: %w", err)
 	}
+	contactHandler, err := handler.NewContactHandler(contactsService)
+	if err != nil {
+		return nil, fmt.Errorf("NewContactHandler: %w", err)
+	}
+	messageHandler, err := handler.NewMessageHandler(messageService, commandService)
+	if err != nil {
+		return nil, fmt.Errorf("NewMessageHandler: %w", err)
+	}
+	oidcHandler, err := handler.NewOIDCHandler(userService)
+	if err != nil {
+		return nil, fmt.Errorf("NewOIDCHandler: %w", err)
+	}
+	botHandler, err := handler.NewBotHandler(userService, contactsService)
+	if err != nil {
+		return nil, fmt.Errorf("NewBotHandler: %w", err)
+	}
+	webhookHandler, err := handler.NewWebhookHandler(webhookService)
+	if err != nil {
+		return nil, fmt.Errorf("NewWebhookHandler: %w", err)
+	}
+	commandHandler, err := handler.NewCommandHandler(commandService)
+	if err != nil {
+		return nil, fmt.Errorf("NewCommandHandler: %w", err)
+	}
+	pollHandler, err := handler.NewPollHandler(pollService)
+	if err != nil {
+		return nil, fmt.Errorf("NewPollHandler: %w", err)
+	}
+	scheduleHandler, err := handler.NewScheduleHandler(scheduleService)
+	if err != nil {
+		return nil, fmt.Errorf("NewScheduleHandler: %w", err)
+	}
+	accountHandler, err := handler.NewAccountHandler(accountService)
+	if err != nil {
+		return nil, fmt.Errorf("NewAccountHandler: %w", err)
+	}
 	r := handler.SetupRoutes(
 		userHandler,
 		authHandler,
+		oidcHandler,
+		botHandler,
 		groupHandler,
+		contactHandler,
+		messageHandler,
+		webhookHandler,
+		commandHandler,
+		pollHandler,
+		scheduleHandler,
+		accountHandler,
 	)
+	if err = r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("SetTrustedProxies: %w", err)
+	}
 	{
 		// testing
 		contactsService.CreateProfile(1, "test")
@@ -67,3 +178,84 @@ func SetupServer(cfg *config.Config) (*http.Server, error) {
 
 	return srv, nil
 }
+
+// SetupAuthServer serves only the authentication routes, for deployments
+// where a reverse proxy asks it to verify the requests of other services.
+func SetupAuthServer(ctx context.Context, cfg *config.Config) (*http.Server, error) {
+	addr := net.JoinHostPort(cfg.Host, fmt.Sprintf("%d", cfg.Port))
+
+	userStore, err := ustore.NewUserStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := notify.New(cfg.Notifier)
+	if err != nil {
+		return nil, err
+	}
+	userService, err := service.NewUserService(userStore, cfg.Auth, notifier)
+	if err != nil {
+		return nil, fmt.Errorf("NewUserService: %w", err)
+	}
+	go userService.RunSweeper(ctx, cfg.Auth.SweepInterval)
+	// single sign-on users get their profile on the first login
+	contactsStore, err := cstore.NewContactsStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	contactsService, err := service.NewContactsService(contactsStore, event.NewEventHandler())
+	if err != nil {
+		return nil, fmt.Errorf("NewContactsService: %w", err)
+	}
+	userService.SetProfileCreator(contactsService)
+
+	authHandler, err := handler.NewAuthHandler(userService)
+	if err != nil {
+		return nil, fmt.Errorf("NewAuthHandler: %w", err)
+	}
+	oidcHandler, err := handler.NewOIDCHandler(userService)
+	if err != nil {
+		return nil, fmt.Errorf("NewOIDCHandler: %w", err)
+	}
+	r := handler.SetupAuthRoutes(authHandler, oidcHandler)
+	if err = r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("SetTrustedProxies: %w", err)
+	}
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+
+	return srv, nil
+}
+
+// SetupRetention wires the services needed to run the retention rules
+// outside of the server.
+func SetupRetention(cfg *config.Config) (*service.RetentionService, error) {
+	userStore, err := ustore.NewUserStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	contactsStore, err := cstore.NewContactsStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	messageStore, err := mstore.NewMessageStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	events := event.NewEventHandler()
+
+	contactsService, err := service.NewContactsService(contactsStore, events)
+	if err != nil {
+		return nil, fmt.Errorf("NewContactsService: %w", err)
+	}
+	messageService, err := service.NewMessageService(messageStore, contactsService, events)
+	if err != nil {
+		return nil, fmt.Errorf("NewMessageService: %w", err)
+	}
+	retentionService, err := service.NewRetentionService(messageService, userStore, cfg.Retention)
+	if err != nil {
+		return nil, fmt.Errorf("NewRetentionService: %w", err)
+	}
+	return retentionService, nil
+}
@@ -7,9 +7,19 @@ type ContactsAccess struct{}
 type Action string
 
 const (
-	ActionInvite       Action = "invite"
 	ActionDeleteMember Action = "delete"
 	ActionDeleteGroup  Action = "delete group"
+	ActionManageHooks  Action = "manage webhooks"
+	ActionIncomingHook Action = "manage incoming webhooks"
+	ActionManageCmds   Action = "manage commands"
+	ActionClosePoll    Action = "close poll"
+	ActionPin          Action = "pin messages"
+	ActionRetention    Action = "change message retention"
+	ActionSetRole      Action = "change member roles"
+	ActionEditGroup    Action = "edit group"
+	ActionSettings     Action = "change group settings"
+	ActionRestrict     Action = "restrict members"
+	ActionJoinRequests Action = "review join requests"
 )
 
 const (
@@ -18,6 +28,8 @@ const (
 	RoleOwner   model.Role = "owner"
 )
 
+// Policy allows the act role to take the action. A policy with a tgt role
+// only applies to members with that role.
 type Policy struct {
 	act    model.Role
 	tgt    model.Role
@@ -26,14 +38,51 @@ type Policy struct {
 
 var Policies = []Policy{
 	{act: RoleOwner, action: ActionDeleteGroup},
-	{act: RoleOwner, action: ActionInvite},
+	{act: RoleOwner, action: ActionManageHooks},
+	{act: RoleOwner, action: ActionIncomingHook},
+	{act: RoleManager, action: ActionIncomingHook},
+	{act: RoleOwner, action: ActionManageCmds},
+	{act: RoleManager, action: ActionManageCmds},
+	{act: RoleOwner, action: ActionClosePoll},
+	{act: RoleManager, action: ActionClosePoll},
+	{act: RoleOwner, action: ActionPin},
+	{act: RoleManager, action: ActionPin},
+	{act: RoleOwner, action: ActionRetention},
+	{act: RoleManager, action: ActionRetention},
+	{act: RoleOwner, tgt: RoleManager, action: ActionDeleteMember},
+	{act: RoleOwner, tgt: RoleMember, action: ActionDeleteMember},
+	{act: RoleManager, tgt: RoleMember, action: ActionDeleteMember},
+	{act: RoleOwner, tgt: RoleManager, action: ActionSetRole},
+	{act: RoleOwner, tgt: RoleMember, action: ActionSetRole},
+	{act: RoleOwner, action: ActionEditGroup},
+	{act: RoleManager, action: ActionEditGroup},
+	{act: RoleOwner, action: ActionSettings},
+	{act: RoleOwner, tgt: RoleManager, action: ActionRestrict},
+	{act: RoleOwner, tgt: RoleMember, action: ActionRestrict},
+	{act: RoleManager, tgt: RoleMember, action: ActionRestrict},
+	{act: RoleOwner, action: ActionJoinRequests},
+	{act: RoleManager, action: ActionJoinRequests},
 }
 
 func (access *ContactsAccess) Can(act, tgt model.Role, action Action) bool {
 	for _, policy := range Policies {
-		if policy.act == act && policy.action == action {
+		if policy.act == act && policy.action == action && (policy.tgt == "" || policy.tgt == tgt) {
 			return true
 		}
 	}
 	return false
 }
+
+// Permits reports whether the role has the permission set in the settings of
+// a group.
+func (access *ContactsAccess) Permits(perm model.Permission, role model.Role) bool {
+	switch perm {
+	case model.PermissionEveryone:
+		return true
+	case model.PermissionManagers:
+		return role == RoleManager || role == RoleOwner
+	case model.PermissionOwner:
+		return role == RoleOwner
+	}
+	return false
+}
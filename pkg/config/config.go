@@ -0,0 +1,169 @@
+// Package config holds the settings of the services. They are loaded by
+// internal/config.
+package config
+
+import "time"
+
+type Config struct {
+	// Host is the interface the server listens on, empty for all of them.
+	Host      string          `mapstructure:"host"`
+	Port      int             `mapstructure:"port"`
+	SaveDir   string          `mapstructure:"saveDir"`
+	NoSave    bool            `mapstructure:"noSave"`
+	Auth      AuthConfig      `mapstructure:"auth"`
+	Notifier  NotifierConfig  `mapstructure:"notifier"`
+	Webhook   WebhookConfig   `mapstructure:"webhook"`
+	Command   CommandConfig   `mapstructure:"command"`
+	Schedule  ScheduleConfig  `mapstructure:"schedule"`
+	Retention RetentionConfig `mapstructure:"retention"`
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// gives the client address. Requests from anywhere else are attributed
+	// to their peer address.
+	TrustedProxies []string `mapstructure:"trustedProxies"`
+}
+
+// AuthConfig controls the lifetime of issued tokens.
+// A zero duration disables expiry.
+type AuthConfig struct {
+	AccessTokenTTL  time.Duration `mapstructure:"accessTokenTTL"`
+	RefreshTokenTTL time.Duration `mapstructure:"refreshTokenTTL"`
+	// SlidingExpiration renews access tokens for another AccessTokenTTL on use.
+	SlidingExpiration bool `mapstructure:"slidingExpiration"`
+	// SweepInterval is how often expired access tokens are purged.
+	SweepInterval time.Duration `mapstructure:"sweepInterval"`
+	// JWT replaces stored access tokens with signed ones. Sliding expiration
+	// does not apply to signed tokens, clients use the refresh token instead.
+	JWT JWTConfig `mapstructure:"jwt"`
+	// Password is the strength policy enforced when passwords are set.
+	Password PasswordConfig `mapstructure:"password"`
+	// ResetCodeTTL is how long a password reset code stays valid.
+	ResetCodeTTL time.Duration `mapstructure:"resetCodeTTL"`
+	// A user may request ResetRate password resets per minute with bursts
+	// of ResetBurst; a zero rate disables the limit.
+	ResetRate  int `mapstructure:"resetRate"`
+	ResetBurst int `mapstructure:"resetBurst"`
+	// Lockout throttles failed logins per username and per client address.
+	Lockout   LockoutConfig   `mapstructure:"lockout"`
+	TwoFactor TwoFactorConfig `mapstructure:"twoFactor"`
+	OIDC      OIDCConfig      `mapstructure:"oidc"`
+}
+
+// OIDCConfig enables login through an external OpenID provider.
+type OIDCConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Issuer       string `mapstructure:"issuer"`
+	ClientId     string `mapstructure:"clientId"`
+	ClientSecret string `mapstructure:"clientSecret"`
+	// RedirectURL is the callback registered at the provider, usually
+	// https://<host>/api/v1/auth/oidc/callback.
+	RedirectURL string   `mapstructure:"redirectURL"`
+	Scopes      []string `mapstructure:"scopes"`
+	// StateTTL is how long the provider login may take.
+	StateTTL time.Duration `mapstructure:"stateTTL"`
+}
+
+// TwoFactorConfig controls TOTP two-factor authentication.
+type TwoFactorConfig struct {
+	// Issuer is the account name shown in authenticator apps.
+	Issuer string `mapstructure:"issuer"`
+	// ChallengeTTL is how long the second step of a login may take.
+	ChallengeTTL time.Duration `mapstructure:"challengeTTL"`
+}
+
+// LockoutConfig throttles repeated failed logins. Each failure doubles the
+// delay before the next attempt, starting at BaseDelay and capped at
+// MaxDelay. After MaxAttempts failures the key is locked for Duration.
+// Failures older than Window are forgotten. Zero values disable a rule.
+type LockoutConfig struct {
+	MaxAttempts int           `mapstructure:"maxAttempts"`
+	BaseDelay   time.Duration `mapstructure:"baseDelay"`
+	MaxDelay    time.Duration `mapstructure:"maxDelay"`
+	Duration    time.Duration `mapstructure:"duration"`
+	Window      time.Duration `mapstructure:"window"`
+}
+
+// PasswordConfig is the password strength policy. Zero values disable a rule.
+type PasswordConfig struct {
+	MinLength     int  `mapstructure:"minLength"`
+	RequireLetter bool `mapstructure:"requireLetter"`
+	RequireDigit  bool `mapstructure:"requireDigit"`
+	RequireSymbol bool `mapstructure:"requireSymbol"`
+}
+
+// NotifierConfig selects how out-of-band messages such as password reset
+// codes reach users: "file" appends them to Path, "log" writes them to the
+// server log. Type has no default and must be set. Anyone who can read the
+// server log could take over accounts with the logged codes, so "log" is
+// refused unless Dev is set.
+type NotifierConfig struct {
+	Type string `mapstructure:"type"`
+	Path string `mapstructure:"path"`
+	Dev  bool   `mapstructure:"dev"`
+}
+
+// WebhookConfig controls delivery of outgoing webhooks. Failed deliveries are
+// retried with exponential backoff from BaseDelay up to MaxDelay, and given up
+// after MaxAttempts. An endpoint is disabled after DisableAfter deliveries in
+// a row have been given up; zero never disables it.
+// Incoming webhooks may post IncomingRate messages per minute with bursts of
+// IncomingBurst; a zero rate disables the limit.
+// Webhooks may not point to loopback, private or link-local addresses unless
+// AllowInternal is set.
+type WebhookConfig struct {
+	Timeout       time.Duration `mapstructure:"timeout"`
+	PollInterval  time.Duration `mapstructure:"pollInterval"`
+	MaxAttempts   int           `mapstructure:"maxAttempts"`
+	BaseDelay     time.Duration `mapstructure:"baseDelay"`
+	MaxDelay      time.Duration `mapstructure:"maxDelay"`
+	DisableAfter  int           `mapstructure:"disableAfter"`
+	IncomingRate  int           `mapstructure:"incomingRate"`
+	IncomingBurst int           `mapstructure:"incomingBurst"`
+	AllowInternal bool          `mapstructure:"allowInternal"`
+}
+
+// CommandConfig controls slash commands served by external endpoints, which
+// must reply within Timeout. Endpoints may not be loopback, private or
+// link-local addresses unless AllowInternal is set.
+type CommandConfig struct {
+	Timeout       time.Duration `mapstructure:"timeout"`
+	AllowInternal bool          `mapstructure:"allowInternal"`
+}
+
+// ScheduleConfig controls how often the scheduler looks for due scheduled
+// messages and reminders.
+type ScheduleConfig struct {
+	PollInterval time.Duration `mapstructure:"pollInterval"`
+}
+
+// RetentionConfig controls the retention job, which runs every Interval.
+// It deletes expired messages of groups with disappearing messages, messages
+// older than MessageMaxAge together with their polls, and the remaining data
+// of accounts deleted more than AccountGracePeriod ago. A zero MessageMaxAge
+// keeps messages forever.
+type RetentionConfig struct {
+	Interval           time.Duration `mapstructure:"interval"`
+	MessageMaxAge      time.Duration `mapstructure:"messageMaxAge"`
+	AccountGracePeriod time.Duration `mapstructure:"accountGracePeriod"`
+}
+
+// JWTConfig enables signed access tokens that other services can verify
+// with the published JWKS instead of asking the user database.
+type JWTConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Issuer  string `mapstructure:"issuer"`
+	// SigningKey is the id of the key used to sign new tokens. The other keys
+	// only verify tokens issued before the last rotation.
+	SigningKey string         `mapstructure:"signingKey"`
+	Keys       []JWTKeyConfig `mapstructure:"keys"`
+}
+
+// JWTKeyConfig is an EdDSA key (base64 encoded Ed25519 private key or seed,
+// or only the public key for retired keys) or an HS256 secret.
+type JWTKeyConfig struct {
+	Id         string `mapstructure:"id"`
+	Alg        string `mapstructure:"alg"`
+	PrivateKey string `mapstructure:"privateKey"`
+	PublicKey  string `mapstructure:"publicKey"`
+	Secret     string `mapstructure:"secret"`
+}
@@ -6,6 +6,10 @@ import (
 	"sync"
 )
 
+// subscriberBuffer is the number of events queued for a subscriber before
+// Publish waits for it.
+const subscriberBuffer = 256
+
 type EventHandler struct {
 	subjects map[string]*Subject
 	mu       sync.RWMutex
@@ -16,17 +20,19 @@ type Event struct {
 }
 
 type Subject struct {
-	channels []chan interface{}
-	once     sync.Once
+	subscribers []*Subscriber
+	mu          sync.Mutex
+	once        sync.Once
 }
 
 type Subscriber struct {
 	ch     chan interface{}
+	done   chan struct{}
 	cancel context.CancelFunc
 }
 
 func NewSubscriber(ch chan interface{}) *Subscriber {
-	subc := &Subscriber{ch: ch}
+	subc := &Subscriber{ch: ch, done: make(chan struct{})}
 	return subc
 }
 
@@ -39,16 +45,20 @@ func NewEventHandler() *EventHandler {
 
 func NewSubject() *Subject {
 	subj := &Subject{
-		channels: make([]chan interface{}, 0),
+		subscribers: make([]*Subscriber, 0),
 	}
 	return subj
 }
 
+// Register calls fn for every event published on pattern until ctx is done.
 func (eh *EventHandler) Register(ctx context.Context, pattern string, fn func(e *Event) error) error {
 	subj := eh.getSubject(pattern, true)
 	subc := subj.createSubscriber()
 
-	go subc.Subscribe(ctx, fn)
+	go func() {
+		subc.Subscribe(ctx, fn)
+		subj.deleteSubscriber(subc)
+	}()
 	return nil
 }
 
@@ -65,8 +75,16 @@ func (eh *EventHandler) Publish(pattern string, data interface{}) error {
 // getSubject returnss the subject for the given pattern.
 // If it does not exist, a new one is createed and returned
 func (eh *EventHandler) getSubject(pattern string, create bool) *Subject {
+	eh.mu.RLock()
 	subj, exist := eh.subjects[pattern]
-	if !exist && create {
+	eh.mu.RUnlock()
+	if exist || !create {
+		return subj
+	}
+
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	if subj, exist = eh.subjects[pattern]; !exist {
 		subj = NewSubject()
 		eh.subjects[pattern] = subj
 	}
@@ -76,35 +94,59 @@ func (eh *EventHandler) getSubject(pattern string, create bool) *Subject {
 func (eh *EventHandler) deleteSubject(pattern string) {
 }
 
+// Publish delivers data to every subscriber.
+// Subscribers that stopped while data is pending are skipped.
 func (subj *Subject) Publish(data interface{}) {
-	for _, ch := range subj.channels {
-		ch <- data
+	subj.mu.Lock()
+	subscribers := make([]*Subscriber, len(subj.subscribers))
+	copy(subscribers, subj.subscribers)
+	subj.mu.Unlock()
+
+	for _, subc := range subscribers {
+		select {
+		case subc.ch <- data:
+		case <-subc.done:
+		}
 	}
 }
 
 func (subj *Subject) createSubscriber() *Subscriber {
-	ch := make(chan interface{}, 10)
-	subj.channels = append(subj.channels, ch)
+	ch := make(chan interface{}, subscriberBuffer)
 	subc := NewSubscriber(ch)
 
+	subj.mu.Lock()
+	subj.subscribers = append(subj.subscribers, subc)
+	subj.mu.Unlock()
+
 	return subc
 }
 
-// TODO
-func (subj *Subject) deleteSubscriber() {
+func (subj *Subject) deleteSubscriber(subc *Subscriber) {
+	subj.mu.Lock()
+	defer subj.mu.Unlock()
+	for i, s := range subj.subscribers {
+		if s == subc {
+			subj.subscribers = append(subj.subscribers[:i], subj.subscribers[i+1:]...)
+			return
+		}
+	}
 }
 
-// TODO: add Done() receiver
+// Subscribe runs fn for each received event until ctx is done or fn fails.
 func (subc *Subscriber) Subscribe(ctx context.Context, fn func(e *Event) error) error {
 	ctx, cancel := context.WithCancel(ctx)
 	subc.cancel = cancel
+	defer close(subc.done)
+	defer cancel()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case data, ok := <-subc.ch:
-			fmt.Println("ok:", ok)
+			if !ok {
+				return nil
+			}
 			if err := fn(&Event{Data: data}); err != nil {
 				return err
 			}
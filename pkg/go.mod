@@ -1,3 +0,0 @@
-module github.com/elug3/chatapp/pkg
-
-go 1.24.2
@@ -0,0 +1,184 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JWK is the public part of a key as published in a JWKS document.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set. Symmetric keys are never published.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		switch key.Alg {
+		case AlgEdDSA:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Crv: "Ed25519",
+				X:   encoding.EncodeToString(key.PublicKey),
+				Kid: key.Id,
+				Alg: AlgEdDSA,
+				Use: "sig",
+			})
+		case AlgRS256:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				N:   encoding.EncodeToString(key.RSAPublicKey.N.Bytes()),
+				E:   encoding.EncodeToString(big.NewInt(int64(key.RSAPublicKey.E)).Bytes()),
+				Kid: key.Id,
+				Alg: AlgRS256,
+				Use: "sig",
+			})
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
+
+func (jwk *JWK) key() (*Key, error) {
+	switch {
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+		x, err := encoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q", jwk.Kid)
+		}
+		return &Key{Id: jwk.Kid, Alg: AlgEdDSA, PublicKey: ed25519.PublicKey(x)}, nil
+	case jwk.Kty == "RSA" && (jwk.Alg == "" || jwk.Alg == AlgRS256):
+		n, err := encoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q", jwk.Kid)
+		}
+		e, err := encoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid public key %q", jwk.Kid)
+		}
+		pub := rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return &Key{Id: jwk.Kid, Alg: AlgRS256, RSAPublicKey: &pub}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q/%q", jwk.Kty, jwk.Crv)
+	}
+}
+
+// Verifier verifies tokens against the JWKS document published by the auth
+// service. Keys are cached and refetched when a token names an unknown key,
+// so other services can verify tokens without a database round trip.
+type Verifier struct {
+	url        string
+	issuer     string
+	client     *http.Client
+	minRefresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*Key
+	fetchedAt time.Time
+}
+
+// NewVerifier returns a verifier of tokens issued by issuer and signed with
+// the keys published at jwksURL.
+func NewVerifier(jwksURL, issuer string) *Verifier {
+	return &Verifier{
+		url:        jwksURL,
+		issuer:     issuer,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		minRefresh: time.Minute,
+		keys:       make(map[string]*Key),
+	}
+}
+
+// Verify checks the signature, issuer and expiry of the token and returns
+// its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	claims, err := verify(token, v.lookup)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalid, claims.Issuer)
+	}
+	return claims, nil
+}
+
+// Decode checks the signature of the token and unmarshals its payload into
+// claims. The claims, including the issuer and expiry, are left to the
+// caller to check.
+func (v *Verifier) Decode(token string, claims any) error {
+	return decode(token, v.lookup, claims)
+}
+
+// lookup returns the key from the cache, refetching the document if the key
+// is unknown. The document is fetched without holding the lock, so verifying
+// tokens with known keys is not blocked by a slow auth service.
+func (v *Verifier) lookup(kid string) (*Key, error) {
+	v.mu.Lock()
+	if key, ok := v.keys[kid]; ok {
+		v.mu.Unlock()
+		return key, nil
+	}
+	// unknown keys refetch the document, at most once per minRefresh
+	if time.Since(v.fetchedAt) < v.minRefresh {
+		v.mu.Unlock()
+		return nil, ErrUnknownKey
+	}
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+
+	keys, err := v.fetch()
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (v *Verifier) fetch() (map[string]*Key, error) {
+	resp, err := v.client.Get(v.url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+	keys := make(map[string]*Key, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.key()
+		if err != nil {
+			continue
+		}
+		keys[key.Id] = key
+	}
+	return keys, nil
+}
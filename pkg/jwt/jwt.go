@@ -0,0 +1,223 @@
+// Package jwt issues and verifies the signed access tokens shared between
+// gochat services. Only the EdDSA (Ed25519), RS256 and HS256 algorithms are
+// supported.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+	AlgHS256 = "HS256"
+)
+
+var (
+	ErrInvalid    = errors.New("invalid token")
+	ErrExpired    = errors.New("token expired")
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// Claims are the registered and gochat specific claims of an access token.
+type Claims struct {
+	Id        string `json:"jti"`
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	UserId    int    `json:"uid"`
+	SessionId int    `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// Expired reports whether the claims are expired at t.
+func (c *Claims) Expired(t time.Time) bool {
+	return c.ExpiresAt != 0 && t.Unix() >= c.ExpiresAt
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// Key signs or verifies tokens. Verification-only keys have no private part.
+type Key struct {
+	Id            string
+	Alg           string
+	PrivateKey    ed25519.PrivateKey
+	PublicKey     ed25519.PublicKey
+	RSAPrivateKey *rsa.PrivateKey
+	RSAPublicKey  *rsa.PublicKey
+	Secret        []byte
+}
+
+func (k *Key) sign(data []byte) ([]byte, error) {
+	switch k.Alg {
+	case AlgEdDSA:
+		if k.PrivateKey == nil {
+			return nil, fmt.Errorf("key %q cannot sign", k.Id)
+		}
+		return ed25519.Sign(k.PrivateKey, data), nil
+	case AlgRS256:
+		if k.RSAPrivateKey == nil {
+			return nil, fmt.Errorf("key %q cannot sign", k.Id)
+		}
+		sum := sha256.Sum256(data)
+		return rsa.SignPKCS1v15(rand.Reader, k.RSAPrivateKey, crypto.SHA256, sum[:])
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", k.Alg)
+	}
+}
+
+func (k *Key) verify(data, sig []byte) bool {
+	switch k.Alg {
+	case AlgEdDSA:
+		return len(k.PublicKey) == ed25519.PublicKeySize && ed25519.Verify(k.PublicKey, data, sig)
+	case AlgRS256:
+		sum := sha256.Sum256(data)
+		return k.RSAPublicKey != nil && rsa.VerifyPKCS1v15(k.RSAPublicKey, crypto.SHA256, sum[:], sig) == nil
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(data)
+		return hmac.Equal(mac.Sum(nil), sig)
+	default:
+		return false
+	}
+}
+
+// KeySet holds the keys of a service. New tokens are signed with the current
+// key; retired keys stay in the set to verify tokens issued before a rotation.
+type KeySet struct {
+	keys    map[string]*Key
+	current string
+}
+
+func NewKeySet(current string, keys ...Key) (*KeySet, error) {
+	ks := KeySet{keys: make(map[string]*Key), current: current}
+	for i := range keys {
+		key := keys[i]
+		if key.Alg == AlgEdDSA && key.PublicKey == nil && key.PrivateKey != nil {
+			key.PublicKey = key.PrivateKey.Public().(ed25519.PublicKey)
+		}
+		if key.Alg == AlgRS256 && key.RSAPublicKey == nil && key.RSAPrivateKey != nil {
+			key.RSAPublicKey = &key.RSAPrivateKey.PublicKey
+		}
+		if _, exists := ks.keys[key.Id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.Id)
+		}
+		ks.keys[key.Id] = &key
+	}
+	if current != "" {
+		if _, ok := ks.keys[current]; !ok {
+			return nil, fmt.Errorf("current key %q: %w", current, ErrUnknownKey)
+		}
+	}
+	return &ks, nil
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign encodes the claims, usually Claims, as a token signed with the current key.
+func (ks *KeySet) Sign(claims any) (string, error) {
+	key, ok := ks.keys[ks.current]
+	if !ok {
+		return "", fmt.Errorf("no signing key: %w", ErrUnknownKey)
+	}
+	h, err := json.Marshal(header{Alg: key.Alg, Typ: "JWT", Kid: key.Id})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(p)
+	sig, err := key.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + encoding.EncodeToString(sig), nil
+}
+
+// Verify checks the signature and expiry of the token and returns its claims.
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	return verify(token, func(kid string) (*Key, error) {
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return key, nil
+	})
+}
+
+// IsToken reports whether s has the shape of a signed token.
+func IsToken(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+func verify(token string, lookup func(kid string) (*Key, error)) (*Claims, error) {
+	var claims Claims
+	if err := decode(token, lookup, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+// decode checks the signature of the token and unmarshals its payload into v.
+func decode(token string, lookup func(kid string) (*Key, error), v any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalid
+	}
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalid
+	}
+	var h header
+	if err = json.Unmarshal(rawHeader, &h); err != nil {
+		return ErrInvalid
+	}
+	key, err := lookup(h.Kid)
+	if err != nil {
+		return fmt.Errorf("%w: kid %q: %w", ErrInvalid, h.Kid, err)
+	}
+	// the algorithm is fixed by the key, never by the token
+	if h.Alg != key.Alg {
+		return ErrInvalid
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalid
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return ErrInvalid
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalid
+	}
+	if err = json.Unmarshal(payload, v); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEdKey(t *testing.T, id string) Key {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Key{Id: id, Alg: AlgEdDSA, PrivateKey: priv}
+}
+
+func TestKeySet_Verify(t *testing.T) {
+	edKey := newEdKey(t, "ed-1")
+	hsKey := Key{Id: "hs-1", Alg: AlgHS256, Secret: []byte("0123456789abcdef0123456789abcdef")}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsKey := Key{Id: "rs-1", Alg: AlgRS256, RSAPrivateKey: rsaKey}
+	valid := Claims{Id: "1", Subject: "1", UserId: 1, SessionId: 1, IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+	testCases := map[string]struct {
+		signer  string
+		claims  Claims
+		tamper  func(token string) string
+		wantErr error
+	}{
+		"eddsa":         {signer: "ed-1", claims: valid},
+		"hs256":         {signer: "hs-1", claims: valid},
+		"rs256":         {signer: "rs-1", claims: valid},
+		"no expiry":     {signer: "ed-1", claims: Claims{Subject: "1", UserId: 1}},
+		"expired":       {signer: "ed-1", claims: expired, wantErr: ErrExpired},
+		"malformed":     {signer: "ed-1", claims: valid, tamper: func(string) string { return "a.b" }, wantErr: ErrInvalid},
+		"bad signature": {signer: "ed-1", claims: valid, tamper: func(s string) string { return s[:len(s)-4] + "AAAA" }, wantErr: ErrInvalid},
+		"alg mismatch": {signer: "ed-1", claims: valid, wantErr: ErrInvalid, tamper: func(s string) string {
+			// relabel the token as HS256 keeping the EdDSA signature
+			h, _ := json.Marshal(header{Alg: AlgHS256, Typ: "JWT", Kid: "ed-1"})
+			parts := strings.Split(s, ".")
+			return encoding.EncodeToString(h) + "." + parts[1] + "." + parts[2]
+		}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ks, err := NewKeySet(tc.signer, edKey, hsKey, rsKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := ks.Sign(tc.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.tamper != nil {
+				token = tc.tamper(token)
+			}
+			claims, err := ks.Verify(token)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %q, but got %q", tc.wantErr, err)
+			}
+			if err == nil && *claims != tc.claims {
+				t.Errorf("expected claims %+v, but got %+v", tc.claims, *claims)
+			}
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldKey, newKey := newEdKey(t, "old"), newEdKey(t, "new")
+	before, err := NewKeySet("old", oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := before.Sign(Claims{Subject: "1", UserId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old key is retired, only its public part is kept
+	retired := Key{Id: "old", Alg: AlgEdDSA, PublicKey: oldKey.PrivateKey.Public().(ed25519.PublicKey)}
+	after, err := NewKeySet("new", newKey, retired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = after.Verify(token); err != nil {
+		t.Errorf("token of retired key: %v", err)
+	}
+	if len(after.JWKS().Keys) != 2 {
+		t.Errorf("expected 2 published keys, but got %d", len(after.JWKS().Keys))
+	}
+
+	withoutOld, err := NewKeySet("new", newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = withoutOld.Verify(token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected error %q, but got %q", ErrUnknownKey, err)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	key := newEdKey(t, "ed-1")
+	ks, err := NewKeySet("ed-1", key, Key{Id: "hs-1", Alg: AlgHS256, Secret: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(ks.JWKS())
+	}))
+	defer srv.Close()
+
+	v := NewVerifier(srv.URL, "gochat")
+	token, err := ks.Sign(Claims{Issuer: "gochat", Subject: "1", UserId: 1, SessionId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		claims, err := v.Verify(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.UserId != 1 || claims.SessionId != 2 {
+			t.Errorf("unexpected claims %+v", claims)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, but got %d", fetches)
+	}
+
+	other, err := ks.Sign(Claims{Issuer: "other", Subject: "1", UserId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.Verify(other); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected error %q, but got %q", ErrInvalid, err)
+	}
+
+	// symmetric keys are not published, so their tokens are rejected
+	ks.current = "hs-1"
+	token, err = ks.Sign(Claims{Issuer: "gochat", Subject: "1", UserId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.Verify(token); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected error %q, but got %q", ErrInvalid, err)
+	}
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// APIKeyPrefix marks API keys so they can be told apart from access tokens.
+const APIKeyPrefix = "gck_"
+
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+)
+
+// APIScopes lists the scopes an API key can be granted.
+var APIScopes = []string{ScopeMessagesRead, ScopeMessagesWrite}
+
+// APIKey is a long-lived credential of a bot. Key is only set when the key is
+// created.
+type APIKey struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	GroupIds   []int      `json:"group_ids,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Allows reports whether the key grants the scope on the group. A key
+// without group ids is valid for every group of its user; groupId 0 checks
+// the scope alone.
+func (key APIKey) Allows(scope string, groupId int) bool {
+	if !slices.Contains(key.Scopes, scope) {
+		return false
+	}
+	return groupId == 0 || len(key.GroupIds) == 0 || slices.Contains(key.GroupIds, groupId)
+}
@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// ResponseType tells where the reply of a command goes.
+type ResponseType string
+
+const (
+	// ResponseEphemeral replies are only shown to the user who ran the command.
+	ResponseEphemeral ResponseType = "ephemeral"
+	// ResponseInChannel replies are posted to the group.
+	ResponseInChannel ResponseType = "in_channel"
+)
+
+// Command is a slash command available in a group. Built-in commands are
+// served by the server itself and have no id; the others are registered per
+// group and forward invocations to an external URL. Secret signs the
+// invocations; it is only returned when the command is created.
+type Command struct {
+	Id          int       `json:"id,omitempty"`
+	GroupId     int       `json:"group_id,omitempty"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Usage       string    `json:"usage,omitempty"`
+	Builtin     bool      `json:"builtin,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Secret      string    `json:"secret,omitempty"`
+	CreatedBy   int       `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+}
+
+// CommandInvocation is posted to the URL of an external command.
+type CommandInvocation struct {
+	Command  string `json:"command"`
+	Text     string `json:"text"`
+	GroupId  int    `json:"group_id"`
+	UserId   int    `json:"user_id"`
+	UserName string `json:"user_name"`
+}
+
+// CommandResponse is the reply of a command. The zero ResponseType is
+// ephemeral.
+type CommandResponse struct {
+	ResponseType ResponseType `json:"response_type"`
+	Text         string       `json:"text"`
+	Attachments  []Attachment `json:"attachments,omitempty"`
+	// Message is the message posted for in_channel replies.
+	Message *Message `json:"message,omitempty"`
+}
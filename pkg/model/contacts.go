@@ -6,15 +6,108 @@ import (
 
 type Role string
 
+type Presence string
+
+const (
+	PresenceOnline  Presence = "online"
+	PresenceOffline Presence = "offline"
+)
+
+type ContactRequestStatus string
+
+const (
+	ContactRequestPending  ContactRequestStatus = "pending"
+	ContactRequestAccepted ContactRequestStatus = "accepted"
+	ContactRequestRejected ContactRequestStatus = "rejected"
+)
+
 type Profile struct {
-	Id       int        `json:"id"`
-	Name     string     `json:"name"`
-	Birthday *time.Time `json:"birthday,omitempty"`
+	Id           int        `json:"id"`
+	Name         string     `json:"name"`
+	Birthday     *time.Time `json:"birthday,omitempty"`
+	LastSeen     *time.Time `json:"last_seen,omitempty"`
+	ContactsOnly bool       `json:"contacts_only"`
+	Bot          bool       `json:"bot,omitempty"`
 }
 
 type Group struct {
-	Id        int       `json:"id"`
-	Name      string    `json:"name"`
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Avatar is the URL of the group picture.
+	Avatar string `json:"avatar,omitempty"`
+	Direct bool   `json:"direct,omitempty"`
+	// Retention is how many seconds messages are kept before they disappear.
+	// Messages are kept forever if it is 0.
+	Retention int           `json:"retention,omitempty"`
+	Settings  GroupSettings `json:"settings"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Permission is the lowest role allowed to do something in a group.
+type Permission string
+
+const (
+	PermissionEveryone Permission = "everyone"
+	PermissionManagers Permission = "managers"
+	PermissionOwner    Permission = "owner"
+)
+
+// GroupSettings control what members of a group may do.
+type GroupSettings struct {
+	// Post is who can send messages.
+	Post Permission `json:"post"`
+	// Invite is who can add members.
+	Invite Permission `json:"invite"`
+	// JoinApproval requires a manager to approve users joining the group.
+	JoinApproval bool `json:"join_approval"`
+	// Public lists the group in discovery and lets anyone join it, or
+	// request to join it if JoinApproval is set.
+	Public bool `json:"public"`
+}
+
+// GroupUpdated is the data of a group.updated event.
+type GroupUpdated struct {
+	Group Group `json:"group"`
+	// Changed lists the changed fields: name, description, avatar or
+	// settings.
+	Changed []string `json:"changed"`
+}
+
+type Contact struct {
+	UserId    int        `json:"user_id"`
+	Name      string     `json:"name"`
+	Presence  Presence   `json:"presence"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ContactRequest struct {
+	Id        int                  `json:"id"`
+	FromId    int                  `json:"from_id"`
+	ToId      int                  `json:"to_id"`
+	Status    ContactRequestStatus `json:"status"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+type JoinRequestStatus string
+
+const (
+	JoinRequestPending  JoinRequestStatus = "pending"
+	JoinRequestApproved JoinRequestStatus = "approved"
+	JoinRequestDenied   JoinRequestStatus = "denied"
+)
+
+// JoinRequest asks the managers of a public group with join approval to let
+// the user in.
+type JoinRequest struct {
+	Id      int               `json:"id"`
+	GroupId int               `json:"group_id"`
+	UserId  int               `json:"user_id"`
+	Status  JoinRequestStatus `json:"status"`
+	// DecidedBy is the manager who approved or denied the request.
+	DecidedBy int       `json:"decided_by,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -26,10 +119,104 @@ type Member struct {
 	CreatedAt time.Time `json:"created_at,omitempty"`
 }
 
+// MessageType distinguishes messages with structured content. Plain text
+// messages have no type.
+type MessageType string
+
+const (
+	MessagePoll MessageType = "poll"
+	// MessageSystem announces a change of the group rather than being sent
+	// by a member.
+	MessageSystem MessageType = "system"
+)
+
 type Message struct {
-	Id        string
-	SenderId  string
-	ConvId    string
-	Content   string
-	CreatedAt time.Time
+	Id       string      `json:"id"`
+	SenderId string      `json:"sender_id"`
+	ConvId   string      `json:"conv_id"`
+	Type     MessageType `json:"type,omitempty"`
+	Content  string      `json:"content"`
+	Bot      bool        `json:"bot,omitempty"`
+	// PollId is the poll of a poll message.
+	PollId int `json:"poll_id,omitempty"`
+	// Username overrides the sender name shown for messages posted through
+	// incoming webhooks.
+	Username    string       `json:"username,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	EditedAt    *time.Time   `json:"edited_at,omitempty"`
+	Pinned      bool         `json:"pinned"`
+	PinnedBy    int          `json:"pinned_by,omitempty"`
+	PinnedAt    *time.Time   `json:"pinned_at,omitempty"`
+	// ExpiresAt is when a message of a group with retention disappears.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// DeletedAt is set when an expired message was replaced by a tombstone
+	// without content.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// System describes the change announced by a system message.
+	System *SystemPayload `json:"system,omitempty"`
+}
+
+// SystemAction is the change of a group announced by a system message.
+type SystemAction string
+
+const (
+	// SystemMemberAdded is sent when the actor adds the target to the group.
+	SystemMemberAdded SystemAction = "member.added"
+	// SystemMemberJoined is sent when the actor joins the group by itself.
+	SystemMemberJoined SystemAction = "member.joined"
+	// SystemMemberLeft is sent when the actor leaves the group.
+	SystemMemberLeft SystemAction = "member.left"
+	// SystemMemberRemoved is sent when the actor removes the target.
+	SystemMemberRemoved SystemAction = "member.removed"
+	// SystemMemberRole is sent when the target is given Role.
+	SystemMemberRole SystemAction = "member.role"
+	// SystemRetention is sent when the actor changes Retention.
+	SystemRetention SystemAction = "group.retention"
+	// SystemGroupRenamed is sent when the actor renames the group to Name.
+	SystemGroupRenamed SystemAction = "group.renamed"
+)
+
+// SystemPayload lets clients render system messages in their own words. The
+// content of a system message is an English rendering of it.
+type SystemPayload struct {
+	Action   SystemAction `json:"action"`
+	ActorId  int          `json:"actor_id,omitempty"`
+	TargetId int          `json:"target_id,omitempty"`
+	Role     Role         `json:"role,omitempty"`
+	// Retention is the new retention in seconds, 0 if it was turned off.
+	Retention int `json:"retention,omitempty"`
+	// Name is the new name of the group.
+	Name string `json:"name,omitempty"`
+}
+
+// Attachment is a rich block shown below the text of a message.
+type Attachment struct {
+	Title    string `json:"title,omitempty"`
+	Text     string `json:"text,omitempty"`
+	URL      string `json:"url,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type Block struct {
+	UserId    int       `json:"user_id"`
+	BlockedId int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Mute struct {
+	UserId    int        `json:"user_id"`
+	GroupId   int        `json:"group_id"`
+	Until     *time.Time `json:"until,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Restriction revokes the permission of a member to post in a group until
+// the given time, or until it is lifted if Until is nil.
+type Restriction struct {
+	UserId    int        `json:"user_id"`
+	GroupId   int        `json:"group_id"`
+	Until     *time.Time `json:"until,omitempty"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
 }
@@ -0,0 +1,43 @@
+package model
+
+const (
+	EventMessageCreated  = "message.created"
+	EventMessageEdited   = "message.edited"
+	EventMessageDeleted  = "message.deleted"
+	EventMessagePinned   = "message.pinned"
+	EventMessageUnpinned = "message.unpinned"
+	EventMemberAdded     = "member.added"
+	EventMemberRemoved   = "member.removed"
+	EventMemberUpdated   = "member.updated"
+	EventGroupUpdated    = "group.updated"
+	EventCommandReply    = "command.reply"
+	EventPollUpdated     = "poll.updated"
+	EventReminderDue     = "reminder.due"
+)
+
+// FeedEvents are the events delivered to clients over the realtime feed.
+var FeedEvents = []string{
+	EventMessageCreated,
+	EventMessageEdited,
+	EventMessageDeleted,
+	EventMessagePinned,
+	EventMessageUnpinned,
+	EventMemberAdded,
+	EventMemberRemoved,
+	EventMemberUpdated,
+	EventGroupUpdated,
+	EventCommandReply,
+	EventPollUpdated,
+	EventReminderDue,
+}
+
+// ChatEvent is published when something happens in a group.
+type ChatEvent struct {
+	Type    string `json:"type"`
+	GroupId int    `json:"group_id"`
+	ActorId int    `json:"actor_id"`
+	Notify  bool   `json:"notify"`
+	// RecipientId limits delivery to one member, for ephemeral events.
+	RecipientId int `json:"recipient_id,omitempty"`
+	Data        any `json:"data"`
+}
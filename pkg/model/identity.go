@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// ExternalIdentity links a subject of an external identity provider to a user.
+type ExternalIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserId    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCState is kept between redirecting to the provider and its callback.
+type OIDCState struct {
+	CodeVerifier string
+	Nonce        string
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// LoginThrottle counts recent failed logins for a username or client address.
+type LoginThrottle struct {
+	Key          string
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+const (
+	LoginFailedPassword = "invalid_credentials"
+	LoginFailedLocked   = "locked"
+	LoginFailedCode     = "invalid_code"
+)
+
+// LoginAudit records a failed login attempt.
+type LoginAudit struct {
+	Id        int       `json:"id"`
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	Device
+}
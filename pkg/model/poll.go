@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// Poll is posted to a group as a poll message. Members vote for one option,
+// or for any number of them if Multiple is set. The voters of each option are
+// only shown if the poll is not anonymous.
+type Poll struct {
+	Id        int          `json:"id"`
+	GroupId   int          `json:"group_id"`
+	MessageId string       `json:"message_id"`
+	Question  string       `json:"question"`
+	Options   []PollOption `json:"options"`
+	Multiple  bool         `json:"multiple"`
+	Anonymous bool         `json:"anonymous"`
+	CreatedBy int          `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+	ClosesAt  *time.Time   `json:"closes_at,omitempty"`
+	ClosedAt  *time.Time   `json:"closed_at,omitempty"`
+	Closed    bool         `json:"closed"`
+	// Voted are the options voted for by the user viewing the poll.
+	Voted []int `json:"voted,omitempty"`
+}
+
+type PollOption struct {
+	Id     int    `json:"id"`
+	Text   string `json:"text"`
+	Votes  int    `json:"votes"`
+	Voters []int  `json:"voters,omitempty"`
+}
+
+// IsClosed reports whether the poll was closed or its close time has passed.
+func (p *Poll) IsClosed(now time.Time) bool {
+	return p.ClosedAt != nil || (p.ClosesAt != nil && !now.Before(*p.ClosesAt))
+}
+
+// HasOption reports whether the option belongs to the poll.
+func (p *Poll) HasOption(optionId int) bool {
+	for _, option := range p.Options {
+		if option.Id == optionId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// JobKind is what a scheduled job does when it is due.
+type JobKind string
+
+const (
+	// JobMessage sends Content to the group as the user.
+	JobMessage JobKind = "message"
+	// JobReminder reminds the user of a message of the group.
+	JobReminder JobKind = "reminder"
+)
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	// JobDue is a reminder that ran and was not dismissed by the user yet.
+	JobDue    JobStatus = "due"
+	JobDone   JobStatus = "done"
+	JobFailed JobStatus = "failed"
+)
+
+// ScheduledJob is a message or reminder of a user that is run at RunAt.
+type ScheduledJob struct {
+	Id      int     `json:"id"`
+	Kind    JobKind `json:"kind"`
+	UserId  int     `json:"user_id"`
+	GroupId int     `json:"group_id"`
+	// MessageId is the message a reminder is about.
+	MessageId string    `json:"message_id,omitempty"`
+	Content   string    `json:"content"`
+	RunAt     time.Time `json:"run_at"`
+	Status    JobStatus `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Reminder is published to the user when a reminder is due. It is kept
+// until the user dismisses it.
+type Reminder struct {
+	Job     ScheduledJob `json:"job"`
+	Message *Message     `json:"message,omitempty"`
+}
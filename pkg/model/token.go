@@ -5,11 +5,42 @@ import (
 )
 
 type Token struct {
-	Id          string     `json:"id"`
-	AccessToken string     `json:"access_token"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
-	IssuedAt    time.Time  `json:"issued_at"`
-	UserId      int        `json:"user_id"`
+	Id           string     `json:"id"`
+	AccessToken  string     `json:"access_token"`
+	RefreshToken string     `json:"refresh_token,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	IssuedAt     time.Time  `json:"issued_at"`
+	UserId       int        `json:"user_id"`
+	SessionId    int        `json:"session_id"`
+	APIKey       *APIKey    `json:"-"`
+}
+
+// Device describes the client a session was created from.
+type Device struct {
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+}
+
+// Session groups the tokens issued from one login.
+type Session struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	IssuedAt   time.Time  `json:"issued_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Current    bool       `json:"current"`
+	Device
+}
+
+// RefreshToken is exchanged once for a new token pair of its session.
+type RefreshToken struct {
+	Id        int        `json:"id"`
+	SessionId int        `json:"session_id"`
+	Token     string     `json:"-"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
 }
 
 func (token Token) String() string {
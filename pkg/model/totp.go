@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// TOTP is the authenticator of a user. It only guards logins once enabled.
+type TOTP struct {
+	UserId      int
+	Secret      string
+	EnabledAt   *time.Time
+	LastCounter int64
+}
+
+// TOTPEnrollment is shown once to the user to set up an authenticator app.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// LoginChallenge is returned instead of a token when the password was right
+// but a second factor is required. It is exchanged together with a code.
+type LoginChallenge struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Methods   []string  `json:"methods"`
+}
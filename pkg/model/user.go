@@ -1,8 +1,15 @@
 package model
 
+import "time"
+
 type User struct {
 	Id       int    `json:"id"`
 	Username string `json:"username"`
+	Bot      bool   `json:"bot,omitempty"`
+	OwnerId  int    `json:"owner_id,omitempty"`
+	// DeletedAt is set when the account was deleted. Its data is purged after
+	// a grace period.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 // // TODO: Use optional fields for initialization
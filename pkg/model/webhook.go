@@ -0,0 +1,83 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// WebhookEvents are the events outgoing webhooks can subscribe to.
+var WebhookEvents = []string{
+	EventMessageCreated,
+	EventMessageEdited,
+	EventMessageDeleted,
+	EventMessagePinned,
+	EventMessageUnpinned,
+	EventMemberAdded,
+	EventMemberRemoved,
+	EventMemberUpdated,
+	EventGroupUpdated,
+}
+
+// Webhook posts events of a group to an external URL. Secret signs the
+// deliveries; it is only returned when the webhook is created.
+type Webhook struct {
+	Id         int        `json:"id"`
+	GroupId    int        `json:"group_id"`
+	URL        string     `json:"url"`
+	Events     []string   `json:"events"`
+	Secret     string     `json:"secret,omitempty"`
+	Failures   int        `json:"failures"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event queued for a webhook, with the outcome of its
+// latest attempt.
+type WebhookDelivery struct {
+	Id            int            `json:"id"`
+	WebhookId     int            `json:"webhook_id"`
+	Event         string         `json:"event"`
+	Payload       string         `json:"payload"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	StatusCode    int            `json:"status_code,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+}
+
+// WebhookPayload is the JSON body posted to a webhook.
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	GroupId   int       `json:"group_id"`
+	ActorId   int       `json:"actor_id"`
+	Data      any       `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IncomingWebhook lets external services post messages to a group through a
+// secret URL. Token is only set when the webhook is created or rotated.
+type IncomingWebhook struct {
+	Id         int        `json:"id"`
+	GroupId    int        `json:"group_id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// SenderId returns the sender id of the messages posted through the webhook.
+func (webhook IncomingWebhook) SenderId() string {
+	return "webhook:" + strconv.Itoa(webhook.Id)
+}
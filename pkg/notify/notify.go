@@ -0,0 +1,91 @@
+// Package notify delivers out-of-band messages, such as password reset codes,
+// to users.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+type Message struct {
+	UserId   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Subject  string    `json:"subject"`
+	Body     string    `json:"body"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New returns the notifier selected by the configuration.
+func New(cfg config.NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "":
+		return nil, fmt.Errorf("notifier: type is required")
+	case "log":
+		if !cfg.Dev {
+			return nil, fmt.Errorf("notifier: type %q exposes reset codes in the server log and needs dev to be set", cfg.Type)
+		}
+		return LogNotifier{}, nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("notifier: path is required")
+		}
+		return NewFileNotifier(cfg.Path), nil
+	default:
+		return nil, fmt.Errorf("notifier: unknown type %q", cfg.Type)
+	}
+}
+
+// LogNotifier writes messages to the server log, for local development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	log.Info().
+		Int("userId", msg.UserId).
+		Str("username", msg.Username).
+		Str("subject", msg.Subject).
+		Msg(msg.Body)
+	return nil
+}
+
+// FileNotifier appends messages to a file as JSON lines.
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// deletedSender replaces the sender of messages of deleted accounts.
+const deletedSender = "deleted"
+
+// AccountService deletes and exports accounts, which spans the user,
+// contacts and message stores.
+type AccountService struct {
+	users    store.UserStore
+	messages *MessageService
+}
+
+func NewAccountService(userStore store.UserStore, messages *MessageService) (*AccountService, error) {
+	s := AccountService{
+		users:    userStore,
+		messages: messages,
+	}
+	return &s, nil
+}
+
+// Delete deletes the account of the user. Its credentials, tokens, profile
+// and memberships are removed, its bots deleted and its messages anonymized
+// right away. The account itself is purged by the retention job after the
+// grace period.
+func (s *AccountService) Delete(userId int) error {
+	txu, err := s.users.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	user, err := txu.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	if user.Bot || user.DeletedAt != nil {
+		return &store.Error{
+			Kind:    store.KindUser,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("user '%d' cannot be deleted", userId),
+		}
+	}
+	bots, err := txu.ListBots(userId)
+	if err != nil {
+		return fmt.Errorf("ListBots: %w", err)
+	}
+	for _, bot := range bots {
+		if err = s.deleteData(bot.Id); err != nil {
+			return fmt.Errorf("delete bot %d: %w", bot.Id, err)
+		}
+		if err = txu.DeleteUser(bot.Id); err != nil {
+			return fmt.Errorf("DeleteUser: %w", err)
+		}
+	}
+	if err = s.deleteData(userId); err != nil {
+		return err
+	}
+	if err = txu.MarkDeleted(userId); err != nil {
+		return fmt.Errorf("MarkDeleted: %w", err)
+	}
+	return txu.Commit()
+}
+
+// deleteData removes the contacts data of the user and anonymizes its
+// messages.
+func (s *AccountService) deleteData(userId int) error {
+	if err := s.messages.Contacts.deleteAccount(userId); err != nil {
+		return fmt.Errorf("deleteAccount: %w", err)
+	}
+	if _, err := s.messages.store.AnonymizeMessages(strconv.Itoa(userId), deletedSender); err != nil {
+		return fmt.Errorf("AnonymizeMessages: %w", err)
+	}
+	return nil
+}
+
+// accountExport is the profile.json file of an export.
+type accountExport struct {
+	User    *model.User    `json:"user"`
+	Profile *model.Profile `json:"profile"`
+}
+
+// Export writes a ZIP archive of the data of the user: its account and
+// profile, memberships and sent messages, each as a JSON file.
+func (s *AccountService) Export(userId int, w io.Writer) error {
+	txu, err := s.users.Begin()
+	if err != nil {
+		return err
+	}
+	user, err := txu.GetUser(userId)
+	txu.Rollback()
+	if err != nil {
+		return err
+	}
+	profile, err := s.messages.Contacts.GetProfile(userId)
+	if err != nil {
+		return err
+	}
+	memberships, err := s.messages.Contacts.Memberships(userId)
+	if err != nil {
+		return err
+	}
+	msgs, err := s.messages.store.GetMessagesBySender(strconv.Itoa(userId))
+	if err != nil {
+		return fmt.Errorf("GetMessagesBySender: %w", err)
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", accountExport{User: user, Profile: profile}},
+		{"memberships.json", memberships},
+		{"messages.json", msgs},
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(file.data); err != nil {
+			return fmt.Errorf("%s: %w", file.name, err)
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/store/user/sqlite"
+)
+
+func newTestAccountService(t *testing.T) (*AccountService, *PresetResult) {
+	t.Helper()
+	messages, result := newTestMessageService(t, webhookPreset)
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txu, err := userStore.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txu.Rollback()
+	// users are created in the order of the preset profile ids
+	for _, name := range []string{"p1", "p2", "p3"} {
+		if _, err = txu.CreateUser(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = txu.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := NewAccountService(userStore, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accounts, result
+}
+
+func TestAccountService_Export(t *testing.T) {
+	accounts, result := newTestAccountService(t)
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	if _, err := accounts.messages.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := accounts.Export(p2.Id, &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		profile     accountExport
+		memberships []Membership
+		msgs        []model.Message
+	)
+	files := map[string]any{
+		"profile.json":     &profile,
+		"memberships.json": &memberships,
+		"messages.json":    &msgs,
+	}
+	for _, f := range zr.File {
+		v, ok := files[f.Name]
+		if !ok {
+			t.Errorf("unexpected file %q", f.Name)
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = json.NewDecoder(r).Decode(v); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		r.Close()
+		delete(files, f.Name)
+	}
+	if len(files) != 0 {
+		t.Errorf("missing files %v", files)
+	}
+	if profile.User == nil || profile.User.Username != "p2" || profile.Profile == nil || profile.Profile.Id != p2.Id {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+	if len(memberships) != 1 || memberships[0].Group.Id != g1.Id || memberships[0].Role != access.RoleMember {
+		t.Errorf("unexpected memberships %+v", memberships)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "hello" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+}
+
+func TestAccountService_Delete(t *testing.T) {
+	accounts, result := newTestAccountService(t)
+	p1, _ := result.GetProfile("p1")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+	contacts := accounts.messages.Contacts
+
+	solo, err := contacts.CreateGroup(p1.Id, "solo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := accounts.messages.Send(p1.Id, SendParams{ChatId: g1.Id, Content: "bye"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = accounts.Delete(p1.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = accounts.Delete(p1.Id); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+	if _, err = contacts.GetProfile(p1.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	if _, err = contacts.GetGroup(solo.Id, p1.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	memberships, err := contacts.Memberships(p3.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberships) != 1 || memberships[0].Role != access.RoleOwner {
+		t.Errorf("expected p3 to own g1, but got %+v", memberships)
+	}
+	msgs, err := accounts.messages.List(p3.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Id != msg.Id || msgs[0].SenderId != deletedSender {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+
+	retention, err := NewRetentionService(accounts.messages, accounts.users, config.RetentionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := retention.Run(t.Context(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accounts != 1 {
+		t.Errorf("expected 1 purged account, but got %+v", report)
+	}
+	txu, err := accounts.users.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txu.Rollback()
+	if _, err = txu.GetUser(p1.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+}
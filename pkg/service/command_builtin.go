@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+)
+
+const shrug = `¯\_(ツ)_/¯`
+
+func (s *CommandService) registerBuiltins() error {
+	builtins := []struct {
+		name, description, usage string
+		fn                       CommandFunc
+	}{
+		{"help", "List the available commands", "[prefix]", s.help},
+		{"me", "Post an action in the third person", "<action>", s.me},
+		{"shrug", "Append a shrug to the message", "[message]", s.shrug},
+	}
+	for _, b := range builtins {
+		if err := s.Register(b.name, b.description, b.usage, b.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// help replies with the commands of the group, one per line.
+func (s *CommandService) help(ctx context.Context, call CommandCall) (*model.CommandResponse, error) {
+	commands, err := s.List(call.UserId, call.GroupId, call.Text)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, command := range commands {
+		fmt.Fprintf(&b, "/%s", command.Name)
+		if command.Usage != "" {
+			fmt.Fprintf(&b, " %s", command.Usage)
+		}
+		if command.Description != "" {
+			fmt.Fprintf(&b, " - %s", command.Description)
+		}
+		b.WriteByte('\n')
+	}
+	if b.Len() == 0 {
+		b.WriteString("no matching commands")
+	}
+	return &model.CommandResponse{
+		ResponseType: model.ResponseEphemeral,
+		Text:         strings.TrimSuffix(b.String(), "\n"),
+	}, nil
+}
+
+// me posts "_<name> <action>_" as the user.
+func (s *CommandService) me(ctx context.Context, call CommandCall) (*model.CommandResponse, error) {
+	if call.Text == "" {
+		return &model.CommandResponse{Text: "usage: /me <action>"}, nil
+	}
+	profile, err := s.messages.Contacts.GetProfile(call.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return s.send(call, fmt.Sprintf("_%s %s_", profile.Name, call.Text))
+}
+
+func (s *CommandService) shrug(ctx context.Context, call CommandCall) (*model.CommandResponse, error) {
+	return s.send(call, strings.TrimSpace(call.Text+" "+shrug))
+}
+
+// send posts content as the user who ran the command.
+func (s *CommandService) send(call CommandCall, content string) (*model.CommandResponse, error) {
+	msg, err := s.messages.Send(call.UserId, SendParams{ChatId: call.GroupId, Content: content})
+	if err != nil {
+		return nil, err
+	}
+	return &model.CommandResponse{
+		ResponseType: model.ResponseInChannel,
+		Text:         msg.Content,
+		Message:      msg,
+	}, nil
+}
+
+// RegisterPolls adds /poll, which posts a poll to the group.
+func (s *CommandService) RegisterPolls(polls *PollService) error {
+	const usage = "<question> | <option> | <option>..."
+	return s.Register("poll", "Post a poll", usage, func(ctx context.Context, call CommandCall) (*model.CommandResponse, error) {
+		parts := strings.Split(call.Text, "|")
+		if len(parts) < 3 {
+			return &model.CommandResponse{Text: "usage: /poll " + usage}, nil
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		_, err := polls.Create(call.UserId, call.GroupId, PollParams{Question: parts[0], Options: parts[1:]})
+		// the poll message is the reply
+		return nil, err
+	})
+}
+
+// RegisterReminders adds /remind, which reminds the user of a note after a
+// duration such as 30m or 2h.
+func (s *CommandService) RegisterReminders(schedules *ScheduleService) error {
+	const usage = "<duration> <note>"
+	return s.Register("remind", "Remind yourself of something", usage, func(ctx context.Context, call CommandCall) (*model.CommandResponse, error) {
+		after, note, _ := strings.Cut(call.Text, " ")
+		d, err := time.ParseDuration(after)
+		if err != nil || d <= 0 || strings.TrimSpace(note) == "" {
+			return &model.CommandResponse{Text: "usage: /remind " + usage}, nil
+		}
+		job, err := schedules.Remind(call.UserId, call.GroupId, "", note, time.Now().Add(d))
+		if err != nil {
+			return nil, err
+		}
+		return &model.CommandResponse{
+			Text: fmt.Sprintf("I will remind you at %s", job.RunAt.Format(time.RFC3339)),
+		}, nil
+	})
+}
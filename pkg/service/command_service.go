@@ -0,0 +1,415 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+// commandName is the syntax of command names, without the leading slash.
+var commandName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// CommandCall is a command run by a member of a group.
+type CommandCall struct {
+	UserId  int
+	GroupId int
+	Name    string
+	// Text is what follows the command name, trimmed.
+	Text string
+}
+
+// CommandFunc serves a built-in command. A nil response acknowledges the
+// command without a reply.
+type CommandFunc func(ctx context.Context, call CommandCall) (*model.CommandResponse, error)
+
+type builtinCommand struct {
+	command model.Command
+	fn      CommandFunc
+}
+
+// CommandService dispatches slash commands. Built-in commands are registered
+// in Go with Register; groups can add commands served by external endpoints,
+// which receive a signed CommandInvocation and reply with a CommandResponse.
+type CommandService struct {
+	store    store.ContactsStore
+	access   access.ContactsAccess
+	messages *MessageService
+	events   *event.EventHandler
+	client   *http.Client
+	// allowInternal lets commands point to internal addresses
+	allowInternal bool
+
+	mu       sync.RWMutex
+	builtins map[string]builtinCommand
+}
+
+func NewCommandService(contactsStore store.ContactsStore, messages *MessageService, events *event.EventHandler, cfg config.CommandConfig) (*CommandService, error) {
+	s := CommandService{
+		store:         contactsStore,
+		messages:      messages,
+		events:        events,
+		client:        newOutboundClient(cfg.Timeout, cfg.AllowInternal),
+		allowInternal: cfg.AllowInternal,
+		builtins:      make(map[string]builtinCommand),
+	}
+	if err := s.registerBuiltins(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Register adds a built-in command available in every group.
+func (s *CommandService) Register(name, description, usage string, fn CommandFunc) error {
+	if !commandName.MatchString(name) {
+		return fmt.Errorf("invalid command name %q", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.builtins[name]; exists {
+		return fmt.Errorf("command %q already registered", name)
+	}
+	s.builtins[name] = builtinCommand{
+		command: model.Command{Name: name, Description: description, Usage: usage, Builtin: true},
+		fn:      fn,
+	}
+	return nil
+}
+
+func (s *CommandService) builtin(name string) (builtinCommand, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.builtins[name]
+	return b, ok
+}
+
+// ParseCommand splits a message of the form "/name text" into the command
+// name and its text. Messages starting with "//" are not commands; they are
+// sent with the first slash removed.
+func ParseCommand(content string) (name, text string, ok bool) {
+	if !strings.HasPrefix(content, "/") || strings.HasPrefix(content, "//") {
+		return "", "", false
+	}
+	name, text, _ = strings.Cut(strings.TrimPrefix(content, "/"), " ")
+	if name == "" {
+		return "", "", false
+	}
+	return strings.ToLower(name), strings.TrimSpace(text), true
+}
+
+// Execute runs the command for a member of the group. In-channel replies are
+// posted to the group; ephemeral replies are only published to the user.
+// Either way the reply is returned.
+func (s *CommandService) Execute(ctx context.Context, userId, groupId int, name, text string) (*model.CommandResponse, error) {
+	if err := s.messages.Contacts.CanPost(groupId, userId); err != nil {
+		return nil, err
+	}
+	call := CommandCall{UserId: userId, GroupId: groupId, Name: name, Text: text}
+
+	var resp *model.CommandResponse
+	var err error
+	if b, ok := s.builtin(name); ok {
+		resp, err = b.fn(ctx, call)
+	} else {
+		var command *model.Command
+		if command, err = s.getGroupCommand(groupId, name); err != nil {
+			return nil, err
+		}
+		resp = s.invoke(ctx, command, call)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return &model.CommandResponse{ResponseType: model.ResponseEphemeral}, nil
+	}
+	return s.reply(call, resp)
+}
+
+func (s *CommandService) getGroupCommand(groupId int, name string) (*model.Command, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	command, err := txc.GetCommandByName(groupId, name)
+	if err != nil {
+		return nil, &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("unknown command '/%s', see /help", name),
+		}
+	}
+	return command, nil
+}
+
+// reply delivers the response of a command.
+func (s *CommandService) reply(call CommandCall, resp *model.CommandResponse) (*model.CommandResponse, error) {
+	if len(resp.Attachments) > maxWebhookAttachments {
+		resp.Attachments = resp.Attachments[:maxWebhookAttachments]
+	}
+	if resp.ResponseType != model.ResponseInChannel {
+		resp.ResponseType = model.ResponseEphemeral
+		s.events.Publish(model.EventCommandReply, model.ChatEvent{
+			Type:        model.EventCommandReply,
+			GroupId:     call.GroupId,
+			ActorId:     call.UserId,
+			RecipientId: call.UserId,
+			Data:        resp,
+		})
+		return resp, nil
+	}
+	if resp.Message != nil {
+		return resp, nil
+	}
+	msg, err := s.messages.Post(PostParams{
+		ChatId:      call.GroupId,
+		SenderId:    "command:" + call.Name,
+		Username:    call.Name,
+		Content:     resp.Text,
+		Attachments: resp.Attachments,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Message = msg
+	return resp, nil
+}
+
+// invoke posts the call to the endpoint of an external command. Failures are
+// reported to the user as an ephemeral reply.
+func (s *CommandService) invoke(ctx context.Context, command *model.Command, call CommandCall) *model.CommandResponse {
+	resp, err := s.post(ctx, command, call)
+	if err != nil {
+		log.Warn().Err(err).Int("commandId", command.Id).Msg("invoke command")
+		return &model.CommandResponse{
+			ResponseType: model.ResponseEphemeral,
+			Text:         fmt.Sprintf("/%s failed to respond", command.Name),
+		}
+	}
+	return resp
+}
+
+func (s *CommandService) post(ctx context.Context, command *model.Command, call CommandCall) (*model.CommandResponse, error) {
+	profile, err := s.messages.Contacts.GetProfile(call.UserId)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(model.CommandInvocation{
+		Command:  command.Name,
+		Text:     call.Text,
+		GroupId:  call.GroupId,
+		UserId:   call.UserId,
+		UserName: profile.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, command.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gochat-command")
+	req.Header.Set("X-Gochat-Command", command.Name)
+	req.Header.Set("X-Gochat-Timestamp", timestamp)
+	req.Header.Set("X-Gochat-Signature", signPayload(command.Secret, timestamp, string(payload)))
+
+	httpResp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s", httpResp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	var resp model.CommandResponse
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	resp.Message = nil
+	return &resp, nil
+}
+
+// List returns the commands available in the group whose name starts with
+// prefix, for help and autocompletion. Endpoints of external commands are
+// only shown to members who can manage them.
+func (s *CommandService) List(userId, groupId int, prefix string) ([]model.Command, error) {
+	prefix = strings.ToLower(strings.TrimPrefix(prefix, "/"))
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return nil, &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("cannot find group %d for user %d", groupId, userId),
+		}
+	}
+	manage := s.access.Can(member.Role, member.Role, access.ActionManageCmds)
+	groupCommands, err := txc.GetCommands(groupId)
+	if err != nil {
+		return nil, fmt.Errorf("GetCommands: %w", err)
+	}
+
+	commands := make([]model.Command, 0)
+	s.mu.RLock()
+	for name, b := range s.builtins {
+		if strings.HasPrefix(name, prefix) {
+			commands = append(commands, b.command)
+		}
+	}
+	s.mu.RUnlock()
+	for _, command := range groupCommands {
+		if !strings.HasPrefix(command.Name, prefix) {
+			continue
+		}
+		command.Secret = ""
+		if !manage {
+			command.URL = ""
+		}
+		commands = append(commands, command)
+	}
+	slices.SortFunc(commands, func(a, b model.Command) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return commands, nil
+}
+
+// CommandParams describe an external command of a group.
+type CommandParams struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Usage       string `json:"usage"`
+	URL         string `json:"url"`
+}
+
+// CreateCommand adds an external command to the group. The returned command
+// carries the secret signing its invocations.
+func (s *CommandService) CreateCommand(userId, groupId int, params CommandParams) (*model.Command, error) {
+	name := strings.TrimPrefix(params.Name, "/")
+	if !commandName.MatchString(name) {
+		return nil, &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrBadRequest,
+			Message: "name must be 1 to 32 lowercase letters, digits, '-' or '_'",
+		}
+	}
+	if _, exists := s.builtin(name); exists {
+		return nil, &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrExists,
+			Message: fmt.Sprintf("'/%s' is a built-in command", name),
+		}
+	}
+	if err := checkOutboundURL(params.URL, s.allowInternal); err != nil {
+		return nil, &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrBadRequest,
+			Message: err.Error(),
+		}
+	}
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkManage(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	command, err := txc.CreateCommand(model.Command{
+		GroupId:     groupId,
+		Name:        name,
+		Description: strings.TrimSpace(params.Description),
+		Usage:       strings.TrimSpace(params.Usage),
+		URL:         params.URL,
+		CreatedBy:   userId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+func (s *CommandService) DeleteCommand(userId, groupId, commandId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkManage(txc, groupId, userId); err != nil {
+		return err
+	}
+	command, err := txc.GetCommand(commandId)
+	if err != nil {
+		return err
+	}
+	if command.GroupId != groupId {
+		return &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("command '%d' not found", commandId),
+		}
+	}
+	if err = txc.DeleteCommand(commandId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+// checkManage fails unless the user may manage the commands of the group.
+func (s *CommandService) checkManage(txc store.TxContacts, groupId, userId int) error {
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("cannot find group %d for user %d", groupId, userId),
+		}
+	}
+	if !s.access.Can(member.Role, member.Role, access.ActionManageCmds) {
+		return &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrPermissionDenied,
+			Message: "only managers and owners can manage commands",
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func newTestCommandService(t *testing.T) (*CommandService, *MessageService, *PresetResult) {
+	t.Helper()
+	webhooks, messages, result := newTestWebhookService(t, config.WebhookConfig{})
+	commands, err := NewCommandService(webhooks.store, messages, messages.events, config.CommandConfig{Timeout: time.Second, AllowInternal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commands, messages, result
+}
+
+func TestParseCommand(t *testing.T) {
+	testCases := map[string]struct {
+		content  string
+		wantName string
+		wantText string
+		wantOk   bool
+	}{
+		"command":      {content: "/help", wantName: "help", wantOk: true},
+		"with text":    {content: "/Me  waves ", wantName: "me", wantText: "waves", wantOk: true},
+		"plain":        {content: "hello /help"},
+		"escaped":      {content: "//help"},
+		"only a slash": {content: "/ help"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			gotName, gotText, ok := ParseCommand(tc.content)
+			if gotName != tc.wantName || gotText != tc.wantText || ok != tc.wantOk {
+				t.Errorf("expected (%q, %q, %v), but got (%q, %q, %v)", tc.wantName, tc.wantText, tc.wantOk, gotName, gotText, ok)
+			}
+		})
+	}
+}
+
+func TestCommandService_Builtin(t *testing.T) {
+	commands, messages, result := newTestCommandService(t)
+	p1, _ := result.GetProfile("p1")
+	g1, _ := result.GetGroup("g1")
+
+	var mu sync.Mutex
+	var replies []model.ChatEvent
+	if err := messages.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	messages.Subscribe(t.Context(), p1.Id, func(e model.ChatEvent) error {
+		if e.Type == model.EventCommandReply {
+			mu.Lock()
+			replies = append(replies, e)
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	resp, err := commands.Execute(t.Context(), p1.Id, g1.Id, "help", "sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ResponseType != model.ResponseEphemeral || !strings.HasPrefix(resp.Text, "/shrug") {
+		t.Errorf("unexpected help reply %+v", resp)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(replies) == 1
+	})
+	if replies[0].RecipientId != p1.Id {
+		t.Errorf("expected reply to user %d, but got %d", p1.Id, replies[0].RecipientId)
+	}
+
+	resp, err = commands.Execute(t.Context(), p1.Id, g1.Id, "shrug", "oh well")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message == nil || resp.Message.SenderId != "1" || resp.Message.Content != "oh well "+shrug {
+		t.Errorf("unexpected shrug reply %+v", resp)
+	}
+
+	if _, err = commands.Execute(t.Context(), p1.Id, g1.Id, "nope", ""); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+	if _, err = commands.Execute(t.Context(), 99, g1.Id, "help", ""); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+}
+
+func TestCommandService_PollAndRemind(t *testing.T) {
+	commands, messages, result := newTestCommandService(t)
+	p1, _ := result.GetProfile("p1")
+	g1, _ := result.GetGroup("g1")
+
+	polls, err := NewPollService(commands.store, messages, messages.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedules, err := NewScheduleService(commands.store, messages, messages.events, config.ScheduleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = commands.RegisterPolls(polls); err != nil {
+		t.Fatal(err)
+	}
+	if err = commands.RegisterReminders(schedules); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := commands.Execute(t.Context(), p1.Id, g1.Id, "poll", "lunch?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Text, "usage: /poll") {
+		t.Errorf("unexpected poll usage reply %+v", resp)
+	}
+	if _, err = commands.Execute(t.Context(), p1.Id, g1.Id, "poll", "lunch? | pizza | sushi"); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := messages.List(p1.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Type != model.MessagePoll || msgs[0].Content != "lunch?" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+
+	if resp, err = commands.Execute(t.Context(), p1.Id, g1.Id, "remind", "soon"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Text, "usage: /remind") {
+		t.Errorf("unexpected remind usage reply %+v", resp)
+	}
+	if _, err = commands.Execute(t.Context(), p1.Id, g1.Id, "remind", "30m check the build"); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := schedules.List(p1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Kind != model.JobReminder || jobs[0].Content != "check the build" {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+}
+
+func TestCommandService_External(t *testing.T) {
+	commands, messages, result := newTestCommandService(t)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	invocations := make(chan webhookRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		invocations <- webhookRequest{header: r.Header, body: string(body)}
+		json.NewEncoder(w).Encode(model.CommandResponse{
+			ResponseType: model.ResponseInChannel,
+			Text:         "deploying",
+		})
+	}))
+	defer srv.Close()
+
+	params := CommandParams{Name: "deploy", Description: "Deploy a branch", Usage: "<branch>", URL: srv.URL}
+	if _, err := commands.CreateCommand(p2.Id, g1.Id, params); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if _, err := commands.CreateCommand(p3.Id, g1.Id, CommandParams{Name: "help", URL: srv.URL}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("expected error %q, but got %q", store.ErrExists, err)
+	}
+	// endpoints on internal addresses are refused unless allowed
+	commands.allowInternal = false
+	if _, err := commands.CreateCommand(p3.Id, g1.Id, params); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+	commands.allowInternal = true
+	command, err := commands.CreateCommand(p3.Id, g1.Id, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := commands.List(p2.Id, g1.Id, "/de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Name != "deploy" || listed[0].URL != "" || listed[0].Secret != "" {
+		t.Errorf("unexpected commands %+v", listed)
+	}
+
+	resp, err := commands.Execute(t.Context(), p2.Id, g1.Id, "deploy", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-invocations
+	var invocation model.CommandInvocation
+	if err = json.Unmarshal([]byte(req.body), &invocation); err != nil {
+		t.Fatal(err)
+	}
+	if invocation.Text != "main" || invocation.UserId != p2.Id || invocation.UserName != "p2" {
+		t.Errorf("unexpected invocation %+v", invocation)
+	}
+	want := signPayload(command.Secret, req.header.Get("X-Gochat-Timestamp"), req.body)
+	if got := req.header.Get("X-Gochat-Signature"); got != want {
+		t.Errorf("expected signature %q, but got %q", want, got)
+	}
+	if resp.Message == nil || resp.Message.SenderId != "command:deploy" || !resp.Message.Bot {
+		t.Errorf("unexpected reply %+v", resp)
+	}
+	msgs, err := messages.List(p1.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "deploying" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+
+	if err = commands.DeleteCommand(p1.Id, g1.Id, command.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = commands.Execute(t.Context(), p2.Id, g1.Id, "deploy", ""); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// Membership is a group of a user with the role the user has in it.
+type Membership struct {
+	Group    model.Group `json:"group"`
+	Role     model.Role  `json:"role"`
+	JoinedAt time.Time   `json:"joined_at"`
+}
+
+// Memberships returns the groups of the user with its role in them.
+func (s *ContactsService) Memberships(userId int) ([]Membership, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	groups, err := txc.GetGroups(userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetGroups: %w", err)
+	}
+	memberships := make([]Membership, 0, len(groups))
+	for _, group := range groups {
+		member, err := txc.GetMember(group.Id, userId)
+		if err != nil {
+			return nil, fmt.Errorf("GetMember: %w", err)
+		}
+		memberships = append(memberships, Membership{
+			Group:    group,
+			Role:     member.Role,
+			JoinedAt: member.CreatedAt,
+		})
+	}
+	return memberships, nil
+}
+
+// deleteAccount removes the user from all groups and deletes its profile,
+// contacts, pending contact and join requests, blocks and scheduled jobs.
+// Groups owned by the user are handed to the longest standing manager, or
+// member if there is no manager, and deleted if the user was the last member.
+// Deleting the account of a user without a profile is a no-op.
+func (s *ContactsService) deleteAccount(userId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	groups, err := txc.GetGroups(userId)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("GetGroups: %w", err)
+	}
+	left := make([]model.Member, 0, len(groups))
+	successors := make([]model.Member, 0)
+	for _, group := range groups {
+		member, successor, err := s.leaveGroup(txc, group, userId)
+		if err != nil {
+			return fmt.Errorf("leave group %d: %w", group.Id, err)
+		}
+		if member != nil {
+			left = append(left, *member)
+		}
+		if successor != nil {
+			successors = append(successors, *successor)
+		}
+	}
+
+	contacts, err := txc.GetContacts(userId)
+	if err != nil {
+		return fmt.Errorf("GetContacts: %w", err)
+	}
+	for _, contact := range contacts {
+		if err = txc.DeleteContact(userId, contact.UserId); err != nil {
+			return fmt.Errorf("DeleteContact: %w", err)
+		}
+	}
+	reqs, err := txc.GetContactRequests(userId)
+	if err != nil {
+		return fmt.Errorf("GetContactRequests: %w", err)
+	}
+	for _, req := range reqs {
+		if err = txc.UpdateContactRequest(req.Id, model.ContactRequestRejected); err != nil {
+			return fmt.Errorf("UpdateContactRequest: %w", err)
+		}
+	}
+	blocks, err := txc.GetBlocks(userId)
+	if err != nil {
+		return fmt.Errorf("GetBlocks: %w", err)
+	}
+	for _, block := range blocks {
+		if err = txc.DeleteBlock(userId, block.BlockedId); err != nil {
+			return fmt.Errorf("DeleteBlock: %w", err)
+		}
+	}
+	if err = txc.DeleteJoinRequests(userId); err != nil {
+		return fmt.Errorf("DeleteJoinRequests: %w", err)
+	}
+	jobs, err := txc.GetJobs(userId, model.JobPending)
+	if err != nil {
+		return fmt.Errorf("GetJobs: %w", err)
+	}
+	for _, job := range jobs {
+		if err = txc.DeleteJob(job.Id); err != nil {
+			return fmt.Errorf("DeleteJob: %w", err)
+		}
+	}
+	if err = txc.DeleteProfile(userId); err != nil {
+		return fmt.Errorf("DeleteProfile: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return err
+	}
+	for _, member := range left {
+		s.publish(model.EventMemberRemoved, member.GroupId, userId, member)
+	}
+	for _, member := range successors {
+		s.publish(model.EventMemberUpdated, member.GroupId, userId, member)
+	}
+	return nil
+}
+
+// leaveGroup removes the user from the group, handing the group over first
+// if the user owns it. It returns the removed member, or nil if the group was
+// deleted, and the member the group was handed to, if any.
+func (s *ContactsService) leaveGroup(txc store.TxContacts, group model.Group, userId int) (*model.Member, *model.Member, error) {
+	members, err := txc.GetMembers(group.Id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetMembers: %w", err)
+	}
+	var self *model.Member
+	others := make([]model.Member, 0, len(members))
+	for _, m := range members {
+		if m.UserId == userId {
+			self = &m
+			continue
+		}
+		others = append(others, m)
+	}
+	if self == nil {
+		return nil, nil, nil
+	}
+	if err = txc.DeleteMute(userId, group.Id); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, nil, fmt.Errorf("DeleteMute: %w", err)
+	}
+	if err = s.deleteMember(txc, group.Id, userId); err != nil {
+		return nil, nil, fmt.Errorf("deleteMember: %w", err)
+	}
+	if self.Role != access.RoleOwner || group.Direct {
+		return self, nil, nil
+	}
+	if len(others) == 0 {
+		return nil, nil, s.deleteGroup(txc, group.Id)
+	}
+	successor := slices.MinFunc(others, func(a, b model.Member) int {
+		if a.Role != b.Role {
+			if a.Role == access.RoleManager {
+				return -1
+			}
+			if b.Role == access.RoleManager {
+				return 1
+			}
+		}
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.UserId, b.UserId))
+	})
+	if err = txc.SetMemberRole(group.Id, successor.UserId, access.RoleOwner); err != nil {
+		return nil, nil, fmt.Errorf("SetMemberRole: %w", err)
+	}
+	successor.Role = access.RoleOwner
+	return self, &successor, nil
+}
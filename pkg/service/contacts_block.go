@@ -0,0 +1,310 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// Block prevents the target from contacting the user. Existing contact links
+// and pending requests between them are removed.
+func (s *ContactsService) Block(userId, targetId int) (*model.Block, error) {
+	if userId == targetId {
+		return nil, &store.Error{
+			Kind:    store.KindBlock,
+			Err:     store.ErrBadRequest,
+			Message: "cannot block yourself",
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if _, err = txc.GetProfile(targetId); err != nil {
+		return nil, err
+	}
+	block, err := txc.CreateBlock(userId, targetId)
+	if err != nil {
+		return nil, err
+	}
+	if err = txc.DeleteContact(userId, targetId); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("DeleteContact: %w", err)
+	}
+	if err = txc.RejectContactRequests(userId, targetId); err != nil {
+		return nil, fmt.Errorf("RejectContactRequests: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (s *ContactsService) Unblock(userId, targetId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = txc.DeleteBlock(userId, targetId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+func (s *ContactsService) ListBlocks(userId int) ([]model.Block, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	return txc.GetBlocks(userId)
+}
+
+// BlockedIds returns the set of users blocked by the user.
+func (s *ContactsService) BlockedIds(userId int) (map[int]bool, error) {
+	blocks, err := s.ListBlocks(userId)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[int]bool, len(blocks))
+	for _, block := range blocks {
+		ids[block.BlockedId] = true
+	}
+	return ids, nil
+}
+
+// checkBlocked fails if either user has blocked the other.
+func (s *ContactsService) checkBlocked(txc store.TxContacts, userId, targetId int) error {
+	for _, pair := range [][2]int{{targetId, userId}, {userId, targetId}} {
+		blocked, err := txc.BlockExists(pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return &store.Error{
+				Kind:    store.KindBlock,
+				Err:     store.ErrPermissionDenied,
+				Message: fmt.Sprintf("user '%d' is not reachable", targetId),
+			}
+		}
+	}
+	return nil
+}
+
+// CanPost fails if the user may not post messages to the group: if the
+// posting mode of the group excludes its role, it is restricted, or it is
+// blocked in a direct group.
+func (s *ContactsService) CanPost(groupId, userId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	group, err := s.getMemberGroup(txc, groupId, userId)
+	if err != nil {
+		return err
+	}
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return fmt.Errorf("GetMember: %w", err)
+	}
+	if !s.access.Permits(group.Settings.Post, member.Role) {
+		return &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrPermissionDenied,
+			Message: fmt.Sprintf("%s role cannot post in group %d", member.Role, groupId),
+		}
+	}
+	restriction, err := txc.GetRestriction(userId, groupId)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("GetRestriction: %w", err)
+	}
+	if restriction != nil {
+		message := fmt.Sprintf("user %d cannot post in group %d", userId, groupId)
+		if restriction.Until != nil {
+			message += " until " + restriction.Until.Format(time.RFC3339)
+		}
+		return &store.Error{
+			Kind:    store.KindRestrict,
+			Err:     store.ErrPermissionDenied,
+			Message: message,
+		}
+	}
+	if group.Direct {
+		members, err := txc.GetMembers(groupId)
+		if err != nil {
+			return fmt.Errorf("GetMembers: %w", err)
+		}
+		for _, m := range members {
+			if m.UserId == userId {
+				continue
+			}
+			if err = s.checkBlocked(txc, userId, m.UserId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getMemberGroup returns the group if the user is a member of it.
+func (s *ContactsService) getMemberGroup(txc store.TxContacts, groupId, userId int) (*model.Group, error) {
+	if exists, _ := txc.MemberExists(groupId, userId); !exists {
+		return nil, &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("cannot find group %d for user %d", groupId, userId),
+		}
+	}
+	return txc.GetGroup(groupId)
+}
+
+// Mute suppresses notifications of the group for the user until the given
+// time, or until unmuted if until is nil.
+func (s *ContactsService) Mute(userId, groupId int, until *time.Time) (*model.Mute, error) {
+	if until != nil && until.Before(time.Now()) {
+		return nil, &store.Error{
+			Kind:    store.KindMute,
+			Err:     store.ErrBadRequest,
+			Message: "until must be in the future",
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if _, err = s.getMemberGroup(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	mute, err := txc.SetMute(userId, groupId, until)
+	if err != nil {
+		return nil, fmt.Errorf("SetMute: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return mute, nil
+}
+
+func (s *ContactsService) Unmute(userId, groupId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = txc.DeleteMute(userId, groupId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+// Restrict revokes the permission of the target to post in the group until
+// the given time, or until lifted if until is nil. Managers may restrict
+// members, the owner may also restrict managers.
+func (s *ContactsService) Restrict(groupId, userId, targetId int, until *time.Time) (*model.Restriction, error) {
+	if until != nil && until.Before(time.Now()) {
+		return nil, &store.Error{
+			Kind:    store.KindRestrict,
+			Err:     store.ErrBadRequest,
+			Message: "until must be in the future",
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkRestrict(txc, groupId, userId, targetId); err != nil {
+		return nil, err
+	}
+	restriction, err := txc.SetRestriction(targetId, groupId, userId, until)
+	if err != nil {
+		return nil, fmt.Errorf("SetRestriction: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return restriction, nil
+}
+
+// Unrestrict lifts the restriction of the target in the group.
+func (s *ContactsService) Unrestrict(groupId, userId, targetId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkRestrict(txc, groupId, userId, targetId); err != nil {
+		return err
+	}
+	if err = txc.DeleteRestriction(targetId, groupId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+// checkRestrict fails unless the role of the user allows it to restrict the
+// target.
+func (s *ContactsService) checkRestrict(txc store.TxContacts, groupId, userId, targetId int) error {
+	actMbr, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return err
+	}
+	tgtMbr, err := txc.GetMember(groupId, targetId)
+	if err != nil {
+		return err
+	}
+	if !s.access.Can(actMbr.Role, tgtMbr.Role, access.ActionRestrict) {
+		return &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrPermissionDenied,
+			Message: fmt.Sprintf("%s role cannot restrict a %s", actMbr.Role, tgtMbr.Role),
+		}
+	}
+	return nil
+}
+
+// Audience returns which of the users an event of the actor in the group is
+// delivered to, and whether each of them should be notified about it.
+func (s *ContactsService) Audience(groupId, actorId int, userIds []int) (map[int]bool, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	audience := make(map[int]bool, len(userIds))
+	for _, userId := range userIds {
+		if exists, _ := txc.MemberExists(groupId, userId); !exists {
+			continue
+		}
+		blocked, err := txc.BlockExists(userId, actorId)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			continue
+		}
+		if actorId == userId {
+			audience[userId] = false
+			continue
+		}
+		_, err = txc.GetMute(userId, groupId)
+		audience[userId] = err != nil
+	}
+	return audience, nil
+}
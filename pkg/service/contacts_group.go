@@ -0,0 +1,190 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+const (
+	maxGroupName   = 50
+	maxDescription = 500
+	maxAvatarURL   = 2048
+)
+
+// GroupUpdate changes the metadata of a group. Nil fields are left as they
+// are.
+type GroupUpdate struct {
+	Name        *string         `json:"name"`
+	Description *string         `json:"description"`
+	Avatar      *string         `json:"avatar"`
+	Settings    *SettingsUpdate `json:"settings"`
+}
+
+// SettingsUpdate changes the settings of a group. Nil fields are left as
+// they are.
+type SettingsUpdate struct {
+	Post         *model.Permission `json:"post"`
+	Invite       *model.Permission `json:"invite"`
+	JoinApproval *bool             `json:"join_approval"`
+	Public       *bool             `json:"public"`
+}
+
+// UpdateGroup changes the name, description, avatar and settings of the
+// group. Managers may edit the group, only the owner may change its
+// settings. Direct groups cannot be edited.
+func (s *ContactsService) UpdateGroup(groupId, userId int, update GroupUpdate) (*model.Group, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	group, err := s.getMemberGroup(txc, groupId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if group.Direct {
+		return nil, &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrBadRequest,
+			Message: "direct groups cannot be edited",
+		}
+	}
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetMember: %w", err)
+	}
+	if update.Name != nil || update.Description != nil || update.Avatar != nil {
+		if err = s.checkRole(member.Role, access.ActionEditGroup); err != nil {
+			return nil, err
+		}
+	}
+	if update.Settings != nil {
+		if err = s.checkRole(member.Role, access.ActionSettings); err != nil {
+			return nil, err
+		}
+	}
+
+	changed, err := applyGroupUpdate(group, update)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) == 0 {
+		return group, nil
+	}
+	group.UpdatedAt = time.Now()
+	if err = txc.UpdateGroup(*group); err != nil {
+		return nil, fmt.Errorf("UpdateGroup: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	s.publish(model.EventGroupUpdated, groupId, userId, model.GroupUpdated{
+		Group:   *group,
+		Changed: changed,
+	})
+	return group, nil
+}
+
+// checkRole fails unless the role may take the action.
+func (s *ContactsService) checkRole(role model.Role, action access.Action) error {
+	if !s.access.Can(role, role, action) {
+		return &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrPermissionDenied,
+			Message: fmt.Sprintf("%s role cannot %s", role, action),
+		}
+	}
+	return nil
+}
+
+// applyGroupUpdate validates the update, applies it to the group and returns
+// the changed fields.
+func applyGroupUpdate(group *model.Group, update GroupUpdate) ([]string, error) {
+	changed := make([]string, 0)
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if n := utf8.RuneCountInString(name); n < 2 || n > maxGroupName {
+			return nil, errBadGroup(fmt.Sprintf("name must be between 2 and %d characters long", maxGroupName))
+		}
+		if name != group.Name {
+			group.Name = name
+			changed = append(changed, "name")
+		}
+	}
+	if update.Description != nil {
+		description := strings.TrimSpace(*update.Description)
+		if utf8.RuneCountInString(description) > maxDescription {
+			return nil, errBadGroup(fmt.Sprintf("description must be at most %d characters long", maxDescription))
+		}
+		if description != group.Description {
+			group.Description = description
+			changed = append(changed, "description")
+		}
+	}
+	if update.Avatar != nil {
+		avatar := strings.TrimSpace(*update.Avatar)
+		if avatar != "" && !validAvatar(avatar) {
+			return nil, errBadGroup("avatar must be an http or https URL")
+		}
+		if avatar != group.Avatar {
+			group.Avatar = avatar
+			changed = append(changed, "avatar")
+		}
+	}
+	if update.Settings != nil {
+		settings := group.Settings
+		if p := update.Settings.Post; p != nil {
+			settings.Post = *p
+		}
+		if p := update.Settings.Invite; p != nil {
+			settings.Invite = *p
+		}
+		if a := update.Settings.JoinApproval; a != nil {
+			settings.JoinApproval = *a
+		}
+		if p := update.Settings.Public; p != nil {
+			settings.Public = *p
+		}
+		if !validPermission(settings.Post) || !validPermission(settings.Invite) {
+			return nil, errBadGroup(fmt.Sprintf("permissions must be %q, %q or %q",
+				model.PermissionEveryone, model.PermissionManagers, model.PermissionOwner))
+		}
+		if settings != group.Settings {
+			group.Settings = settings
+			changed = append(changed, "settings")
+		}
+	}
+	return changed, nil
+}
+
+func validPermission(p model.Permission) bool {
+	switch p {
+	case model.PermissionEveryone, model.PermissionManagers, model.PermissionOwner:
+		return true
+	}
+	return false
+}
+
+func validAvatar(avatar string) bool {
+	if len(avatar) > maxAvatarURL {
+		return false
+	}
+	u, err := url.Parse(avatar)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func errBadGroup(message string) error {
+	return &store.Error{
+		Kind:    store.KindGroup,
+		Err:     store.ErrBadRequest,
+		Message: message,
+	}
+}
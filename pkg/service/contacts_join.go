@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+const (
+	defaultDiscoverLimit = 20
+	maxDiscoverLimit     = 50
+)
+
+// Discover returns public groups whose name or description contains the
+// query, or all public groups if it is empty.
+func (s *ContactsService) Discover(query string, limit int) ([]model.Group, error) {
+	if limit <= 0 {
+		limit = defaultDiscoverLimit
+	}
+	limit = min(limit, maxDiscoverLimit)
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	groups, err := txc.SearchGroups(strings.TrimSpace(query), limit)
+	if err != nil {
+		return nil, fmt.Errorf("SearchGroups: %w", err)
+	}
+	return groups, nil
+}
+
+// Join adds the user to a public group. If the group requires approval a
+// join request is filed instead and returned with a nil member.
+func (s *ContactsService) Join(groupId, userId int) (*model.Member, *model.JoinRequest, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer txc.Rollback()
+
+	group, err := txc.GetGroup(groupId)
+	if err != nil || !group.Settings.Public || group.Direct {
+		return nil, nil, &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("group '%d' not found", groupId),
+		}
+	}
+	if exists, err := txc.MemberExists(groupId, userId); err != nil || exists {
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrExists,
+			Message: fmt.Sprintf("user '%d' is already a member of group '%d'", userId, groupId),
+		}
+	}
+
+	if group.Settings.JoinApproval {
+		req, err := txc.CreateJoinRequest(groupId, userId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("CreateJoinRequest: %w", err)
+		}
+		if err = txc.Commit(); err != nil {
+			return nil, nil, err
+		}
+		return nil, req, nil
+	}
+
+	member, err := s.join(txc, groupId, userId, access.RoleMember)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, nil, err
+	}
+	s.publish(model.EventMemberAdded, groupId, userId, member)
+	return member, nil, nil
+}
+
+// JoinRequests returns the pending requests to join the group.
+func (s *ContactsService) JoinRequests(groupId, userId int) ([]model.JoinRequest, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkJoinRequests(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	reqs, err := txc.GetJoinRequests(groupId)
+	if err != nil {
+		return nil, fmt.Errorf("GetJoinRequests: %w", err)
+	}
+	return reqs, nil
+}
+
+// DecideJoinRequest approves or denies a pending request to join the group.
+// Approving it adds the requester as a member.
+func (s *ContactsService) DecideJoinRequest(groupId, userId, requestId int, approve bool) (*model.JoinRequest, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkJoinRequests(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	req, err := txc.GetJoinRequest(requestId)
+	if err != nil {
+		return nil, err
+	}
+	if req.GroupId != groupId {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("join request '%d' not found", requestId),
+		}
+	}
+	if req.Status != model.JoinRequestPending {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("join request '%d' is already %s", requestId, req.Status),
+		}
+	}
+
+	status := model.JoinRequestDenied
+	var member *model.Member
+	if approve {
+		status = model.JoinRequestApproved
+		if member, err = s.join(txc, groupId, req.UserId, access.RoleMember); err != nil {
+			return nil, err
+		}
+	}
+	if err = txc.UpdateJoinRequest(req.Id, status, userId); err != nil {
+		return nil, fmt.Errorf("UpdateJoinRequest: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	if member != nil {
+		s.publish(model.EventMemberAdded, groupId, userId, member)
+	}
+	req.Status = status
+	req.DecidedBy = userId
+	return req, nil
+}
+
+// checkJoinRequests fails unless the user may review join requests of the
+// group.
+func (s *ContactsService) checkJoinRequests(txc store.TxContacts, groupId, userId int) error {
+	if _, err := s.getMemberGroup(txc, groupId, userId); err != nil {
+		return err
+	}
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return fmt.Errorf("GetMember: %w", err)
+	}
+	return s.checkRole(member.Role, access.ActionJoinRequests)
+}
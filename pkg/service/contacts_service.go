@@ -1,9 +1,13 @@
 package service
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/event"
 	"github.com/elug3/gochat/pkg/model"
 	"github.com/elug3/gochat/pkg/store"
 )
@@ -11,15 +15,29 @@ import (
 type ContactsService struct {
 	store  store.ContactsStore
 	access access.ContactsAccess
+	events *event.EventHandler
+
+	// touched holds the last time the presence of a user was written.
+	touched sync.Map
 }
 
-func NewContactsService(contactsStore store.ContactsStore) (*ContactsService, error) {
+func NewContactsService(contactsStore store.ContactsStore, events *event.EventHandler) (*ContactsService, error) {
 	s := ContactsService{
-		store: contactsStore,
+		store:  contactsStore,
+		events: events,
 	}
 	return &s, nil
 }
 
+func (s *ContactsService) publish(typ string, groupId, actorId int, data any) {
+	s.events.Publish(typ, model.ChatEvent{
+		Type:    typ,
+		GroupId: groupId,
+		ActorId: actorId,
+		Data:    data,
+	})
+}
+
 // func (s *ContactsService) GetGroups(userId string) (groups []model.Group, err error) {
 // }
 
@@ -110,16 +128,24 @@ func (s *ContactsService) DeleteGroup(groupId, userId int) error {
 }
 
 func (s *ContactsService) deleteGroup(txc store.TxContacts, id int) error {
-	err := txc.DeleteGroup(id)
-	return err
+	if err := txc.DeleteGroupJoinRequests(id); err != nil {
+		return fmt.Errorf("DeleteGroupJoinRequests: %w", err)
+	}
+	return txc.DeleteGroup(id)
 }
 
+// canInvite reports whether the settings of the group let the inviter add
+// members.
 func (s *ContactsService) canInvite(txc store.TxContacts, groupId, inviterId int) bool {
 	actMbr, err := txc.GetMember(groupId, inviterId)
 	if err != nil {
 		return false
 	}
-	return s.access.Can(actMbr.Role, actMbr.Role, access.ActionInvite)
+	group, err := txc.GetGroup(groupId)
+	if err != nil {
+		return false
+	}
+	return s.access.Permits(group.Settings.Invite, actMbr.Role)
 }
 
 func (s *ContactsService) Invite(groupId, inviterId, inviteeId int) (*model.Member, error) {
@@ -136,6 +162,12 @@ func (s *ContactsService) Invite(groupId, inviterId, inviteeId int) (*model.Memb
 			Message: "persission denided",
 		}
 	}
+	if err = s.checkBlocked(txc, inviterId, inviteeId); err != nil {
+		return nil, err
+	}
+	if err = s.checkContactsOnly(txc, inviterId, inviteeId); err != nil {
+		return nil, err
+	}
 	member, err := s.join(txc, groupId, inviteeId, access.RoleMember)
 	if err != nil {
 		return nil, err
@@ -144,6 +176,7 @@ func (s *ContactsService) Invite(groupId, inviterId, inviteeId int) (*model.Memb
 	if err = txc.Commit(); err != nil {
 		return nil, err
 	}
+	s.publish(model.EventMemberAdded, groupId, inviterId, member)
 	return member, nil
 }
 
@@ -165,7 +198,12 @@ func (s *ContactsService) ListMember(groupId int) ([]model.Member, error) {
 	return members, nil
 }
 
+// DeleteMember removes the target from the group. Members may always remove
+// themselves, which is the same as Leave.
 func (s *ContactsService) DeleteMember(groupId, userId, targetId int) error {
+	if userId == targetId {
+		return s.Leave(groupId, userId)
+	}
 	txc, err := s.store.Begin()
 	if err != nil {
 		return err
@@ -181,18 +219,100 @@ func (s *ContactsService) DeleteMember(groupId, userId, targetId int) error {
 		return err
 	}
 	if ok := s.access.Can(actMbr.Role, tgtMbr.Role, access.ActionDeleteMember); !ok {
-		return fmt.Errorf("permission denided")
+		return &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrPermissionDenied,
+			Message: fmt.Sprintf("%s role cannot remove a %s", actMbr.Role, tgtMbr.Role),
+		}
 	}
 
-	if err := s.deleteMember(txc, groupId, userId); err != nil {
+	if err = txc.DeleteMute(targetId, groupId); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("DeleteMute: %w", err)
+	}
+	if err := s.deleteMember(txc, groupId, targetId); err != nil {
 		return fmt.Errorf("deleteMember: %w", err)
 	}
 	if err = txc.Commit(); err != nil {
 		return err
 	}
+	s.publish(model.EventMemberRemoved, groupId, userId, tgtMbr)
 	return nil
 }
 
+// Leave removes the user from the group. An owner hands the group over to
+// the longest standing manager, or member, and deletes it if it was the last
+// member.
+func (s *ContactsService) Leave(groupId, userId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	group, err := s.getMemberGroup(txc, groupId, userId)
+	if err != nil {
+		return err
+	}
+	member, successor, err := s.leaveGroup(txc, *group, userId)
+	if err != nil {
+		return err
+	}
+	if err = txc.Commit(); err != nil {
+		return err
+	}
+	if member != nil {
+		s.publish(model.EventMemberRemoved, groupId, userId, member)
+	}
+	if successor != nil {
+		s.publish(model.EventMemberUpdated, groupId, userId, successor)
+	}
+	return nil
+}
+
+// SetRole changes the role of the target to member or manager.
+func (s *ContactsService) SetRole(groupId, userId, targetId int, role model.Role) (*model.Member, error) {
+	if role != access.RoleMember && role != access.RoleManager {
+		return nil, &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("role must be %q or %q", access.RoleMember, access.RoleManager),
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	actMbr, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return nil, err
+	}
+	tgtMbr, err := txc.GetMember(groupId, targetId)
+	if err != nil {
+		return nil, err
+	}
+	if !s.access.Can(actMbr.Role, tgtMbr.Role, access.ActionSetRole) {
+		return nil, &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrPermissionDenied,
+			Message: fmt.Sprintf("%s role cannot %s", actMbr.Role, access.ActionSetRole),
+		}
+	}
+	if tgtMbr.Role == role {
+		return tgtMbr, nil
+	}
+	if err = txc.SetMemberRole(groupId, targetId, role); err != nil {
+		return nil, fmt.Errorf("SetMemberRole: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	tgtMbr.Role = role
+	s.publish(model.EventMemberUpdated, groupId, userId, tgtMbr)
+	return tgtMbr, nil
+}
+
 func (s *ContactsService) deleteMember(txc store.TxContacts, groupId, userId int) error {
 	return txc.DeleteMember(groupId, userId)
 }
@@ -215,6 +335,30 @@ func (s *ContactsService) CreateProfile(userId int, name string) (*model.Profile
 	return profile, nil
 }
 
+// CreateBotProfile creates the profile of a bot user, flagged as a bot.
+func (s *ContactsService) CreateBotProfile(userId int, name string) (*model.Profile, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if _, err = txc.CreateProfile(userId, name); err != nil {
+		return nil, err
+	}
+	if err = txc.SetBot(userId); err != nil {
+		return nil, fmt.Errorf("SetBot: %w", err)
+	}
+	profile, err := txc.GetProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
 func (s *ContactsService) DeleteProfile(userId int) error {
 	txc, err := s.store.Begin()
 	if err != nil {
@@ -228,6 +372,415 @@ func (s *ContactsService) DeleteProfile(userId int) error {
 	return nil
 }
 
-// func (s *ContactsService) Can(userId, targetId, int, action access.Action) (bool, error) {
+// onlineWindow is how long after the last activity a user is reported online.
+const onlineWindow = 5 * time.Minute
 
-// }
+// touchInterval is the minimum time between two presence writes of a user.
+const touchInterval = time.Minute
+
+// Touch records activity of the user for presence. Writes are throttled to
+// one per touchInterval.
+func (s *ContactsService) Touch(userId int) error {
+	now := time.Now()
+	if last, ok := s.touched.Load(userId); ok && now.Sub(last.(time.Time)) < touchInterval {
+		return nil
+	}
+	s.touched.Store(userId, now)
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = txc.UpdateLastSeen(userId); err != nil {
+		return fmt.Errorf("UpdateLastSeen: %w", err)
+	}
+	return txc.Commit()
+}
+
+func (s *ContactsService) GetProfile(userId int) (*model.Profile, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	return txc.GetProfile(userId)
+}
+
+// SetContactsOnly restricts invitations and direct messages to the user's contacts.
+func (s *ContactsService) SetContactsOnly(userId int, contactsOnly bool) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = txc.SetContactsOnly(userId, contactsOnly); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+// checkContactsOnly fails if the target only accepts invitations from contacts
+// and the user is not one of them.
+func (s *ContactsService) checkContactsOnly(txc store.TxContacts, userId, targetId int) error {
+	target, err := txc.GetProfile(targetId)
+	if errors.Is(err, store.ErrNotFound) {
+		return &store.Error{
+			Kind:    store.KindProfile,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("user '%d' has no profile", targetId),
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !target.ContactsOnly {
+		return nil
+	}
+	if ok, err := txc.ContactExists(targetId, userId); err != nil || !ok {
+		if err != nil {
+			return err
+		}
+		return &store.Error{
+			Kind:    store.KindContact,
+			Err:     store.ErrPermissionDenied,
+			Message: fmt.Sprintf("user '%d' only accepts contacts", targetId),
+		}
+	}
+	return nil
+}
+
+func (s *ContactsService) ListContacts(userId int) ([]model.Contact, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	contacts, err := txc.GetContacts(userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetContacts: %w", err)
+	}
+	for i := range contacts {
+		contacts[i].Presence = presence(contacts[i].LastSeen)
+	}
+	return contacts, nil
+}
+
+func presence(lastSeen *time.Time) model.Presence {
+	if lastSeen != nil && time.Since(*lastSeen) < onlineWindow {
+		return model.PresenceOnline
+	}
+	return model.PresenceOffline
+}
+
+func (s *ContactsService) RemoveContact(userId, contactId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = txc.DeleteContact(userId, contactId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+func (s *ContactsService) ListContactRequests(userId int) ([]model.ContactRequest, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	return txc.GetContactRequests(userId)
+}
+
+// SendContactRequest asks the target to become a contact of the user.
+// A pending request in the opposite direction is accepted instead.
+func (s *ContactsService) SendContactRequest(userId, targetId int) (*model.ContactRequest, error) {
+	if userId == targetId {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrBadRequest,
+			Message: "cannot send a contact request to yourself",
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkBlocked(txc, userId, targetId); err != nil {
+		return nil, err
+	}
+	if exists, err := txc.ContactExists(userId, targetId); err != nil || exists {
+		if err != nil {
+			return nil, err
+		}
+		return nil, &store.Error{
+			Kind:    store.KindContact,
+			Err:     store.ErrExists,
+			Message: fmt.Sprintf("user '%d' is already a contact", targetId),
+		}
+	}
+	if _, err = txc.GetPendingContactRequest(userId, targetId); err == nil {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrExists,
+			Message: fmt.Sprintf("contact request to '%d' is already pending", targetId),
+		}
+	}
+
+	if req, err := txc.GetPendingContactRequest(targetId, userId); err == nil {
+		if err = s.acceptContactRequest(txc, req); err != nil {
+			return nil, err
+		}
+		if err = txc.Commit(); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+
+	req, err := txc.CreateContactRequest(userId, targetId)
+	if err != nil {
+		return nil, fmt.Errorf("CreateContactRequest: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// AcceptContactRequest accepts a pending request received by the user.
+func (s *ContactsService) AcceptContactRequest(userId, requestId int) (*model.ContactRequest, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	req, err := s.getReceivedRequest(txc, userId, requestId)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.acceptContactRequest(txc, req); err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (s *ContactsService) acceptContactRequest(txc store.TxContacts, req *model.ContactRequest) error {
+	if err := txc.CreateContact(req.FromId, req.ToId); err != nil {
+		return fmt.Errorf("CreateContact: %w", err)
+	}
+	if err := txc.UpdateContactRequest(req.Id, model.ContactRequestAccepted); err != nil {
+		return fmt.Errorf("UpdateContactRequest: %w", err)
+	}
+	req.Status = model.ContactRequestAccepted
+	return nil
+}
+
+// RejectContactRequest rejects a request received by the user, or withdraws
+// one sent by the user.
+func (s *ContactsService) RejectContactRequest(userId, requestId int) (*model.ContactRequest, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	req, err := txc.GetContactRequest(requestId)
+	if err != nil {
+		return nil, err
+	}
+	if req.FromId != userId && req.ToId != userId {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("contact request '%d' not found", requestId),
+		}
+	}
+	if req.Status != model.ContactRequestPending {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("contact request '%d' is already %s", requestId, req.Status),
+		}
+	}
+	if err = txc.UpdateContactRequest(req.Id, model.ContactRequestRejected); err != nil {
+		return nil, err
+	}
+	req.Status = model.ContactRequestRejected
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (s *ContactsService) getReceivedRequest(txc store.TxContacts, userId, requestId int) (*model.ContactRequest, error) {
+	req, err := txc.GetContactRequest(requestId)
+	if err != nil {
+		return nil, err
+	}
+	if req.ToId != userId {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("contact request '%d' not found", requestId),
+		}
+	}
+	if req.Status != model.ContactRequestPending {
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("contact request '%d' is already %s", requestId, req.Status),
+		}
+	}
+	return req, nil
+}
+
+// OpenDirect returns the direct group between the user and the peer,
+// creating it on first use.
+func (s *ContactsService) OpenDirect(userId, peerId int) (*model.Group, error) {
+	if userId == peerId {
+		return nil, &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrBadRequest,
+			Message: "cannot open a direct conversation with yourself",
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkBlocked(txc, userId, peerId); err != nil {
+		return nil, err
+	}
+	if group, err := txc.GetDirectGroup(userId, peerId); err == nil {
+		return group, nil
+	}
+	if err = s.checkContactsOnly(txc, userId, peerId); err != nil {
+		return nil, err
+	}
+	group, err := txc.CreateDirectGroup(userId, peerId)
+	if err != nil {
+		return nil, fmt.Errorf("CreateDirectGroup: %w", err)
+	}
+	for _, id := range []int{userId, peerId} {
+		if _, err = s.join(txc, group.Id, id, access.RoleMember); err != nil {
+			return nil, fmt.Errorf("join: %w", err)
+		}
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// CheckAccess fails unless the user is a member of the group whose role
+// allows the action.
+func (s *ContactsService) CheckAccess(groupId, userId int, action access.Action) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("cannot find group %d for user %d", groupId, userId),
+		}
+	}
+	if !s.access.Can(member.Role, member.Role, action) {
+		return &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrPermissionDenied,
+			Message: fmt.Sprintf("%s role cannot %s", member.Role, action),
+		}
+	}
+	return nil
+}
+
+const (
+	minRetention = time.Minute
+	maxRetention = 365 * 24 * time.Hour
+)
+
+// SetRetention changes how long messages of the group are kept, keeping them
+// forever if retention is 0. Any member of a direct group may change it,
+// other groups require a role allowed to change it. It reports whether the
+// retention changed.
+func (s *ContactsService) SetRetention(groupId, userId int, retention time.Duration) (*model.Group, bool, error) {
+	if retention != 0 && (retention < minRetention || retention > maxRetention) {
+		return nil, false, &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("retention must be 0 or between %s and %s", minRetention, maxRetention),
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer txc.Rollback()
+
+	group, err := s.getMemberGroup(txc, groupId, userId)
+	if err != nil {
+		return nil, false, err
+	}
+	if !group.Direct {
+		member, err := txc.GetMember(groupId, userId)
+		if err != nil {
+			return nil, false, fmt.Errorf("GetMember: %w", err)
+		}
+		if !s.access.Can(member.Role, member.Role, access.ActionRetention) {
+			return nil, false, &store.Error{
+				Kind:    store.KindMember,
+				Err:     store.ErrPermissionDenied,
+				Message: fmt.Sprintf("%s role cannot %s", member.Role, access.ActionRetention),
+			}
+		}
+	}
+	seconds := int(retention / time.Second)
+	if group.Retention == seconds {
+		return group, false, nil
+	}
+	if err = txc.SetRetention(groupId, seconds); err != nil {
+		return nil, false, fmt.Errorf("SetRetention: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, false, err
+	}
+	group.Retention = seconds
+	return group, true, nil
+}
+
+// retention returns how long new messages of the group are kept, or 0 if
+// they are kept forever.
+func (s *ContactsService) retention(groupId int) (time.Duration, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer txc.Rollback()
+
+	group, err := txc.GetGroup(groupId)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(group.Retention) * time.Second, nil
+}
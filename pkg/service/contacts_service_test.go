@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/event"
 	"github.com/elug3/gochat/pkg/model"
 	"github.com/elug3/gochat/pkg/store"
 	"github.com/elug3/gochat/pkg/store/contacts/sqlite"
@@ -22,7 +24,7 @@ func NewTestContactsService() (*ContactsService, error) {
 	// if err != nil {
 	// 	return nil, err
 	// }
-	s, err := NewContactsService(store)
+	s, err := NewContactsService(store, event.NewEventHandler())
 	if err != nil {
 		return nil, err
 	}
@@ -208,6 +210,162 @@ func TestContacts_Invite(t *testing.T) {
 	}
 }
 
+func TestContacts_UpdateGroup(t *testing.T) {
+	s, result, err := setup(t, webhookPreset)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	str := func(s string) *string { return &s }
+	perm := func(p model.Permission) *model.Permission { return &p }
+	rows := []struct {
+		name    string
+		userId  int
+		update  GroupUpdate
+		wantErr error
+	}{
+		{name: "member cannot rename", userId: p2.Id, update: GroupUpdate{Name: str("renamed")}, wantErr: store.ErrPermissionDenied},
+		{name: "manager renames", userId: p3.Id, update: GroupUpdate{Name: str(" renamed "), Description: str("about")}},
+		{name: "name too short", userId: p3.Id, update: GroupUpdate{Name: str("x")}, wantErr: store.ErrBadRequest},
+		{name: "invalid avatar", userId: p3.Id, update: GroupUpdate{Avatar: str("javascript:alert(1)")}, wantErr: store.ErrBadRequest},
+		{name: "avatar", userId: p3.Id, update: GroupUpdate{Avatar: str("https://example.com/a.png")}},
+		{name: "manager cannot change settings", userId: p3.Id, update: GroupUpdate{Settings: &SettingsUpdate{Invite: perm(model.PermissionManagers)}}, wantErr: store.ErrPermissionDenied},
+		{name: "invalid permission", userId: p1.Id, update: GroupUpdate{Settings: &SettingsUpdate{Invite: perm("nobody")}}, wantErr: store.ErrBadRequest},
+		{name: "owner changes settings", userId: p1.Id, update: GroupUpdate{Settings: &SettingsUpdate{Invite: perm(model.PermissionManagers)}}},
+	}
+	for _, row := range rows {
+		if _, err := s.UpdateGroup(g1.Id, row.userId, row.update); !errors.Is(err, row.wantErr) {
+			t.Errorf("%s: expected error %q, but got %q", row.name, row.wantErr, err)
+		}
+	}
+
+	group, err := s.GetGroup(g1.Id, p2.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.GroupSettings{Post: model.PermissionEveryone, Invite: model.PermissionManagers}
+	if group.Name != "renamed" || group.Description != "about" || group.Avatar != "https://example.com/a.png" || group.Settings != want {
+		t.Errorf("unexpected group %+v", group)
+	}
+	if !group.UpdatedAt.After(group.CreatedAt) {
+		t.Errorf("expected updated_at after %v, but got %v", group.CreatedAt, group.UpdatedAt)
+	}
+	if _, err = s.CreateProfile(4, "p4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Invite(g1.Id, p3.Id, 4); err != nil {
+		t.Errorf("expected manager to invite, but got %q", err)
+	}
+
+	direct, err := s.OpenDirect(p1.Id, p2.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.UpdateGroup(direct.Id, p1.Id, GroupUpdate{Name: str("direct")}); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+}
+
+func TestContacts_Join(t *testing.T) {
+	s, result, err := setup(t, webhookPreset)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	p1, _ := result.GetProfile("p1")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+	for _, id := range []int{4, 5} {
+		if _, err = s.CreateProfile(id, fmt.Sprintf("p%d", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err = s.Join(g1.Id, 4); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	if groups, err := s.Discover("test", 0); err != nil || len(groups) != 0 {
+		t.Errorf("expected no public groups, but got %v (%v)", groups, err)
+	}
+
+	public := true
+	if _, err = s.UpdateGroup(g1.Id, p1.Id, GroupUpdate{Settings: &SettingsUpdate{Public: &public}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "", want: 1},
+		{query: "TEST", want: 1},
+		{query: "other", want: 0},
+		{query: "%", want: 0},
+	}
+	for _, tt := range tests {
+		if groups, err := s.Discover(tt.query, 0); err != nil || len(groups) != tt.want {
+			t.Errorf("%q: expected %d groups, but got %v (%v)", tt.query, tt.want, groups, err)
+		}
+	}
+
+	member, req, err := s.Join(g1.Id, 4)
+	if err != nil || req != nil || member == nil || member.Role != "member" {
+		t.Fatalf("expected to join as member, but got %v, %v (%v)", member, req, err)
+	}
+	if _, _, err = s.Join(g1.Id, 4); !errors.Is(err, store.ErrExists) {
+		t.Errorf("expected error %q, but got %q", store.ErrExists, err)
+	}
+
+	if _, err = s.UpdateGroup(g1.Id, p1.Id, GroupUpdate{Settings: &SettingsUpdate{JoinApproval: &public}}); err != nil {
+		t.Fatal(err)
+	}
+	member, req, err = s.Join(g1.Id, 5)
+	if err != nil || member != nil || req == nil || req.Status != model.JoinRequestPending {
+		t.Fatalf("expected a pending join request, but got %v, %v (%v)", member, req, err)
+	}
+	if _, _, err = s.Join(g1.Id, 5); !errors.Is(err, store.ErrExists) {
+		t.Errorf("expected error %q, but got %q", store.ErrExists, err)
+	}
+	if _, err = s.JoinRequests(g1.Id, 4); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	reqs, err := s.JoinRequests(g1.Id, p3.Id)
+	if err != nil || len(reqs) != 1 || reqs[0].Id != req.Id {
+		t.Fatalf("expected request %d, but got %v (%v)", req.Id, reqs, err)
+	}
+	decided, err := s.DecideJoinRequest(g1.Id, p3.Id, req.Id, true)
+	if err != nil || decided.Status != model.JoinRequestApproved || decided.DecidedBy != p3.Id {
+		t.Fatalf("unexpected decision %v (%v)", decided, err)
+	}
+	if _, err = s.DecideJoinRequest(g1.Id, p3.Id, req.Id, false); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+	if _, err = s.GetGroup(g1.Id, 5); err != nil {
+		t.Errorf("expected approved user to be a member, but got %q", err)
+	}
+
+	// requests are deleted with the group
+	if _, err = s.CreateProfile(6, "p6"); err != nil {
+		t.Fatal(err)
+	}
+	if _, req, err = s.Join(g1.Id, 6); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteGroup(g1.Id, p1.Id); err != nil {
+		t.Fatal(err)
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txc.Rollback()
+	if _, err = txc.GetJoinRequest(req.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+}
+
 func TestContacts_DeleteGroup(t *testing.T) {
 	type DeleteGroup struct {
 		group   string
@@ -273,6 +431,141 @@ func TestContacts_DeleteGroup(t *testing.T) {
 	}
 }
 
+func TestContacts_ContactRequest(t *testing.T) {
+	type Request struct {
+		from    string
+		to      string
+		accept  bool
+		wantErr error
+	}
+	preset := &Preset{
+		profiles: map[string]presetProfile{
+			"p1": {userId: 1, name: "p1"},
+			"p2": {userId: 2, name: "p2"},
+		},
+	}
+	testCases := map[string]struct {
+		preset      *Preset
+		rows        []Request
+		wantContact bool
+	}{
+		"send and accept": {
+			preset:      preset,
+			rows:        []Request{{from: "p1", to: "p2", accept: true}},
+			wantContact: true,
+		},
+		"pending request": {
+			preset: preset,
+			rows:   []Request{{from: "p1", to: "p2"}},
+		},
+		"duplicate request": {
+			preset: preset,
+			rows: []Request{
+				{from: "p1", to: "p2"},
+				{from: "p1", to: "p2", wantErr: store.ErrExists},
+			},
+		},
+		"crossed requests become contacts": {
+			preset: preset,
+			rows: []Request{
+				{from: "p1", to: "p2"},
+				{from: "p2", to: "p1"},
+			},
+			wantContact: true,
+		},
+		"request to yourself": {
+			preset: preset,
+			rows:   []Request{{from: "p1", to: "p1", wantErr: store.ErrBadRequest}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s, result, err := setup(t, tc.preset)
+			if err != nil {
+				t.Fatalf("setup failed: %v", err)
+			}
+			for i, row := range tc.rows {
+				from, err := result.GetProfile(row.from)
+				if err != nil {
+					t.Fatal(err)
+				}
+				to, err := result.GetProfile(row.to)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req, err := s.SendContactRequest(from.Id, to.Id)
+				if !errors.Is(err, row.wantErr) {
+					t.Fatalf("row_%d: expected error %q, but got %q", i, row.wantErr, err)
+				}
+				if err == nil && row.accept {
+					if _, err = s.AcceptContactRequest(to.Id, req.Id); err != nil {
+						t.Fatalf("row_%d: AcceptContactRequest: %q", i, err)
+					}
+				}
+			}
+
+			p1, _ := result.GetProfile("p1")
+			contacts, err := s.ListContacts(p1.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(contacts) == 1; got != tc.wantContact {
+				t.Errorf("expected contact: %v, but got contacts: %v", tc.wantContact, contacts)
+			}
+		})
+	}
+}
+
+func TestContacts_ContactsOnly(t *testing.T) {
+	preset := &Preset{
+		profiles: map[string]presetProfile{
+			"p1": {userId: 1, name: "p1"},
+			"p2": {userId: 2, name: "p2"},
+		},
+		groups: map[string]presetGroup{
+			"g1": {name: "test group", owner: "p1"},
+		},
+	}
+	s, result, err := setup(t, preset)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	if _, err = s.Invite(g1.Id, p1.Id, 99); !errors.Is(err, store.ErrBadRequest) {
+		t.Fatalf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+	if err = s.SetContactsOnly(p2.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Invite(g1.Id, p1.Id, p2.Id); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Fatalf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if _, err = s.OpenDirect(p1.Id, p2.Id); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Fatalf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+
+	req, err := s.SendContactRequest(p1.Id, p2.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AcceptContactRequest(p2.Id, req.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Invite(g1.Id, p1.Id, p2.Id); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	g, err := s.OpenDirect(p1.Id, p2.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if again, err := s.OpenDirect(p2.Id, p1.Id); err != nil || again.Id != g.Id {
+		t.Errorf("expected the same direct group %d, but got %v (%v)", g.Id, again, err)
+	}
+}
+
 type PresetResult struct {
 	profiles map[string]*model.Profile
 	groups   map[string]*model.Group
@@ -354,6 +647,25 @@ func setup(t *testing.T, preset *Preset) (*ContactsService, *PresetResult, error
 					return nil, nil, fmt.Errorf("presetGroup.member.Invite: %q", err)
 				}
 			}
+
+			for _, key := range preg.manager {
+				mgrProfile, err := result.GetProfile(key)
+				if err != nil {
+					return nil, nil, fmt.Errorf("presetGroupManager.result.GetProfile: %q", err)
+				}
+				txc, err := s.store.Begin()
+				if err != nil {
+					return nil, nil, err
+				}
+				_, err = s.join(txc, g.Id, mgrProfile.Id, access.RoleManager)
+				if err == nil {
+					err = txc.Commit()
+				}
+				if err != nil {
+					txc.Rollback()
+					return nil, nil, fmt.Errorf("presetGroup.manager.join: %q", err)
+				}
+			}
 		}
 
 	}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/jwt"
+)
+
+// newKeySet builds the signing keys from the configuration, or returns nil if
+// signed tokens are disabled.
+func newKeySet(cfg config.JWTConfig) (*jwt.KeySet, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.SigningKey == "" {
+		return nil, fmt.Errorf("jwt: signingKey is required")
+	}
+	keys := make([]jwt.Key, 0, len(cfg.Keys))
+	for _, kc := range cfg.Keys {
+		key, err := parseKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", kc.Id, err)
+		}
+		keys = append(keys, *key)
+	}
+	return jwt.NewKeySet(cfg.SigningKey, keys...)
+}
+
+func parseKey(kc config.JWTKeyConfig) (*jwt.Key, error) {
+	if kc.Id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	key := jwt.Key{Id: kc.Id, Alg: kc.Alg}
+	switch kc.Alg {
+	case jwt.AlgHS256:
+		if len(kc.Secret) < 32 {
+			return nil, fmt.Errorf("secret must be at least 32 bytes")
+		}
+		key.Secret = []byte(kc.Secret)
+	case jwt.AlgEdDSA:
+		if kc.PrivateKey != "" {
+			b, err := base64.StdEncoding.DecodeString(kc.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("privateKey: %w", err)
+			}
+			switch len(b) {
+			case ed25519.SeedSize:
+				key.PrivateKey = ed25519.NewKeyFromSeed(b)
+			case ed25519.PrivateKeySize:
+				key.PrivateKey = ed25519.PrivateKey(b)
+			default:
+				return nil, fmt.Errorf("privateKey: invalid length %d", len(b))
+			}
+		} else {
+			b, err := base64.StdEncoding.DecodeString(kc.PublicKey)
+			if err != nil || len(b) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("privateKey or publicKey is required")
+			}
+			key.PublicKey = ed25519.PublicKey(b)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Alg)
+	}
+	return &key, nil
+}
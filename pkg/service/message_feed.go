@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// feedBuffer is the number of events queued for a feed subscriber. A
+// subscriber that falls further behind is disconnected.
+const feedBuffer = 64
+
+// feed fans out feed events to the subscribed users.
+type feed struct {
+	mu          sync.RWMutex
+	subscribers map[int]map[*feedSubscriber]struct{}
+}
+
+type feedSubscriber struct {
+	events chan model.ChatEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+// send queues the event without blocking. A full queue disconnects the
+// subscriber.
+func (sub *feedSubscriber) send(e model.ChatEvent) {
+	select {
+	case <-sub.done:
+	case sub.events <- e:
+	default:
+		sub.close()
+	}
+}
+
+func (sub *feedSubscriber) close() {
+	sub.once.Do(func() { close(sub.done) })
+}
+
+func (f *feed) add(userId int, sub *feedSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subscribers == nil {
+		f.subscribers = make(map[int]map[*feedSubscriber]struct{})
+	}
+	if f.subscribers[userId] == nil {
+		f.subscribers[userId] = make(map[*feedSubscriber]struct{})
+	}
+	f.subscribers[userId][sub] = struct{}{}
+}
+
+func (f *feed) remove(userId int, sub *feedSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers[userId], sub)
+	if len(f.subscribers[userId]) == 0 {
+		delete(f.subscribers, userId)
+	}
+}
+
+// userIds returns the subscribed users, or only the recipient if it is set.
+func (f *feed) userIds(recipientId int) []int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if recipientId != 0 {
+		if _, ok := f.subscribers[recipientId]; ok {
+			return []int{recipientId}
+		}
+		return nil
+	}
+	userIds := make([]int, 0, len(f.subscribers))
+	for userId := range f.subscribers {
+		userIds = append(userIds, userId)
+	}
+	return userIds
+}
+
+func (f *feed) send(userId int, e model.ChatEvent) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for sub := range f.subscribers[userId] {
+		sub.send(e)
+	}
+}
+
+// startFeed delivers feed events to the subscribers until ctx is done.
+func (s *MessageService) startFeed(ctx context.Context) error {
+	for _, pattern := range model.FeedEvents {
+		err := s.events.Register(ctx, pattern, func(e *event.Event) error {
+			if ce, ok := e.Data.(model.ChatEvent); ok {
+				s.dispatch(ce)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatch resolves the audience of the event once and queues it for every
+// subscriber in it.
+func (s *MessageService) dispatch(ce model.ChatEvent) {
+	userIds := s.feed.userIds(ce.RecipientId)
+	if len(userIds) == 0 {
+		return
+	}
+	audience, err := s.Contacts.Audience(ce.GroupId, ce.ActorId, userIds)
+	if err != nil {
+		log.Error().Err(err).Str("event", ce.Type).Int("groupId", ce.GroupId).Msg("resolve audience")
+		return
+	}
+	for userId, notify := range audience {
+		e := ce
+		e.Notify = notify
+		s.feed.send(userId, e)
+	}
+}
+
+// Subscribe calls fn for every feed event visible to the user until ctx is
+// done. The returned channel is closed when the subscription ends, either
+// because ctx is done, fn failed or the subscriber fell behind.
+func (s *MessageService) Subscribe(ctx context.Context, userId int, fn func(e model.ChatEvent) error) <-chan struct{} {
+	sub := &feedSubscriber{
+		events: make(chan model.ChatEvent, feedBuffer),
+		done:   make(chan struct{}),
+	}
+	s.feed.add(userId, sub)
+
+	go func() {
+		defer s.feed.remove(userId, sub)
+		defer sub.close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.done:
+				return
+			case e := <-sub.events:
+				if err := fn(e); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return sub.done
+}
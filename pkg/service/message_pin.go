@@ -0,0 +1,75 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// maxPins is the number of messages that can be pinned in a group.
+const maxPins = 50
+
+// Pin pins a message of the group. Pinning a pinned message is a no-op.
+func (s *MessageService) Pin(userId, groupId int, messageId string) (*model.Message, error) {
+	if err := s.Contacts.CheckAccess(groupId, userId, access.ActionPin); err != nil {
+		return nil, err
+	}
+	msg, err := s.getGroupMessage(groupId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Pinned {
+		return msg, nil
+	}
+	pinned, err := s.store.GetPinnedMessages(msg.ConvId)
+	if err != nil {
+		return nil, fmt.Errorf("GetPinnedMessages: %w", err)
+	}
+	if len(pinned) >= maxPins {
+		return nil, &store.Error{
+			Kind:    store.KindMessage,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("at most %d messages can be pinned", maxPins),
+		}
+	}
+	if msg, err = s.store.SetPinned(messageId, userId); err != nil {
+		return nil, fmt.Errorf("SetPinned: %w", err)
+	}
+	s.publish(model.EventMessagePinned, groupId, userId, msg)
+	return msg, nil
+}
+
+// Unpin unpins a message of the group. Unpinning a message that is not pinned
+// is a no-op.
+func (s *MessageService) Unpin(userId, groupId int, messageId string) (*model.Message, error) {
+	if err := s.Contacts.CheckAccess(groupId, userId, access.ActionPin); err != nil {
+		return nil, err
+	}
+	msg, err := s.getGroupMessage(groupId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Pinned {
+		return msg, nil
+	}
+	if msg, err = s.store.SetPinned(messageId, 0); err != nil {
+		return nil, fmt.Errorf("SetPinned: %w", err)
+	}
+	s.publish(model.EventMessageUnpinned, groupId, userId, msg)
+	return msg, nil
+}
+
+// ListPinned returns the pinned messages of the group, latest pinned first.
+func (s *MessageService) ListPinned(userId, groupId int) ([]model.Message, error) {
+	if _, err := s.Contacts.GetGroup(groupId, userId); err != nil {
+		return nil, err
+	}
+	msgs, err := s.store.GetPinnedMessages(strconv.Itoa(groupId))
+	if err != nil {
+		return nil, fmt.Errorf("GetPinnedMessages: %w", err)
+	}
+	return s.visible(userId, msgs)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+)
+
+// SetRetention changes how long new messages of the group are kept and
+// announces the change with a system message. Messages sent before the
+// change keep their expiry.
+func (s *MessageService) SetRetention(userId, groupId int, retention time.Duration) (*model.Group, error) {
+	group, changed, err := s.Contacts.SetRetention(groupId, userId, retention)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return group, nil
+	}
+	actor, err := s.Contacts.GetProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	content := fmt.Sprintf("%s turned off disappearing messages", actor.Name)
+	if retention > 0 {
+		content = fmt.Sprintf("%s set messages to disappear after %s", actor.Name, formatRetention(retention))
+	}
+	payload := model.SystemPayload{
+		Action:    model.SystemRetention,
+		ActorId:   userId,
+		Retention: group.Retention,
+	}
+	if _, err = s.postSystem(groupId, content, payload); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// formatRetention formats a retention in the largest whole unit.
+func formatRetention(d time.Duration) string {
+	units := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, u := range units {
+		if d%u.d == 0 {
+			n := int(d / u.d)
+			if n == 1 {
+				return "1 " + u.name
+			}
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+	}
+	return d.String()
+}
+
+// Expire replaces up to limit messages that expired before now with
+// tombstones, deletes their polls and returns how many were expired.
+func (s *MessageService) Expire(now time.Time, limit int) (int, error) {
+	msgs, err := s.store.ExpireMessages(now, limit)
+	if err != nil {
+		return 0, fmt.Errorf("ExpireMessages: %w", err)
+	}
+	return len(msgs), s.removed(msgs)
+}
+
+// DeleteBefore deletes up to limit messages created before the time together
+// with their polls and returns how many were deleted.
+func (s *MessageService) DeleteBefore(before time.Time, limit int) (int, error) {
+	msgs, err := s.store.DeleteMessagesBefore(before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteMessagesBefore: %w", err)
+	}
+	return len(msgs), s.removed(msgs)
+}
+
+// removed deletes the polls of messages removed by retention and announces
+// their deletion.
+func (s *MessageService) removed(msgs []model.Message) error {
+	if err := s.deletePolls(msgs); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		groupId, err := strconv.Atoi(msg.ConvId)
+		if err != nil {
+			continue
+		}
+		s.publish(model.EventMessageDeleted, groupId, 0, msg)
+	}
+	return nil
+}
+
+// deletePolls deletes the polls of deleted poll messages.
+func (s *MessageService) deletePolls(msgs []model.Message) error {
+	txc, err := s.Contacts.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	for _, msg := range msgs {
+		if msg.PollId == 0 {
+			continue
+		}
+		if err = txc.DeletePoll(msg.PollId); err != nil {
+			return fmt.Errorf("DeletePoll: %w", err)
+		}
+	}
+	return txc.Commit()
+}
@@ -1,14 +1,249 @@
 package service
 
-// type MessageService struct {
-// 	Contacts *ContactsService
-// 	store    store.MessageStore
-// }
-
-// type SendParams struct {
-// 	ChatId  int
-// 	Content string
-// }
-
-// func (s *MessageService) Send() (*model.Message, error) {
-// }
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/google/uuid"
+)
+
+type MessageService struct {
+	Contacts *ContactsService
+	store    store.MessageStore
+	events   *event.EventHandler
+	feed     feed
+}
+
+type SendParams struct {
+	ChatId  int
+	Content string
+	// Type and PollId are set for poll messages.
+	Type   model.MessageType
+	PollId int
+}
+
+func NewMessageService(messageStore store.MessageStore, contacts *ContactsService, events *event.EventHandler) (*MessageService, error) {
+	s := MessageService{
+		Contacts: contacts,
+		store:    messageStore,
+		events:   events,
+	}
+	return &s, nil
+}
+
+func (s *MessageService) Send(userId int, params SendParams) (*model.Message, error) {
+	if strings.TrimSpace(params.Content) == "" {
+		return nil, &store.Error{
+			Kind:    store.KindMessage,
+			Err:     store.ErrBadRequest,
+			Message: "message content must not be empty",
+		}
+	}
+	if err := s.Contacts.CanPost(params.ChatId, userId); err != nil {
+		return nil, err
+	}
+	// senders without a profile are not bots
+	var bot bool
+	sender, err := s.Contacts.GetProfile(userId)
+	switch {
+	case err == nil:
+		bot = sender.Bot
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	msg := model.Message{
+		Id:        id.String(),
+		SenderId:  strconv.Itoa(userId),
+		ConvId:    strconv.Itoa(params.ChatId),
+		Type:      params.Type,
+		Content:   params.Content,
+		Bot:       bot,
+		PollId:    params.PollId,
+		CreatedAt: time.Now(),
+	}
+	if err = s.create(params.ChatId, &msg); err != nil {
+		return nil, err
+	}
+	s.publish(model.EventMessageCreated, params.ChatId, userId, msg)
+	return &msg, nil
+}
+
+// PostParams describe a message posted by an integration on behalf of a
+// group rather than by one of its members.
+type PostParams struct {
+	ChatId      int
+	SenderId    string
+	Username    string
+	Content     string
+	Attachments []model.Attachment
+}
+
+// Post stores a bot message of an integration. The caller is responsible for
+// authorizing the integration to post to the group.
+func (s *MessageService) Post(params PostParams) (*model.Message, error) {
+	if strings.TrimSpace(params.Content) == "" && len(params.Attachments) == 0 {
+		return nil, &store.Error{
+			Kind:    store.KindMessage,
+			Err:     store.ErrBadRequest,
+			Message: "message must have content or attachments",
+		}
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	msg := model.Message{
+		Id:          id.String(),
+		SenderId:    params.SenderId,
+		ConvId:      strconv.Itoa(params.ChatId),
+		Content:     params.Content,
+		Bot:         true,
+		Username:    params.Username,
+		Attachments: params.Attachments,
+		CreatedAt:   time.Now(),
+	}
+	if err = s.create(params.ChatId, &msg); err != nil {
+		return nil, err
+	}
+	s.publish(model.EventMessageCreated, params.ChatId, 0, msg)
+	return &msg, nil
+}
+
+// create stores the message, stamping when it expires if the group has a
+// retention.
+func (s *MessageService) create(groupId int, msg *model.Message) error {
+	retention, err := s.Contacts.retention(groupId)
+	if err != nil {
+		return err
+	}
+	if retention > 0 {
+		expiresAt := msg.CreatedAt.Add(retention)
+		msg.ExpiresAt = &expiresAt
+	}
+	if err = s.store.CreateMessage(*msg); err != nil {
+		return fmt.Errorf("CreateMessage: %w", err)
+	}
+	return nil
+}
+
+// Edit replaces the content of a message sent by the user.
+func (s *MessageService) Edit(userId, groupId int, messageId, content string) (*model.Message, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, &store.Error{
+			Kind:    store.KindMessage,
+			Err:     store.ErrBadRequest,
+			Message: "message content must not be empty",
+		}
+	}
+	if _, err := s.getOwnMessage(userId, groupId, messageId); err != nil {
+		return nil, err
+	}
+	msg, err := s.store.UpdateMessage(messageId, content)
+	if err != nil {
+		return nil, fmt.Errorf("UpdateMessage: %w", err)
+	}
+	s.publish(model.EventMessageEdited, groupId, userId, msg)
+	return msg, nil
+}
+
+// Delete deletes a message sent by the user.
+func (s *MessageService) Delete(userId, groupId int, messageId string) error {
+	msg, err := s.getOwnMessage(userId, groupId, messageId)
+	if err != nil {
+		return err
+	}
+	if err = s.store.DeleteMessage(messageId); err != nil {
+		return fmt.Errorf("DeleteMessage: %w", err)
+	}
+	if err = s.deletePolls([]model.Message{*msg}); err != nil {
+		return err
+	}
+	s.publish(model.EventMessageDeleted, groupId, userId, msg)
+	return nil
+}
+
+// getOwnMessage returns the message if it was sent by the user to the group
+// and the user is still a member of it.
+func (s *MessageService) getOwnMessage(userId, groupId int, messageId string) (*model.Message, error) {
+	if _, err := s.Contacts.GetGroup(groupId, userId); err != nil {
+		return nil, err
+	}
+	msg, err := s.getGroupMessage(groupId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.SenderId != strconv.Itoa(userId) {
+		return nil, &store.Error{
+			Kind:    store.KindMessage,
+			Err:     store.ErrPermissionDenied,
+			Message: "only the sender can change a message",
+		}
+	}
+	return msg, nil
+}
+
+// getGroupMessage returns the message if it was sent to the group and has
+// not expired.
+func (s *MessageService) getGroupMessage(groupId int, messageId string) (*model.Message, error) {
+	msg, err := s.store.GetMessage(messageId)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ConvId != strconv.Itoa(groupId) || msg.DeletedAt != nil {
+		return nil, &store.Error{
+			Kind:    store.KindMessage,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("message '%s' not found", messageId),
+		}
+	}
+	return msg, nil
+}
+
+// List returns the latest messages of the group, hiding messages of users
+// blocked by the user.
+func (s *MessageService) List(userId, groupId int) ([]model.Message, error) {
+	if _, err := s.Contacts.GetGroup(groupId, userId); err != nil {
+		return nil, err
+	}
+	msgs, err := s.store.GetMessages(strconv.Itoa(groupId))
+	if err != nil {
+		return nil, fmt.Errorf("GetMessages: %w", err)
+	}
+	return s.visible(userId, msgs)
+}
+
+// visible filters out the messages of users blocked by the user.
+func (s *MessageService) visible(userId int, msgs []model.Message) ([]model.Message, error) {
+	blocked, err := s.Contacts.BlockedIds(userId)
+	if err != nil {
+		return nil, fmt.Errorf("BlockedIds: %w", err)
+	}
+	visible := make([]model.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if senderId, err := strconv.Atoi(msg.SenderId); err == nil && blocked[senderId] {
+			continue
+		}
+		visible = append(visible, msg)
+	}
+	return visible, nil
+}
+
+func (s *MessageService) publish(typ string, groupId, actorId int, data any) {
+	s.events.Publish(typ, model.ChatEvent{
+		Type:    typ,
+		GroupId: groupId,
+		ActorId: actorId,
+		Data:    data,
+	})
+}
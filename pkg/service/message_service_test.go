@@ -0,0 +1,481 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/store/message/sqlite"
+)
+
+func newTestMessageService(t *testing.T, preset *Preset) (*MessageService, *PresetResult) {
+	t.Helper()
+	contacts, result, err := setup(t, preset)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	messageStore, err := sqlite.NewMessageStore(&config.Config{
+		NoSave: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewMessageService(messageStore, contacts, contacts.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, result
+}
+
+var blockPreset = &Preset{
+	profiles: map[string]presetProfile{
+		"p1": {userId: 1, name: "p1"},
+		"p2": {userId: 2, name: "p2"},
+		"p3": {userId: 3, name: "p3"},
+	},
+	groups: map[string]presetGroup{
+		"g1": {name: "test group", owner: "p1", member: []string{"p2", "p3"}},
+	},
+}
+
+func TestMessage_BlockHidesMessages(t *testing.T) {
+	s, result := newTestMessageService(t, blockPreset)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	for _, userId := range []int{p1.Id, p2.Id} {
+		if _, err := s.Send(userId, SendParams{ChatId: g1.Id, Content: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Contacts.Block(p1.Id, p2.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := s.List(p1.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].SenderId != "1" {
+		t.Errorf("expected only own message, but got: %v", msgs)
+	}
+	msgs, err = s.List(p2.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Errorf("expected 2 messages, but got: %v", msgs)
+	}
+}
+
+func TestMessage_BlockPreventsDirect(t *testing.T) {
+	s, result := newTestMessageService(t, blockPreset)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+
+	direct, err := s.Contacts.OpenDirect(p1.Id, p2.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Contacts.Block(p2.Id, p1.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Send(p1.Id, SendParams{ChatId: direct.Id, Content: "hello"})
+	if !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if _, err = s.Contacts.SendContactRequest(p1.Id, p2.Id); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+
+	if err = s.Contacts.Unblock(p2.Id, p1.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Send(p1.Id, SendParams{ChatId: direct.Id, Content: "hello"}); err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+}
+
+func TestMessage_Subscribe(t *testing.T) {
+	s, result := newTestMessageService(t, blockPreset)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	if _, err := s.Contacts.Block(p1.Id, p3.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Contacts.Mute(p1.Id, g1.Id, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	received := make(chan model.ChatEvent, 4)
+	if err := s.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	s.Subscribe(ctx, p1.Id, func(e model.ChatEvent) error {
+		received <- e
+		return nil
+	})
+
+	for _, userId := range []int{p3.Id, p2.Id} {
+		if _, err := s.Send(userId, SendParams{ChatId: g1.Id, Content: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case e := <-received:
+		if e.ActorId != p2.Id {
+			t.Errorf("expected event from %d, but got: %v", p2.Id, e)
+		}
+		if e.Notify {
+			t.Errorf("expected muted event, but got: %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+}
+
+func TestMessage_SubscribeSlow(t *testing.T) {
+	s, result := newTestMessageService(t, blockPreset)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	if err := s.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	stopped := s.Subscribe(t.Context(), p1.Id, func(e model.ChatEvent) error {
+		<-release
+		return nil
+	})
+
+	for range feedBuffer + 2 {
+		if _, err := s.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the slow subscriber to be disconnected")
+	}
+}
+
+func TestMessage_Pin(t *testing.T) {
+	s, result := newTestMessageService(t, webhookPreset)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	first, err := s.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Pin(p2.Id, g1.Id, first.Id); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if _, err = s.Pin(p1.Id, g1.Id, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	for _, msg := range []*model.Message{first, second, first} {
+		pinned, err := s.Pin(p3.Id, g1.Id, msg.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pinned.Pinned || pinned.PinnedBy != p3.Id {
+			t.Errorf("expected message pinned by %d, but got %+v", p3.Id, pinned)
+		}
+	}
+
+	pinned, err := s.ListPinned(p2.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 2 || pinned[0].Id != second.Id {
+		t.Errorf("unexpected pinned messages %+v", pinned)
+	}
+
+	if _, err = s.Unpin(p1.Id, g1.Id, second.Id); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := s.List(p2.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || !msgs[0].Pinned || msgs[1].Pinned {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+}
+
+func TestMessage_Retention(t *testing.T) {
+	s, result := newTestMessageService(t, webhookPreset)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	if _, err := s.SetRetention(p2.Id, g1.Id, 24*time.Hour); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if _, err := s.SetRetention(p1.Id, g1.Id, 30*time.Second); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+
+	before, err := s.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "kept"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		group, err := s.SetRetention(p3.Id, g1.Id, 24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if group.Retention != 86400 {
+			t.Errorf("expected retention 86400, but got %d", group.Retention)
+		}
+	}
+	after, err := s.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.ExpiresAt != nil || after.ExpiresAt == nil || !after.ExpiresAt.Equal(after.CreatedAt.Add(24*time.Hour)) {
+		t.Errorf("unexpected expiry %v and %v", before.ExpiresAt, after.ExpiresAt)
+	}
+
+	msgs, err := s.List(p1.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[1].Type != model.MessageSystem || msgs[1].Content != "p3 set messages to disappear after 1 day" {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+
+	if n, err := s.Expire(time.Now(), 10); err != nil || n != 0 {
+		t.Fatalf("expected no expired messages, but got %d: %v", n, err)
+	}
+	if n, err := s.Expire(time.Now().Add(25*time.Hour), 10); err != nil || n != 2 {
+		t.Fatalf("expected 2 expired messages, but got %d: %v", n, err)
+	}
+	if msgs, err = s.List(p1.Id, g1.Id); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[0].DeletedAt != nil || msgs[0].Content != "kept" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+	for _, msg := range msgs[1:] {
+		if msg.DeletedAt == nil || msg.Content != "" {
+			t.Errorf("expected tombstone, but got %+v", msg)
+		}
+	}
+	if _, err = s.Edit(p2.Id, g1.Id, after.Id, "edited"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+
+	if _, err = s.SetRetention(p1.Id, g1.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err = s.List(p1.Id, g1.Id); err != nil {
+		t.Fatal(err)
+	}
+	if last := msgs[len(msgs)-1]; last.Content != "p1 turned off disappearing messages" || last.ExpiresAt != nil {
+		t.Errorf("unexpected system message %+v", last)
+	}
+}
+
+func TestMessage_SystemMessages(t *testing.T) {
+	s, result := newTestMessageService(t, webhookPreset)
+	if err := s.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	if err := s.Contacts.DeleteMember(g1.Id, p3.Id, p1.Id); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if _, err := s.Contacts.SetRole(g1.Id, p3.Id, p2.Id, "manager"); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func() error
+		content string
+		payload model.SystemPayload
+	}{
+		{
+			name:    "remove",
+			change:  func() error { return s.Contacts.DeleteMember(g1.Id, p3.Id, p2.Id) },
+			content: "p3 removed p2",
+			payload: model.SystemPayload{Action: model.SystemMemberRemoved, ActorId: p3.Id, TargetId: p2.Id},
+		},
+		{
+			name: "add",
+			change: func() error {
+				_, err := s.Contacts.Invite(g1.Id, p1.Id, p2.Id)
+				return err
+			},
+			content: "p1 added p2",
+			payload: model.SystemPayload{Action: model.SystemMemberAdded, ActorId: p1.Id, TargetId: p2.Id},
+		},
+		{
+			name: "promote",
+			change: func() error {
+				_, err := s.Contacts.SetRole(g1.Id, p1.Id, p2.Id, "manager")
+				return err
+			},
+			content: "p1 made p2 a manager",
+			payload: model.SystemPayload{Action: model.SystemMemberRole, ActorId: p1.Id, TargetId: p2.Id, Role: "manager"},
+		},
+		{
+			name:    "leave",
+			change:  func() error { return s.Contacts.DeleteMember(g1.Id, p2.Id, p2.Id) },
+			content: "p2 left",
+			payload: model.SystemPayload{Action: model.SystemMemberLeft, ActorId: p2.Id, TargetId: p2.Id},
+		},
+		{
+			name: "rename",
+			change: func() error {
+				name := "renamed group"
+				_, err := s.Contacts.UpdateGroup(g1.Id, p3.Id, GroupUpdate{Name: &name})
+				return err
+			},
+			content: "p3 renamed the group to renamed group",
+			payload: model.SystemPayload{Action: model.SystemGroupRenamed, ActorId: p3.Id, Name: "renamed group"},
+		},
+		{
+			name:    "hand over",
+			change:  func() error { return s.Contacts.Leave(g1.Id, p1.Id) },
+			content: "p3 is now the owner",
+			payload: model.SystemPayload{Action: model.SystemMemberRole, ActorId: p1.Id, TargetId: p3.Id, Role: "owner"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.change(); err != nil {
+				t.Fatal(err)
+			}
+			// events of one change are announced concurrently
+			var found model.Message
+			waitFor(t, func() bool {
+				msgs, err := s.List(p3.Id, g1.Id)
+				if err != nil {
+					return false
+				}
+				for _, msg := range msgs {
+					if msg.Content == tt.content {
+						found = msg
+						return true
+					}
+				}
+				return false
+			})
+			if found.Type != model.MessageSystem || found.SenderId != systemSender {
+				t.Errorf("unexpected message %+v", found)
+			}
+			if found.System == nil || *found.System != tt.payload {
+				t.Errorf("expected payload %+v, but got %+v", tt.payload, found.System)
+			}
+		})
+	}
+}
+
+func TestMessage_PostingMode(t *testing.T) {
+	s, result := newTestMessageService(t, webhookPreset)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	send := func(userId int) error {
+		_, err := s.Send(userId, SendParams{ChatId: g1.Id, Content: "hello"})
+		return err
+	}
+	setPost := func(p model.Permission) {
+		t.Helper()
+		if _, err := s.Contacts.UpdateGroup(g1.Id, p1.Id, GroupUpdate{Settings: &SettingsUpdate{Post: &p}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		mode    model.Permission
+		userId  int
+		wantErr error
+	}{
+		{mode: model.PermissionManagers, userId: p2.Id, wantErr: store.ErrPermissionDenied},
+		{mode: model.PermissionManagers, userId: p3.Id},
+		{mode: model.PermissionOwner, userId: p3.Id, wantErr: store.ErrPermissionDenied},
+		{mode: model.PermissionOwner, userId: p1.Id},
+		{mode: model.PermissionEveryone, userId: p2.Id},
+	}
+	for i, tt := range tests {
+		setPost(tt.mode)
+		if err := send(tt.userId); !errors.Is(err, tt.wantErr) {
+			t.Errorf("row_%d: expected error %q, but got %q", i, tt.wantErr, err)
+		}
+	}
+
+	until := time.Now().Add(time.Hour)
+	if _, err := s.Contacts.Restrict(g1.Id, p2.Id, p3.Id, &until); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if _, err := s.Contacts.Restrict(g1.Id, p3.Id, p1.Id, nil); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	restriction, err := s.Contacts.Restrict(g1.Id, p3.Id, p2.Id, &until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restriction.CreatedBy != p3.Id || restriction.Until == nil {
+		t.Errorf("unexpected restriction %+v", restriction)
+	}
+	if err = send(p2.Id); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if err = s.Contacts.Unrestrict(g1.Id, p3.Id, p2.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = send(p2.Id); err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if _, err = s.Contacts.Restrict(g1.Id, p3.Id, p2.Id, &past); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+
+	// an expired restriction no longer applies
+	txc, err := s.Contacts.store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = txc.SetRestriction(p2.Id, g1.Id, p3.Id, &past); err != nil {
+		t.Fatal(err)
+	}
+	if err = txc.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = send(p2.Id); err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// systemSender is the sender id of system messages.
+const systemSender = "system"
+
+// unknownName stands in for users without a profile, such as deleted
+// accounts.
+const unknownName = "someone"
+
+// systemEvents are the events announced with system messages.
+var systemEvents = []string{
+	model.EventMemberAdded,
+	model.EventMemberRemoved,
+	model.EventMemberUpdated,
+	model.EventGroupUpdated,
+}
+
+// Start delivers feed events to the subscribers and posts system messages
+// for membership and group changes until ctx is done.
+func (s *MessageService) Start(ctx context.Context) error {
+	if err := s.startFeed(ctx); err != nil {
+		return err
+	}
+	for _, pattern := range systemEvents {
+		err := s.events.Register(ctx, pattern, func(e *event.Event) error {
+			ce, ok := e.Data.(model.ChatEvent)
+			if !ok {
+				return nil
+			}
+			var err error
+			if ce.Type == model.EventGroupUpdated {
+				err = s.announceGroup(ce)
+			} else {
+				err = s.announceMember(ce)
+			}
+			if err != nil {
+				log.Error().Err(err).Str("event", ce.Type).Int("groupId", ce.GroupId).Msg("post system message")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// announceMember posts the system message for a membership event.
+func (s *MessageService) announceMember(ce model.ChatEvent) error {
+	var member model.Member
+	switch m := ce.Data.(type) {
+	case model.Member:
+		member = m
+	case *model.Member:
+		member = *m
+	default:
+		return nil
+	}
+	payload := model.SystemPayload{
+		ActorId:  ce.ActorId,
+		TargetId: member.UserId,
+	}
+	actor := s.displayName(ce.ActorId)
+	target := s.displayName(member.UserId)
+	self := ce.ActorId == member.UserId
+
+	var content string
+	switch ce.Type {
+	case model.EventMemberAdded:
+		if self {
+			payload.Action = model.SystemMemberJoined
+			content = fmt.Sprintf("%s joined", target)
+		} else {
+			payload.Action = model.SystemMemberAdded
+			content = fmt.Sprintf("%s added %s", actor, target)
+		}
+	case model.EventMemberRemoved:
+		if self {
+			payload.Action = model.SystemMemberLeft
+			content = fmt.Sprintf("%s left", target)
+		} else {
+			payload.Action = model.SystemMemberRemoved
+			content = fmt.Sprintf("%s removed %s", actor, target)
+		}
+	case model.EventMemberUpdated:
+		payload.Action = model.SystemMemberRole
+		payload.Role = member.Role
+		if member.Role == access.RoleOwner {
+			content = fmt.Sprintf("%s is now the owner", target)
+		} else {
+			content = fmt.Sprintf("%s made %s a %s", actor, target, member.Role)
+		}
+	default:
+		return nil
+	}
+	_, err := s.postSystem(ce.GroupId, content, payload)
+	return err
+}
+
+// announceGroup posts the system message for a rename of the group. Other
+// changes of the group are not announced.
+func (s *MessageService) announceGroup(ce model.ChatEvent) error {
+	update, ok := ce.Data.(model.GroupUpdated)
+	if !ok || !slices.Contains(update.Changed, "name") {
+		return nil
+	}
+	payload := model.SystemPayload{
+		Action:  model.SystemGroupRenamed,
+		ActorId: ce.ActorId,
+		Name:    update.Group.Name,
+	}
+	content := fmt.Sprintf("%s renamed the group to %s", s.displayName(ce.ActorId), update.Group.Name)
+	_, err := s.postSystem(ce.GroupId, content, payload)
+	return err
+}
+
+// displayName returns the profile name of the user for system messages.
+func (s *MessageService) displayName(userId int) string {
+	profile, err := s.Contacts.GetProfile(userId)
+	if err != nil {
+		return unknownName
+	}
+	return profile.Name
+}
+
+// postSystem stores a system message announcing a change of the group.
+func (s *MessageService) postSystem(groupId int, content string, payload model.SystemPayload) (*model.Message, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	msg := model.Message{
+		Id:        id.String(),
+		SenderId:  systemSender,
+		ConvId:    strconv.Itoa(groupId),
+		Type:      model.MessageSystem,
+		Content:   content,
+		System:    &payload,
+		CreatedAt: time.Now(),
+	}
+	if err = s.create(groupId, &msg); err != nil {
+		return nil, err
+	}
+	s.publish(model.EventMessageCreated, groupId, 0, msg)
+	return &msg, nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// resolveTimeout bounds the lookup of an integration URL when it is
+// registered.
+const resolveTimeout = 5 * time.Second
+
+var errInternalAddress = errors.New("internal address")
+
+// internalPrefixes are ranges not covered by the netip predicates that must
+// not be reached by integrations.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("fd00:ec2::254/128"),
+}
+
+// internalAddr reports whether addr is a loopback, private, link-local,
+// metadata or otherwise non-public address.
+func internalAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return true
+	}
+	for _, prefix := range internalPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOutboundURL fails unless rawURL is an absolute http or https URL
+// whose host resolves only to public addresses. allowInternal skips the
+// address check, for development setups.
+func checkOutboundURL(rawURL string, allowInternal bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if allowInternal {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("cannot resolve host %q", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if internalAddr(addr) {
+			return fmt.Errorf("host %q resolves to an %s", u.Hostname(), errInternalAddress)
+		}
+	}
+	return nil
+}
+
+// newOutboundClient returns a client for integration endpoints. Unless
+// allowInternal is set, it refuses to connect to internal addresses, which
+// also covers hosts that resolve differently than when they were checked.
+func newOutboundClient(timeout time.Duration, allowInternal bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !allowInternal {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if internalAddr(addrPort.Addr()) {
+				return fmt.Errorf("dial %s: %w", address, errInternalAddress)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would dial the endpoint on our behalf, bypassing the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxPollQuestion = 300
+	maxPollOption   = 100
+	maxPollOptions  = 10
+)
+
+// PollService manages polls posted to groups. Every change of the votes is
+// published as a poll.updated event carrying the new tally.
+type PollService struct {
+	store    store.ContactsStore
+	access   access.ContactsAccess
+	messages *MessageService
+	events   *event.EventHandler
+}
+
+func NewPollService(contactsStore store.ContactsStore, messages *MessageService, events *event.EventHandler) (*PollService, error) {
+	s := PollService{
+		store:    contactsStore,
+		messages: messages,
+		events:   events,
+	}
+	return &s, nil
+}
+
+// PollParams describe a new poll.
+type PollParams struct {
+	Question  string     `json:"question"`
+	Options   []string   `json:"options"`
+	Multiple  bool       `json:"multiple"`
+	Anonymous bool       `json:"anonymous"`
+	ClosesAt  *time.Time `json:"closes_at"`
+}
+
+// Create posts a poll message to the group.
+func (s *PollService) Create(userId, groupId int, params PollParams) (*model.Poll, error) {
+	poll, err := params.poll(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err = s.messages.Contacts.CanPost(groupId, userId); err != nil {
+		return nil, err
+	}
+	poll.GroupId = groupId
+	poll.CreatedBy = userId
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if poll, err = txc.CreatePoll(*poll); err != nil {
+		return nil, fmt.Errorf("CreatePoll: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.messages.Send(userId, SendParams{
+		ChatId:  groupId,
+		Content: poll.Question,
+		Type:    model.MessagePoll,
+		PollId:  poll.Id,
+	})
+	if err != nil {
+		s.deletePoll(poll.Id)
+		return nil, err
+	}
+
+	txc, err = s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = txc.SetPollMessage(poll.Id, msg.Id); err != nil {
+		return nil, fmt.Errorf("SetPollMessage: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	poll.MessageId = msg.Id
+	return s.view(poll, userId), nil
+}
+
+// poll validates the params.
+func (params PollParams) poll(now time.Time) (*model.Poll, error) {
+	question := strings.TrimSpace(params.Question)
+	if question == "" || len(question) > maxPollQuestion {
+		return nil, &store.Error{
+			Kind:    store.KindPoll,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("question must be 1 to %d characters long", maxPollQuestion),
+		}
+	}
+	if len(params.Options) < 2 || len(params.Options) > maxPollOptions {
+		return nil, &store.Error{
+			Kind:    store.KindPoll,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("a poll must have 2 to %d options", maxPollOptions),
+		}
+	}
+	poll := model.Poll{
+		Question:  question,
+		Multiple:  params.Multiple,
+		Anonymous: params.Anonymous,
+	}
+	seen := make(map[string]bool)
+	for _, text := range params.Options {
+		text = strings.TrimSpace(text)
+		if text == "" || len(text) > maxPollOption || seen[text] {
+			return nil, &store.Error{
+				Kind:    store.KindPoll,
+				Err:     store.ErrBadRequest,
+				Message: fmt.Sprintf("options must be distinct and 1 to %d characters long", maxPollOption),
+			}
+		}
+		seen[text] = true
+		poll.Options = append(poll.Options, model.PollOption{Text: text})
+	}
+	if params.ClosesAt != nil {
+		if !params.ClosesAt.After(now) {
+			return nil, &store.Error{
+				Kind:    store.KindPoll,
+				Err:     store.ErrBadRequest,
+				Message: "closes_at must be in the future",
+			}
+		}
+		closesAt := params.ClosesAt.UTC()
+		poll.ClosesAt = &closesAt
+	}
+	return &poll, nil
+}
+
+func (s *PollService) deletePoll(pollId int) {
+	txc, err := s.store.Begin()
+	if err == nil {
+		defer txc.Rollback()
+		if err = txc.DeletePoll(pollId); err == nil {
+			err = txc.Commit()
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Int("pollId", pollId).Msg("delete poll")
+	}
+}
+
+func (s *PollService) Get(userId, groupId, pollId int) (*model.Poll, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	poll, _, err := s.getPoll(txc, groupId, userId, pollId)
+	if err != nil {
+		return nil, err
+	}
+	return s.view(poll, userId), nil
+}
+
+// Vote adds a vote of the user for the option. In single choice polls it
+// replaces the previous vote.
+func (s *PollService) Vote(userId, groupId, pollId, optionId int) (*model.Poll, error) {
+	return s.updateVotes(userId, groupId, pollId, optionId, func(txc store.TxContacts, poll *model.Poll) error {
+		if !poll.Multiple {
+			if err := txc.DeleteVotes(pollId, userId); err != nil {
+				return fmt.Errorf("DeleteVotes: %w", err)
+			}
+		}
+		return txc.CreateVote(pollId, optionId, userId)
+	})
+}
+
+// Unvote withdraws a vote of the user for the option.
+func (s *PollService) Unvote(userId, groupId, pollId, optionId int) (*model.Poll, error) {
+	return s.updateVotes(userId, groupId, pollId, optionId, func(txc store.TxContacts, poll *model.Poll) error {
+		return txc.DeleteVote(pollId, optionId, userId)
+	})
+}
+
+func (s *PollService) updateVotes(userId, groupId, pollId, optionId int, update func(store.TxContacts, *model.Poll) error) (*model.Poll, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	poll, _, err := s.getPoll(txc, groupId, userId, pollId)
+	if err != nil {
+		return nil, err
+	}
+	if poll.IsClosed(time.Now()) {
+		return nil, &store.Error{
+			Kind:    store.KindPoll,
+			Err:     store.ErrBadRequest,
+			Message: "the poll is closed",
+		}
+	}
+	if !poll.HasOption(optionId) {
+		return nil, &store.Error{
+			Kind:    store.KindPoll,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("option '%d' not found", optionId),
+		}
+	}
+	if err = update(txc, poll); err != nil {
+		return nil, err
+	}
+	if poll, err = txc.GetPoll(pollId); err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	s.publish(poll, userId)
+	return s.view(poll, userId), nil
+}
+
+// Close closes the poll to further votes. Polls can be closed by their
+// creator and by the members access allows to.
+func (s *PollService) Close(userId, groupId, pollId int) (*model.Poll, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	poll, member, err := s.getPoll(txc, groupId, userId, pollId)
+	if err != nil {
+		return nil, err
+	}
+	if poll.CreatedBy != userId && !s.access.Can(member.Role, member.Role, access.ActionClosePoll) {
+		return nil, &store.Error{
+			Kind:    store.KindPoll,
+			Err:     store.ErrPermissionDenied,
+			Message: "only the creator, managers and owners can close a poll",
+		}
+	}
+	if poll.ClosedAt != nil {
+		return s.view(poll, userId), nil
+	}
+	if err = txc.ClosePoll(pollId); err != nil {
+		return nil, fmt.Errorf("ClosePoll: %w", err)
+	}
+	if poll, err = txc.GetPoll(pollId); err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	s.publish(poll, userId)
+	return s.view(poll, userId), nil
+}
+
+// getPoll returns the poll of the group if the user is a member of it.
+func (s *PollService) getPoll(txc store.TxContacts, groupId, userId, pollId int) (*model.Poll, *model.Member, error) {
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return nil, nil, &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("cannot find group %d for user %d", groupId, userId),
+		}
+	}
+	poll, err := txc.GetPoll(pollId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if poll.GroupId != groupId {
+		return nil, nil, &store.Error{
+			Kind:    store.KindPoll,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("poll '%d' not found", pollId),
+		}
+	}
+	return poll, member, nil
+}
+
+// view returns the poll as seen by the user: with the options the user voted
+// for, and without the voters of anonymous polls. A zero user sees no votes
+// of its own.
+func (s *PollService) view(poll *model.Poll, userId int) *model.Poll {
+	v := *poll
+	v.Closed = v.IsClosed(time.Now())
+	v.Voted = nil
+	v.Options = make([]model.PollOption, len(poll.Options))
+	for i, option := range poll.Options {
+		if userId != 0 && slices.Contains(option.Voters, userId) {
+			v.Voted = append(v.Voted, option.Id)
+		}
+		if v.Anonymous {
+			option.Voters = nil
+		}
+		v.Options[i] = option
+	}
+	return &v
+}
+
+// publish sends the tally of the poll to the members of its group.
+func (s *PollService) publish(poll *model.Poll, actorId int) {
+	s.events.Publish(model.EventPollUpdated, model.ChatEvent{
+		Type:    model.EventPollUpdated,
+		GroupId: poll.GroupId,
+		ActorId: actorId,
+		Data:    s.view(poll, 0),
+	})
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func newTestPollService(t *testing.T) (*PollService, *MessageService, *PresetResult) {
+	t.Helper()
+	webhooks, messages, result := newTestWebhookService(t, config.WebhookConfig{})
+	polls, err := NewPollService(webhooks.store, messages, messages.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return polls, messages, result
+}
+
+func TestPollService_Create(t *testing.T) {
+	polls, _, result := newTestPollService(t)
+	p1, _ := result.GetProfile("p1")
+	g1, _ := result.GetGroup("g1")
+	past := time.Now().Add(-time.Minute)
+
+	testCases := map[string]struct {
+		userId  int
+		params  PollParams
+		wantErr error
+	}{
+		"valid":          {params: PollParams{Question: "lunch?", Options: []string{"yes", "no"}}},
+		"no question":    {params: PollParams{Question: " ", Options: []string{"yes", "no"}}, wantErr: store.ErrBadRequest},
+		"one option":     {params: PollParams{Question: "lunch?", Options: []string{"yes"}}, wantErr: store.ErrBadRequest},
+		"same options":   {params: PollParams{Question: "lunch?", Options: []string{"yes", "yes "}}, wantErr: store.ErrBadRequest},
+		"closes in past": {params: PollParams{Question: "lunch?", Options: []string{"yes", "no"}, ClosesAt: &past}, wantErr: store.ErrBadRequest},
+		"not a member":   {userId: 99, params: PollParams{Question: "lunch?", Options: []string{"yes", "no"}}, wantErr: store.ErrNotFound},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			userId := p1.Id
+			if tc.userId != 0 {
+				userId = tc.userId
+			}
+			_, err := polls.Create(userId, g1.Id, tc.params)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error %q, but got %q", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPollService_Vote(t *testing.T) {
+	polls, messages, result := newTestPollService(t)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	var mu sync.Mutex
+	var updates []*model.Poll
+	if err := messages.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	messages.Subscribe(t.Context(), p2.Id, func(e model.ChatEvent) error {
+		if poll, ok := e.Data.(*model.Poll); ok && e.Type == model.EventPollUpdated {
+			mu.Lock()
+			updates = append(updates, poll)
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	poll, err := polls.Create(p1.Id, g1.Id, PollParams{Question: "lunch?", Options: []string{"pizza", "sushi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := messages.List(p2.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Type != model.MessagePoll || msgs[0].PollId != poll.Id || msgs[0].Id != poll.MessageId {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	pizza, sushi := poll.Options[0].Id, poll.Options[1].Id
+
+	if _, err = polls.Vote(p2.Id, g1.Id, poll.Id, pizza); err != nil {
+		t.Fatal(err)
+	}
+	// a second vote replaces the first in single choice polls
+	if poll, err = polls.Vote(p2.Id, g1.Id, poll.Id, sushi); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(poll.Voted, []int{sushi}) || poll.Options[0].Votes != 0 || !slices.Equal(poll.Options[1].Voters, []int{p2.Id}) {
+		t.Errorf("unexpected poll %+v", poll)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(updates) == 2 && updates[1].Options[1].Votes == 1
+	})
+
+	if _, err = polls.Vote(p2.Id, g1.Id, poll.Id, 999); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	if _, err = polls.Unvote(p3.Id, g1.Id, poll.Id, sushi); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+
+	if _, err = polls.Close(p2.Id, g1.Id, poll.Id); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	if poll, err = polls.Close(p3.Id, g1.Id, poll.Id); err != nil {
+		t.Fatal(err)
+	}
+	if !poll.Closed || poll.ClosedAt == nil {
+		t.Errorf("expected closed poll, but got %+v", poll)
+	}
+	if _, err = polls.Vote(p3.Id, g1.Id, poll.Id, pizza); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+}
+
+func TestPollService_AnonymousMultiple(t *testing.T) {
+	polls, _, result := newTestPollService(t)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	poll, err := polls.Create(p2.Id, g1.Id, PollParams{
+		Question:  "which days?",
+		Options:   []string{"mon", "tue", "wed"},
+		Multiple:  true,
+		Anonymous: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon, tue := poll.Options[0].Id, poll.Options[1].Id
+	for _, optionId := range []int{mon, tue, mon} {
+		if poll, err = polls.Vote(p1.Id, g1.Id, poll.Id, optionId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(poll.Voted, []int{mon, tue}) || poll.Options[0].Votes != 1 || poll.Options[0].Voters != nil {
+		t.Errorf("unexpected poll %+v", poll)
+	}
+	if poll, err = polls.Unvote(p1.Id, g1.Id, poll.Id, mon); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(poll.Voted, []int{tue}) {
+		t.Errorf("expected votes %v, but got %v", []int{tue}, poll.Voted)
+	}
+
+	// others see the tally but not who voted
+	if poll, err = polls.Get(p2.Id, g1.Id, poll.Id); err != nil {
+		t.Fatal(err)
+	}
+	if poll.Voted != nil || poll.Options[1].Votes != 1 || poll.Options[1].Voters != nil {
+		t.Errorf("unexpected poll %+v", poll)
+	}
+	// creators can close their own polls
+	if _, err = polls.Close(p2.Id, g1.Id, poll.Id); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// RateLimitError is returned when a client exceeds its request rate.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry in %s", err.RetryAfter.Round(time.Second))
+}
+
+func (err *RateLimitError) Unwrap() error {
+	return store.ErrTooManyRequests
+}
+
+// rateLimiter is an in-memory token bucket per key. It refills perMinute
+// tokens a minute up to burst.
+type rateLimiter struct {
+	perMinute int
+	burst     int
+
+	mu      sync.Mutex
+	buckets map[int]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		perMinute: perMinute,
+		burst:     burst,
+		buckets:   make(map[int]*bucket),
+	}
+}
+
+// allow takes a token of the key, or fails with how long until one is
+// available.
+func (l *rateLimiter) allow(key int, now time.Time) error {
+	if l.perMinute <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	perSecond := float64(l.perMinute) / 60
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(l.burst), b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+		return &RateLimitError{RetryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+// expireBatch is the number of messages deleted per store call.
+const expireBatch = 100
+
+// RetentionService enforces the retention rules: it deletes expired messages
+// of groups with disappearing messages, leaving tombstones in their place,
+// messages older than the server-wide maximum age, and the data of deleted
+// accounts once their grace period is over.
+type RetentionService struct {
+	messages *MessageService
+	users    store.UserStore
+	cfg      config.RetentionConfig
+}
+
+func NewRetentionService(messages *MessageService, userStore store.UserStore, cfg config.RetentionConfig) (*RetentionService, error) {
+	s := RetentionService{
+		messages: messages,
+		users:    userStore,
+		cfg:      cfg,
+	}
+	return &s, nil
+}
+
+// RetentionReport counts what a retention run removed, or would remove in a
+// dry run.
+type RetentionReport struct {
+	// Expired is the number of disappearing messages replaced by tombstones.
+	Expired int `json:"expired"`
+	// Messages is the number of messages older than the maximum age.
+	Messages int `json:"messages"`
+	// Accounts is the number of deleted accounts whose data was purged.
+	Accounts int `json:"accounts"`
+}
+
+func (r RetentionReport) empty() bool {
+	return r == RetentionReport{}
+}
+
+// Start runs the retention rules in the background until ctx is done.
+func (s *RetentionService) Start(ctx context.Context) error {
+	go s.run(ctx)
+	return nil
+}
+
+func (s *RetentionService) run(ctx context.Context) {
+	interval := s.cfg.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.Run(ctx, false)
+		if err != nil {
+			log.Error().Err(err).Msg("run retention")
+		}
+		if report != nil && !report.empty() {
+			log.Info().
+				Int("expired", report.Expired).
+				Int("messages", report.Messages).
+				Int("accounts", report.Accounts).
+				Msg("retention")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run applies the retention rules once. A dry run only counts what would be
+// removed. The report covers what was done before an error.
+func (s *RetentionService) Run(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	var report RetentionReport
+	now := time.Now()
+
+	if dryRun {
+		n, err := s.messages.store.CountExpiredMessages(now)
+		if err != nil {
+			return &report, fmt.Errorf("CountExpiredMessages: %w", err)
+		}
+		report.Expired = n
+	} else {
+		n, err := drain(ctx, func() (int, error) {
+			return s.messages.Expire(now, expireBatch)
+		})
+		report.Expired = n
+		if err != nil {
+			return &report, err
+		}
+	}
+
+	if s.cfg.MessageMaxAge > 0 {
+		before := now.Add(-s.cfg.MessageMaxAge)
+		if dryRun {
+			n, err := s.messages.store.CountMessagesBefore(before)
+			if err != nil {
+				return &report, fmt.Errorf("CountMessagesBefore: %w", err)
+			}
+			report.Messages = n
+		} else {
+			n, err := drain(ctx, func() (int, error) {
+				return s.messages.DeleteBefore(before, expireBatch)
+			})
+			report.Messages = n
+			if err != nil {
+				return &report, err
+			}
+		}
+	}
+
+	n, err := s.purgeAccounts(ctx, now.Add(-s.cfg.AccountGracePeriod), dryRun)
+	report.Accounts = n
+	return &report, err
+}
+
+// drain calls fn until it removes less than a full batch and returns the
+// total removed.
+func drain(ctx context.Context, fn func() (int, error)) (int, error) {
+	total := 0
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		n, err := fn()
+		total += n
+		if err != nil || n < expireBatch {
+			return total, err
+		}
+	}
+}
+
+// purgeAccounts deletes the profile and credentials of accounts deleted
+// before the time and returns how many were purged.
+func (s *RetentionService) purgeAccounts(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+	txu, err := s.users.Begin()
+	if err != nil {
+		return 0, err
+	}
+	ids, err := txu.GetDeletedUsers(before)
+	txu.Rollback()
+	if err != nil {
+		return 0, fmt.Errorf("GetDeletedUsers: %w", err)
+	}
+	if dryRun {
+		return len(ids), nil
+	}
+
+	purged := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return purged, ctx.Err()
+		}
+		if err = s.purgeAccount(id); err != nil {
+			return purged, fmt.Errorf("purge account %d: %w", id, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (s *RetentionService) purgeAccount(userId int) error {
+	err := s.messages.Contacts.DeleteProfile(userId)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	txu, err := s.users.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	if err = txu.DeleteUser(userId); err != nil {
+		return fmt.Errorf("DeleteUser: %w", err)
+	}
+	return txu.Commit()
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/store/user/sqlite"
+)
+
+func TestRetentionService_Run(t *testing.T) {
+	polls, messages, result := newTestPollService(t)
+	p1, _ := result.GetProfile("p1")
+	g1, _ := result.GetGroup("g1")
+
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retention, err := NewRetentionService(messages, userStore, config.RetentionConfig{MessageMaxAge: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = messages.Send(p1.Id, SendParams{ChatId: g1.Id, Content: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	poll, err := polls.Create(p1.Id, g1.Id, PollParams{Question: "lunch?", Options: []string{"pizza", "sushi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err = messages.Send(p1.Id, SendParams{ChatId: g1.Id, Content: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := retention.Run(t.Context(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *report != (RetentionReport{Messages: 2}) {
+		t.Errorf("unexpected dry run report %+v", report)
+	}
+	if msgs, err := messages.List(p1.Id, g1.Id); err != nil || len(msgs) != 3 {
+		t.Fatalf("expected dry run to keep 3 messages, but got %+v: %v", msgs, err)
+	}
+
+	if report, err = retention.Run(t.Context(), false); err != nil {
+		t.Fatal(err)
+	}
+	if *report != (RetentionReport{Messages: 2}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	msgs, err := messages.List(p1.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "new" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+	if _, err = polls.Get(p1.Id, g1.Id, poll.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+}
@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// jobBatch is the number of due jobs run per poll.
+	jobBatch = 20
+	// maxPendingJobs is the number of pending jobs a user can have.
+	maxPendingJobs = 100
+)
+
+// ScheduleService sends scheduled messages and reminders. Jobs are kept in
+// the contacts store and run by Start once due, so they survive restarts.
+// Each job runs at most once; jobs that cannot run are marked failed. Due
+// reminders stay listed until the user dismisses them, so they are not lost
+// when the user is offline.
+type ScheduleService struct {
+	store    store.ContactsStore
+	messages *MessageService
+	events   *event.EventHandler
+	cfg      config.ScheduleConfig
+	// wake triggers a run when a job is scheduled or changed
+	wake chan struct{}
+}
+
+func NewScheduleService(contactsStore store.ContactsStore, messages *MessageService, events *event.EventHandler, cfg config.ScheduleConfig) (*ScheduleService, error) {
+	s := ScheduleService{
+		store:    contactsStore,
+		messages: messages,
+		events:   events,
+		cfg:      cfg,
+		wake:     make(chan struct{}, 1),
+	}
+	return &s, nil
+}
+
+// ScheduleMessage sends the content to the group as the user at runAt.
+func (s *ScheduleService) ScheduleMessage(userId, groupId int, content string, runAt time.Time) (*model.ScheduledJob, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrBadRequest,
+			Message: "message content must not be empty",
+		}
+	}
+	if err := checkRunAt(runAt); err != nil {
+		return nil, err
+	}
+	if err := s.messages.Contacts.CanPost(groupId, userId); err != nil {
+		return nil, err
+	}
+	return s.create(model.ScheduledJob{
+		Kind:    model.JobMessage,
+		UserId:  userId,
+		GroupId: groupId,
+		Content: content,
+		RunAt:   runAt,
+	})
+}
+
+// Remind reminds the user of a message of the group at runAt, with an
+// optional note. Without a message the note is required.
+func (s *ScheduleService) Remind(userId, groupId int, messageId, note string, runAt time.Time) (*model.ScheduledJob, error) {
+	if err := checkRunAt(runAt); err != nil {
+		return nil, err
+	}
+	if messageId == "" && strings.TrimSpace(note) == "" {
+		return nil, &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrBadRequest,
+			Message: "a reminder needs a message or a note",
+		}
+	}
+	if _, err := s.messages.Contacts.GetGroup(groupId, userId); err != nil {
+		return nil, err
+	}
+	if messageId != "" {
+		if _, err := s.messages.getGroupMessage(groupId, messageId); err != nil {
+			return nil, err
+		}
+	}
+	return s.create(model.ScheduledJob{
+		Kind:      model.JobReminder,
+		UserId:    userId,
+		GroupId:   groupId,
+		MessageId: messageId,
+		Content:   strings.TrimSpace(note),
+		RunAt:     runAt,
+	})
+}
+
+func checkRunAt(runAt time.Time) error {
+	if !runAt.After(time.Now()) {
+		return &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrBadRequest,
+			Message: "time must be in the future",
+		}
+	}
+	return nil
+}
+
+func (s *ScheduleService) create(job model.ScheduledJob) (*model.ScheduledJob, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	pending, err := txc.GetJobs(job.UserId, model.JobPending)
+	if err != nil {
+		return nil, fmt.Errorf("GetJobs: %w", err)
+	}
+	if len(pending) >= maxPendingJobs {
+		return nil, &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("at most %d messages and reminders can be scheduled", maxPendingJobs),
+		}
+	}
+	created, err := txc.CreateJob(job)
+	if err != nil {
+		return nil, fmt.Errorf("CreateJob: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	s.trigger()
+	return created, nil
+}
+
+// List returns the pending scheduled messages and reminders of the user,
+// next due first.
+func (s *ScheduleService) List(userId int) ([]model.ScheduledJob, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	return txc.GetJobs(userId, model.JobPending)
+}
+
+// JobUpdate changes a pending job. Nil fields are left unchanged.
+type JobUpdate struct {
+	Content *string    `json:"content"`
+	RunAt   *time.Time `json:"run_at"`
+}
+
+func (s *ScheduleService) Update(userId, jobId int, update JobUpdate) (*model.ScheduledJob, error) {
+	if update.RunAt != nil {
+		if err := checkRunAt(*update.RunAt); err != nil {
+			return nil, err
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	job, err := s.getPendingJob(txc, userId, jobId)
+	if err != nil {
+		return nil, err
+	}
+	content, runAt := job.Content, job.RunAt
+	if update.Content != nil {
+		content = *update.Content
+	}
+	if update.RunAt != nil {
+		runAt = *update.RunAt
+	}
+	if job.Kind == model.JobMessage && strings.TrimSpace(content) == "" {
+		return nil, &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrBadRequest,
+			Message: "message content must not be empty",
+		}
+	}
+	if job, err = txc.UpdateJob(jobId, content, runAt); err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	s.trigger()
+	return job, nil
+}
+
+// Cancel deletes a pending job of the user.
+func (s *ScheduleService) Cancel(userId, jobId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if _, err = s.getPendingJob(txc, userId, jobId); err != nil {
+		return err
+	}
+	if err = txc.DeleteJob(jobId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+func (s *ScheduleService) getPendingJob(txc store.TxContacts, userId, jobId int) (*model.ScheduledJob, error) {
+	job, err := txc.GetJob(jobId)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserId != userId {
+		return nil, &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("scheduled job '%d' not found", jobId),
+		}
+	}
+	if job.Status != model.JobPending {
+		return nil, &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("scheduled job '%d' already ran", jobId),
+		}
+	}
+	return job, nil
+}
+
+// Start runs due jobs in the background until ctx is done.
+func (s *ScheduleService) Start(ctx context.Context) error {
+	go s.run(ctx)
+	return nil
+}
+
+func (s *ScheduleService) run(ctx context.Context) {
+	interval := s.cfg.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunDue(ctx); err != nil {
+			log.Error().Err(err).Msg("run scheduled jobs")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *ScheduleService) trigger() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunDue runs the jobs that are due and returns how many ran successfully.
+func (s *ScheduleService) RunDue(ctx context.Context) (int, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	jobs, err := txc.GetDueJobs(jobBatch)
+	txc.Rollback()
+	if err != nil {
+		return 0, fmt.Errorf("GetDueJobs: %w", err)
+	}
+
+	ran := 0
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return ran, ctx.Err()
+		}
+		claimed, err := s.claim(job.Id)
+		if err != nil {
+			return ran, err
+		}
+		if !claimed {
+			continue
+		}
+		if err = s.runJob(job); err != nil {
+			log.Warn().Err(err).Int("jobId", job.Id).Msg("scheduled job failed")
+			if err = s.fail(job.Id, err); err != nil {
+				return ran, err
+			}
+			continue
+		}
+		ran++
+	}
+	return ran, nil
+}
+
+func (s *ScheduleService) claim(jobId int) (bool, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txc.Rollback()
+
+	claimed, err := txc.ClaimJob(jobId)
+	if err != nil {
+		return false, fmt.Errorf("ClaimJob: %w", err)
+	}
+	return claimed, txc.Commit()
+}
+
+func (s *ScheduleService) fail(jobId int, reason error) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	var storeErr *store.Error
+	message := reason.Error()
+	if errors.As(reason, &storeErr) {
+		message = storeErr.Message
+	}
+	if err = txc.FailJob(jobId, message); err != nil {
+		return fmt.Errorf("FailJob: %w", err)
+	}
+	return txc.Commit()
+}
+
+func (s *ScheduleService) runJob(job model.ScheduledJob) error {
+	switch job.Kind {
+	case model.JobMessage:
+		_, err := s.messages.Send(job.UserId, SendParams{ChatId: job.GroupId, Content: job.Content})
+		return err
+	case model.JobReminder:
+		if _, err := s.messages.Contacts.GetGroup(job.GroupId, job.UserId); err != nil {
+			return err
+		}
+		if err := s.setStatus(job.Id, model.JobDue); err != nil {
+			return err
+		}
+		job.Status = model.JobDue
+		s.events.Publish(model.EventReminderDue, model.ChatEvent{
+			Type:        model.EventReminderDue,
+			GroupId:     job.GroupId,
+			RecipientId: job.UserId,
+			Data:        s.reminder(job),
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown job kind %q", job.Kind)
+}
+
+func (s *ScheduleService) setStatus(jobId int, status model.JobStatus) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if err = txc.SetJobStatus(jobId, status); err != nil {
+		return fmt.Errorf("SetJobStatus: %w", err)
+	}
+	return txc.Commit()
+}
+
+// reminder attaches the message to a reminder job. The reminder is still
+// delivered if the message was deleted.
+func (s *ScheduleService) reminder(job model.ScheduledJob) model.Reminder {
+	var msg *model.Message
+	if job.MessageId != "" {
+		msg, _ = s.messages.getGroupMessage(job.GroupId, job.MessageId)
+	}
+	return model.Reminder{Job: job, Message: msg}
+}
+
+// Reminders returns the due reminders of the user that were not dismissed,
+// oldest first.
+func (s *ScheduleService) Reminders(userId int) ([]model.Reminder, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := txc.GetJobs(userId, model.JobDue)
+	txc.Rollback()
+	if err != nil {
+		return nil, fmt.Errorf("GetJobs: %w", err)
+	}
+
+	reminders := make([]model.Reminder, 0, len(jobs))
+	for _, job := range jobs {
+		reminders = append(reminders, s.reminder(job))
+	}
+	return reminders, nil
+}
+
+// Dismiss marks a due reminder of the user as done.
+func (s *ScheduleService) Dismiss(userId, jobId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	job, err := txc.GetJob(jobId)
+	if err != nil {
+		return err
+	}
+	if job.UserId != userId || job.Status != model.JobDue {
+		return &store.Error{
+			Kind:    store.KindJob,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("reminder '%d' not found", jobId),
+		}
+	}
+	if err = txc.SetJobStatus(jobId, model.JobDone); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func newTestScheduleService(t *testing.T) (*ScheduleService, *MessageService, *PresetResult) {
+	t.Helper()
+	webhooks, messages, result := newTestWebhookService(t, config.WebhookConfig{})
+	schedules, err := NewScheduleService(webhooks.store, messages, messages.events, config.ScheduleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedules, messages, result
+}
+
+func TestScheduleService_Run(t *testing.T) {
+	schedules, messages, result := newTestScheduleService(t)
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	var mu sync.Mutex
+	var reminders []model.Reminder
+	if err := messages.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	messages.Subscribe(t.Context(), p2.Id, func(e model.ChatEvent) error {
+		if r, ok := e.Data.(model.Reminder); ok {
+			mu.Lock()
+			reminders = append(reminders, r)
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	_, err := schedules.ScheduleMessage(p2.Id, g1.Id, "hello", time.Now().Add(-time.Minute))
+	if !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+	if _, err = schedules.ScheduleMessage(99, g1.Id, "hello", time.Now().Add(time.Minute)); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+
+	msg, err := messages.Send(p1.Id, SendParams{ChatId: g1.Id, Content: "review this"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runAt := time.Now().Add(100 * time.Millisecond)
+	scheduled, err := schedules.ScheduleMessage(p2.Id, g1.Id, "draft", runAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminder, err := schedules.Remind(p2.Id, g1.Id, msg.Id, "after lunch", runAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := schedules.ScheduleMessage(p2.Id, g1.Id, "never", runAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "good morning"
+	if _, err = schedules.Update(p2.Id, scheduled.Id, JobUpdate{Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+	if err = schedules.Cancel(p1.Id, cancelled.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	if err = schedules.Cancel(p2.Id, cancelled.Id); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := schedules.List(p2.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 pending jobs, but got %+v", jobs)
+	}
+
+	if n, err := schedules.RunDue(t.Context()); err != nil || n != 0 {
+		t.Fatalf("expected no due jobs, but ran %d: %v", n, err)
+	}
+	time.Sleep(time.Until(runAt) + 50*time.Millisecond)
+	if n, err := schedules.RunDue(t.Context()); err != nil || n != 2 {
+		t.Fatalf("expected 2 jobs to run, but ran %d: %v", n, err)
+	}
+
+	msgs, err := messages.List(p1.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[1].Content != content || msgs[1].SenderId != "2" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reminders) == 1
+	})
+	if got := reminders[0]; got.Job.Id != reminder.Id || got.Message == nil || got.Message.Id != msg.Id {
+		t.Errorf("unexpected reminder %+v", got)
+	}
+
+	if jobs, err = schedules.List(p2.Id); err != nil || len(jobs) != 0 {
+		t.Errorf("expected no pending jobs, but got %+v: %v", jobs, err)
+	}
+
+	// the reminder is kept until it is dismissed
+	due, err := schedules.Reminders(p2.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].Job.Id != reminder.Id || due[0].Message == nil || due[0].Message.Id != msg.Id {
+		t.Errorf("unexpected reminders %+v", due)
+	}
+	if err = schedules.Dismiss(p1.Id, reminder.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	if err = schedules.Dismiss(p2.Id, reminder.Id); err != nil {
+		t.Fatal(err)
+	}
+	if due, err = schedules.Reminders(p2.Id); err != nil || len(due) != 0 {
+		t.Errorf("expected no reminders, but got %+v: %v", due, err)
+	}
+	if err = schedules.Dismiss(p2.Id, reminder.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	if err = schedules.Cancel(p2.Id, scheduled.Id); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+}
+
+func TestScheduleService_Fail(t *testing.T) {
+	schedules, _, result := newTestScheduleService(t)
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	runAt := time.Now().Add(50 * time.Millisecond)
+	job, err := schedules.ScheduleMessage(p2.Id, g1.Id, "bye", runAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the user leaves the group before the message is due
+	txc, err := schedules.store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = schedules.messages.Contacts.deleteMember(txc, g1.Id, p2.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = txc.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Until(runAt) + 50*time.Millisecond)
+	if n, err := schedules.RunDue(t.Context()); err != nil || n != 0 {
+		t.Fatalf("expected no successful jobs, but ran %d: %v", n, err)
+	}
+	if txc, err = schedules.store.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer txc.Rollback()
+	got, err := txc.GetJob(job.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != model.JobFailed || got.Error == "" {
+		t.Errorf("expected failed job, but got %+v", got)
+	}
+}
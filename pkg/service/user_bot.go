@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// CreateBot creates a bot user owned by the user. Bots have no password and
+// authenticate with API keys only.
+func (s *UserService) CreateBot(ctx context.Context, ownerId int, username string) (*model.User, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	owner, err := txu.GetUser(ownerId)
+	if err != nil {
+		return nil, err
+	}
+	if owner.Bot {
+		return nil, &store.Error{
+			Kind:    store.KindUser,
+			Err:     store.ErrPermissionDenied,
+			Message: "bots cannot own bots",
+		}
+	}
+	bot, err := txu.CreateBot(ownerId, username)
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return bot, nil
+}
+
+func (s *UserService) ListBots(ctx context.Context, ownerId int) ([]model.User, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	return txu.ListBots(ownerId)
+}
+
+// DeleteBot deletes a bot of the user together with its API keys.
+func (s *UserService) DeleteBot(ctx context.Context, ownerId, botId int) error {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	if _, err = getOwnedBot(txu, ownerId, botId); err != nil {
+		return err
+	}
+	if err = txu.DeleteUser(botId); err != nil {
+		return fmt.Errorf("DeleteUser: %w", err)
+	}
+	return txu.Commit()
+}
+
+// CreateAPIKey issues an API key for a bot of the user. The key is limited to
+// the scopes and, if any are given, to the groups. It is only returned here.
+func (s *UserService) CreateAPIKey(ctx context.Context, ownerId, botId int, name string, scopes []string, groupIds []int) (*model.APIKey, error) {
+	if len(scopes) == 0 {
+		return nil, &store.Error{
+			Kind:    store.KindToken,
+			Err:     store.ErrBadRequest,
+			Message: "at least one scope is required",
+		}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(model.APIScopes, scope) {
+			return nil, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrBadRequest,
+				Message: fmt.Sprintf("unknown scope %q", scope),
+			}
+		}
+	}
+	for _, groupId := range groupIds {
+		if groupId <= 0 {
+			return nil, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrBadRequest,
+				Message: fmt.Sprintf("invalid group id %d", groupId),
+			}
+		}
+	}
+
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	if _, err = getOwnedBot(txu, ownerId, botId); err != nil {
+		return nil, err
+	}
+	key, err := txu.CreateAPIKey(botId, name, scopes, groupIds)
+	if err != nil {
+		return nil, fmt.Errorf("CreateAPIKey: %w", err)
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *UserService) ListAPIKeys(ctx context.Context, ownerId, botId int) ([]model.APIKey, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	if _, err = getOwnedBot(txu, ownerId, botId); err != nil {
+		return nil, err
+	}
+	return txu.ListAPIKeys(botId)
+}
+
+func (s *UserService) RevokeAPIKey(ctx context.Context, ownerId, botId, keyId int) error {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	if _, err = getOwnedBot(txu, ownerId, botId); err != nil {
+		return err
+	}
+	if err = txu.DeleteAPIKey(botId, keyId); err != nil {
+		return err
+	}
+	return txu.Commit()
+}
+
+// getOwnedBot returns the bot if it is owned by the user. Bots of other users
+// are reported as not found.
+func getOwnedBot(txu store.TxUser, ownerId, botId int) (*model.User, error) {
+	bot, err := txu.GetUser(botId)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if err != nil || !bot.Bot || bot.OwnerId != ownerId {
+		return nil, &store.Error{
+			Kind:    store.KindUser,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("bot '%d' not found", botId),
+		}
+	}
+	return bot, nil
+}
+
+// authenticateAPIKey resolves an API key to a token of its bot. The token has
+// no session; its APIKey carries the scopes granted to the request.
+func authenticateAPIKey(txu store.TxUser, keyString string) (*model.Token, error) {
+	key, err := txu.GetAPIKey(keyString)
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.TouchAPIKey(key.Id); err != nil {
+		return nil, fmt.Errorf("TouchAPIKey: %w", err)
+	}
+	return &model.Token{
+		Id:          "key:" + strconv.Itoa(key.Id),
+		AccessToken: keyString,
+		IssuedAt:    key.CreatedAt,
+		UserId:      key.UserId,
+		APIKey:      key,
+	}, nil
+}
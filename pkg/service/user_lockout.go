@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+// ThrottledError is returned for login attempts made before the backoff of
+// an earlier failure has passed.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (err *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry in %s", err.RetryAfter.Round(time.Second))
+}
+
+func (err *ThrottledError) Unwrap() error {
+	return store.ErrTooManyRequests
+}
+
+// throttleKeys returns the counters a login attempt is charged to.
+func throttleKeys(username string, device model.Device) []string {
+	keys := []string{"user:" + username}
+	if device.IP != "" {
+		keys = append(keys, "ip:"+device.IP)
+	}
+	return keys
+}
+
+// checkThrottle fails if any of the keys is still backing off.
+func (s *UserService) checkThrottle(txu store.TxUser, keys []string, now time.Time) error {
+	var wait time.Duration
+	for _, key := range keys {
+		throttle, err := txu.GetLoginThrottle(key)
+		if err != nil {
+			return fmt.Errorf("GetLoginThrottle: %w", err)
+		}
+		if d := throttle.BlockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// recordFailure charges a failed login to the keys and schedules their next
+// allowed attempt.
+func (s *UserService) recordFailure(txu store.TxUser, keys []string, now time.Time) error {
+	cfg := s.auth.Lockout
+	for _, key := range keys {
+		throttle, err := txu.GetLoginThrottle(key)
+		if err != nil {
+			return fmt.Errorf("GetLoginThrottle: %w", err)
+		}
+		if cfg.Window > 0 && now.Sub(throttle.LastFailure) > cfg.Window {
+			throttle.Failures = 0
+		}
+		throttle.Failures++
+		throttle.LastFailure = now
+		throttle.BlockedUntil = now.Add(s.backoff(throttle.Failures))
+		if err = txu.SetLoginThrottle(*throttle); err != nil {
+			return fmt.Errorf("SetLoginThrottle: %w", err)
+		}
+	}
+	return nil
+}
+
+// backoff returns how long to refuse logins after the given number of
+// consecutive failures.
+func (s *UserService) backoff(failures int) time.Duration {
+	cfg := s.auth.Lockout
+	if cfg.MaxAttempts > 0 && failures >= cfg.MaxAttempts {
+		return cfg.Duration
+	}
+	if cfg.BaseDelay <= 0 {
+		return 0
+	}
+	delay := cfg.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if cfg.MaxDelay > 0 && delay >= cfg.MaxDelay {
+			return cfg.MaxDelay
+		}
+	}
+	if cfg.MaxDelay > 0 && delay > cfg.MaxDelay {
+		return cfg.MaxDelay
+	}
+	return delay
+}
+
+// failLogin audits a failed login, charges it to the keys and commits, then
+// returns cause.
+func (s *UserService) failLogin(txu store.TxUser, username, reason string, device model.Device, keys []string, now time.Time, cause error) error {
+	err := txu.CreateLoginAudit(model.LoginAudit{
+		Username: username,
+		Reason:   reason,
+		Device:   device,
+	})
+	if err != nil {
+		return fmt.Errorf("CreateLoginAudit: %w", err)
+	}
+	if err = s.recordFailure(txu, keys, now); err != nil {
+		return err
+	}
+	if err = txu.Commit(); err != nil {
+		return err
+	}
+	log.Warn().Str("username", username).Str("ip", device.IP).Str("reason", reason).Msg("failed login")
+	return cause
+}
+
+// ListFailedLogins returns the latest failed login attempts on the account.
+func (s *UserService) ListFailedLogins(ctx context.Context, userId int) ([]model.LoginAudit, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	user, err := txu.GetUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetUser: %w", err)
+	}
+	audits, err := txu.ListLoginAudits(user.Username, 50)
+	if err != nil {
+		return nil, fmt.Errorf("ListLoginAudits: %w", err)
+	}
+	return audits, nil
+}
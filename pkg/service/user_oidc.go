@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/oidc"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// OIDCLogin is the result of a login through the identity provider.
+type OIDCLogin struct {
+	Token *model.Token
+	User  *model.User
+	// Created is set on the first login of the identity.
+	Created bool
+	// Name is the display name claimed by the provider.
+	Name string
+}
+
+// ProfileCreator creates the chat profile of users signing up through the
+// identity provider.
+type ProfileCreator interface {
+	CreateProfile(userId int, name string) (*model.Profile, error)
+}
+
+// SetProfileCreator sets where the profiles of new single sign-on users are
+// created.
+func (s *UserService) SetProfileCreator(profiles ProfileCreator) {
+	s.profiles = profiles
+}
+
+var errOIDCDisabled = &store.Error{
+	Kind:    store.KindIdentity,
+	Err:     store.ErrNotFound,
+	Message: "single sign-on is disabled",
+}
+
+// BeginOIDC starts a login at the identity provider and returns the URL to
+// send the user to.
+func (s *UserService) BeginOIDC(ctx context.Context) (string, error) {
+	if s.oidc == nil {
+		return "", errOIDCDisabled
+	}
+	state := rand.Text()
+	verifier, challenge := oidc.NewPKCE()
+	oidcState := model.OIDCState{CodeVerifier: verifier, Nonce: rand.Text()}
+
+	authURL, err := s.oidc.AuthCodeURL(ctx, state, oidcState.Nonce, challenge)
+	if err != nil {
+		return "", fmt.Errorf("AuthCodeURL: %w", err)
+	}
+	txu, err := s.store.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer txu.Rollback()
+
+	if err = txu.CreateOIDCState(state, oidcState, s.auth.OIDC.StateTTL); err != nil {
+		return "", fmt.Errorf("CreateOIDCState: %w", err)
+	}
+	if err = txu.Commit(); err != nil {
+		return "", err
+	}
+	return authURL, nil
+}
+
+// CompleteOIDC redeems the code of the provider callback and starts a session
+// for the linked user. Unknown identities get a new user with a profile.
+// Users with 2FA get a ChallengeError to complete with CompleteLogin, as with
+// a password login.
+func (s *UserService) CompleteOIDC(ctx context.Context, state, code string, device model.Device) (*OIDCLogin, error) {
+	if s.oidc == nil {
+		return nil, errOIDCDisabled
+	}
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	oidcState, err := txu.UseOIDCState(state)
+	if err != nil {
+		txu.Rollback()
+		return nil, err
+	}
+	// the state is consumed even if the exchange fails
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+
+	idToken, err := s.oidc.Exchange(ctx, code, oidcState.CodeVerifier)
+	if err != nil {
+		return nil, &store.Error{
+			Kind:    store.KindIdentity,
+			Err:     store.ErrUnauthorized,
+			Message: err.Error(),
+		}
+	}
+	if idToken.Nonce != oidcState.Nonce {
+		return nil, &store.Error{
+			Kind:    store.KindIdentity,
+			Err:     store.ErrUnauthorized,
+			Message: "nonce mismatch",
+		}
+	}
+
+	txu, err = s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	login := OIDCLogin{Name: idToken.Name}
+	identity, err := txu.GetExternalIdentity(s.oidc.Issuer(), idToken.Subject)
+	switch {
+	case err == nil:
+		login.User, err = txu.GetUser(identity.UserId)
+		if err != nil {
+			return nil, fmt.Errorf("GetUser: %w", err)
+		}
+	case errors.Is(err, store.ErrNotFound):
+		username, err := uniqueUsername(txu, usernameFromIDToken(idToken))
+		if err != nil {
+			return nil, err
+		}
+		login.User, err = txu.CreateUser(username)
+		if err != nil {
+			return nil, fmt.Errorf("CreateUser: %w", err)
+		}
+		if _, err = txu.CreateExternalIdentity(s.oidc.Issuer(), idToken.Subject, login.User.Id); err != nil {
+			return nil, fmt.Errorf("CreateExternalIdentity: %w", err)
+		}
+		if s.profiles != nil {
+			name := idToken.Name
+			if name == "" {
+				name = login.User.Username
+			}
+			if _, err = s.profiles.CreateProfile(login.User.Id, name); err != nil {
+				return nil, fmt.Errorf("CreateProfile: %w", err)
+			}
+		}
+		login.Created = true
+	default:
+		return nil, fmt.Errorf("GetExternalIdentity: %w", err)
+	}
+	if login.Name == "" {
+		login.Name = login.User.Username
+	}
+	challenge, err := s.challenge(txu, login.User.Id)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		if err = txu.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, &ChallengeError{Challenge: *challenge}
+	}
+
+	session, err := txu.CreateSession(login.User.Id, device)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSession: %w", err)
+	}
+	login.Token, err = s.issueTokens(txu, login.User.Id, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+const maxUsernameLength = 20
+
+// usernameFromIDToken derives a username from the claims of the provider.
+func usernameFromIDToken(idToken *oidc.IDToken) string {
+	candidate := idToken.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	username := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return -1
+	}, candidate)
+	if len([]rune(username)) < 2 {
+		username = "user"
+	}
+	if runes := []rune(username); len(runes) > maxUsernameLength {
+		username = string(runes[:maxUsernameLength])
+	}
+	return username
+}
+
+// uniqueUsername appends a number to the username until it is free. Existing
+// accounts are never linked by name, since anyone could claim it at the
+// provider.
+func uniqueUsername(txu store.TxUser, base string) (string, error) {
+	username := base
+	for i := 2; ; i++ {
+		_, err := txu.GetUserByName(username)
+		if errors.Is(err, store.ErrNotFound) {
+			return username, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("GetUserByName: %w", err)
+		}
+		suffix := strconv.Itoa(i)
+		runes := []rune(base)
+		if len(runes)+len(suffix) > maxUsernameLength {
+			runes = runes[:maxUsernameLength-len(suffix)]
+		}
+		username = string(runes) + suffix
+	}
+}
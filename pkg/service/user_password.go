@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/elug3/gochat/pkg/notify"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+// checkPassword enforces the configured strength policy.
+func (s *UserService) checkPassword(password string) error {
+	policy := s.auth.Password
+	var problems []string
+	if len([]rune(password)) < policy.MinLength {
+		problems = append(problems, fmt.Sprintf("be at least %d characters long", policy.MinLength))
+	}
+	var letter, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r), unicode.IsSymbol(r), unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if policy.RequireLetter && !letter {
+		problems = append(problems, "contain a letter")
+	}
+	if policy.RequireDigit && !digit {
+		problems = append(problems, "contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		problems = append(problems, "contain a symbol")
+	}
+	if len(problems) > 0 {
+		return &store.Error{
+			Kind:    store.KindUser,
+			Err:     store.ErrBadRequest,
+			Message: "password must " + strings.Join(problems, ", "),
+		}
+	}
+	return nil
+}
+
+// ChangePassword replaces the password of the user after checking the old
+// one. All other sessions of the user are revoked.
+func (s *UserService) ChangePassword(ctx context.Context, userId, sessionId int, oldPassword, newPassword string) error {
+	if err := s.checkPassword(newPassword); err != nil {
+		return err
+	}
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	user, err := txu.GetUser(userId)
+	if err != nil {
+		return fmt.Errorf("GetUser: %w", err)
+	}
+	if _, err = txu.ValidatePassword(user.Username, oldPassword); err != nil {
+		return err
+	}
+	if err = txu.UpdatePassword(userId, newPassword); err != nil {
+		return fmt.Errorf("UpdatePassword: %w", err)
+	}
+	if _, err = revokeSessionsExcept(txu, userId, sessionId); err != nil {
+		return err
+	}
+	return txu.Commit()
+}
+
+// RequestPasswordReset sends a single-use reset code to the user through the
+// notifier. Unknown usernames are not reported, so the endpoint cannot be
+// used to probe for accounts. For the same reason requests over the rate
+// limit of the user are dropped silently.
+func (s *UserService) RequestPasswordReset(ctx context.Context, username string) error {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	user, err := txu.GetUserByName(username)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("GetUserByName: %w", err)
+	}
+	if err = s.resets.allow(user.Id, time.Now()); err != nil {
+		log.Warn().Err(err).Int("userId", user.Id).Msg("password reset request dropped")
+		return nil
+	}
+	code, err := txu.CreateResetCode(user.Id, s.auth.ResetCodeTTL)
+	if err != nil {
+		return fmt.Errorf("CreateResetCode: %w", err)
+	}
+	if err = txu.Commit(); err != nil {
+		return err
+	}
+	err = s.notifier.Notify(ctx, notify.Message{
+		UserId:   user.Id,
+		Username: user.Username,
+		Subject:  "Password reset",
+		Body:     fmt.Sprintf("Your password reset code is %s. It expires in %s.", code, s.auth.ResetCodeTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("Notify: %w", err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password using a reset code and revokes every
+// session of the user.
+func (s *UserService) ResetPassword(ctx context.Context, code, newPassword string) error {
+	if err := s.checkPassword(newPassword); err != nil {
+		return err
+	}
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	userId, err := txu.UseResetCode(code)
+	if err != nil {
+		return err
+	}
+	if err = txu.UpdatePassword(userId, newPassword); err != nil {
+		return fmt.Errorf("UpdatePassword: %w", err)
+	}
+	if _, err = revokeSessionsExcept(txu, userId, 0); err != nil {
+		return err
+	}
+	return txu.Commit()
+}
@@ -2,22 +2,66 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/jwt"
 	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/notify"
+	"github.com/elug3/gochat/pkg/oidc"
 	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
 )
 
 type UserService struct {
 	store store.UserStore
+	auth  config.AuthConfig
+	// keys signs access tokens, nil unless JWT mode is enabled
+	keys     *jwt.KeySet
+	notifier notify.Notifier
+	// oidc is the identity provider, nil unless single sign-on is enabled
+	oidc *oidc.Provider
+	// touched holds the last time the use of a session was written
+	touched sync.Map
+	// resets limits password reset requests per user
+	resets *rateLimiter
+	// profiles creates the profile of single sign-on users, may be nil
+	profiles ProfileCreator
 }
 
-func NewUserService(userStore store.UserStore) (*UserService, error) {
-	s := UserService{store: userStore}
+func NewUserService(userStore store.UserStore, auth config.AuthConfig, notifier notify.Notifier) (*UserService, error) {
+	keys, err := newKeySet(auth.JWT)
+	if err != nil {
+		return nil, err
+	}
+	s := UserService{
+		store:    userStore,
+		auth:     auth,
+		keys:     keys,
+		notifier: notifier,
+		resets:   newRateLimiter(auth.ResetRate, auth.ResetBurst),
+	}
+	if auth.OIDC.Enabled {
+		s.oidc = oidc.NewProvider(auth.OIDC)
+	}
 	return &s, nil
 }
 
+// JWKS returns the public keys verifying access tokens, or false if signed
+// tokens are disabled.
+func (s *UserService) JWKS() (jwt.JWKS, bool) {
+	if s.keys == nil {
+		return jwt.JWKS{}, false
+	}
+	return s.keys.JWKS(), true
+}
+
 func (s *UserService) GetUser(ctx context.Context, userId int) (*model.User, error) {
 	txu, err := s.store.Begin()
 	if err != nil {
@@ -37,14 +81,74 @@ func (s *UserService) Authenticate(ctx context.Context, tokenString string) (*mo
 		return nil, err
 	}
 	defer txu.Rollback()
+	if strings.HasPrefix(tokenString, model.APIKeyPrefix) {
+		token, err := authenticateAPIKey(txu, tokenString)
+		if err != nil {
+			return nil, err
+		}
+		if err = txu.Commit(); err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+	if s.keys != nil && jwt.IsToken(tokenString) {
+		token, err := s.verifyJWT(txu, tokenString)
+		if err != nil {
+			return nil, err
+		}
+		if err = txu.Commit(); err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
 	token, err := txu.GetToken(tokenString)
 	if err != nil {
 		return nil, fmt.Errorf("GetToken: %w", err)
 	}
+	if token.ExpiresAt != nil {
+		remaining := time.Until(*token.ExpiresAt)
+		if remaining <= 0 {
+			return nil, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrExpired,
+				Message: "token expired",
+			}
+		}
+		// renew at most once a minute
+		if s.auth.SlidingExpiration && remaining < s.auth.AccessTokenTTL-time.Minute {
+			if err = txu.ExtendToken(token.Id, s.auth.AccessTokenTTL); err != nil {
+				return nil, fmt.Errorf("ExtendToken: %w", err)
+			}
+			expiresAt := time.Now().Add(s.auth.AccessTokenTTL)
+			token.ExpiresAt = &expiresAt
+		}
+	}
+	if err = s.touchSession(txu, token.SessionId); err != nil {
+		return nil, err
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
 	return token, nil
 }
 
+// touchSession records use of the session at most once per touchInterval.
+func (s *UserService) touchSession(txu store.TxUser, sessionId int) error {
+	now := time.Now()
+	if last, ok := s.touched.Load(sessionId); ok && now.Sub(last.(time.Time)) < touchInterval {
+		return nil
+	}
+	if err := txu.TouchSession(sessionId); err != nil {
+		return fmt.Errorf("TouchSession: %w", err)
+	}
+	s.touched.Store(sessionId, now)
+	return nil
+}
+
 func (s *UserService) Register(ctx context.Context, username, password string) (*model.User, error) {
+	if err := s.checkPassword(password); err != nil {
+		return nil, err
+	}
 	txu, err := s.store.Begin()
 	if err != nil {
 		return nil, err
@@ -64,18 +168,50 @@ func (s *UserService) Register(ctx context.Context, username, password string) (
 	return user, nil
 }
 
-func (s *UserService) Login(ctx context.Context, username, password string) (*model.Token, error) {
+// Login validates the credentials and starts a new session for the device.
+// Accounts with 2FA get a ChallengeError to complete with CompleteLogin.
+func (s *UserService) Login(ctx context.Context, username, password string, device model.Device) (*model.Token, error) {
 	txu, err := s.store.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer txu.Rollback()
 
+	now := time.Now()
+	keys := throttleKeys(username, device)
+	if err = s.checkThrottle(txu, keys, now); err != nil {
+		if errors.Is(err, store.ErrTooManyRequests) {
+			return nil, s.failLogin(txu, username, model.LoginFailedLocked, device, nil, now, err)
+		}
+		return nil, err
+	}
 	userId, err := txu.ValidatePassword(username, password)
 	if err != nil {
+		if errors.Is(err, store.ErrUnauthorized) {
+			return nil, s.failLogin(txu, username, model.LoginFailedPassword, device, keys, now, err)
+		}
 		return nil, err
 	}
-	token, err := txu.CreateToken(userId, time.Hour*24*7)
+	// only the username is cleared, so one valid account does not reset the
+	// counter of the address
+	if err = txu.DeleteLoginThrottle(keys[0]); err != nil {
+		return nil, fmt.Errorf("DeleteLoginThrottle: %w", err)
+	}
+	challenge, err := s.challenge(txu, userId)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		if err = txu.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, &ChallengeError{Challenge: *challenge}
+	}
+	session, err := txu.CreateSession(userId, device)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSession: %w", err)
+	}
+	token, err := s.issueTokens(txu, userId, session.Id)
 	if err != nil {
 		return nil, err
 	}
@@ -84,3 +220,304 @@ func (s *UserService) Login(ctx context.Context, username, password string) (*mo
 	}
 	return token, nil
 }
+
+// issueTokens creates an access token and a refresh token for the session.
+func (s *UserService) issueTokens(txu store.TxUser, userId, sessionId int) (*model.Token, error) {
+	var token *model.Token
+	var err error
+	if s.keys != nil {
+		token, err = s.signToken(userId, sessionId)
+		if err != nil {
+			return nil, fmt.Errorf("signToken: %w", err)
+		}
+	} else {
+		token, err = txu.CreateToken(userId, sessionId, s.auth.AccessTokenTTL)
+		if err != nil {
+			return nil, fmt.Errorf("CreateToken: %w", err)
+		}
+	}
+	refresh, err := txu.CreateRefreshToken(sessionId, s.auth.RefreshTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("CreateRefreshToken: %w", err)
+	}
+	token.RefreshToken = refresh.Token
+	return token, nil
+}
+
+// signToken issues a signed access token. Signed tokens are not stored, so
+// other services can verify them with the JWKS alone.
+func (s *UserService) signToken(userId, sessionId int) (*model.Token, error) {
+	now := time.Now()
+	claims := jwt.Claims{
+		Id:        rand.Text(),
+		Issuer:    s.auth.JWT.Issuer,
+		Subject:   strconv.Itoa(userId),
+		UserId:    userId,
+		SessionId: sessionId,
+		IssuedAt:  now.Unix(),
+	}
+	token := model.Token{
+		Id:        claims.Id,
+		UserId:    userId,
+		SessionId: sessionId,
+		IssuedAt:  now,
+	}
+	if s.auth.AccessTokenTTL != 0 {
+		expiresAt := now.Add(s.auth.AccessTokenTTL)
+		claims.ExpiresAt = expiresAt.Unix()
+		token.ExpiresAt = &expiresAt
+	}
+	signed, err := s.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	token.AccessToken = signed
+	return &token, nil
+}
+
+// verifyJWT checks a signed access token. Unlike other services, gochat also
+// rejects tokens of revoked sessions so that logout takes effect immediately.
+func (s *UserService) verifyJWT(txu store.TxUser, tokenString string) (*model.Token, error) {
+	claims, err := s.keys.Verify(tokenString)
+	if err != nil {
+		if errors.Is(err, jwt.ErrExpired) {
+			return nil, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrExpired,
+				Message: "token expired",
+			}
+		}
+		return nil, &store.Error{
+			Kind:    store.KindToken,
+			Err:     store.ErrUnauthorized,
+			Message: "invalid token",
+		}
+	}
+	session, err := txu.GetSession(claims.SessionId)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errSessionRevoked
+		}
+		return nil, err
+	}
+	if session.RevokedAt != nil || session.UserId != claims.UserId {
+		return nil, errSessionRevoked
+	}
+	if err = s.touchSession(txu, session.Id); err != nil {
+		return nil, err
+	}
+
+	token := model.Token{
+		Id:          claims.Id,
+		UserId:      claims.UserId,
+		SessionId:   claims.SessionId,
+		AccessToken: tokenString,
+		IssuedAt:    time.Unix(claims.IssuedAt, 0),
+	}
+	if claims.ExpiresAt != 0 {
+		expiresAt := time.Unix(claims.ExpiresAt, 0)
+		token.ExpiresAt = &expiresAt
+	}
+	return &token, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair of the same session.
+// Presenting a refresh token twice revokes the whole session, since one of
+// the two parties must have stolen it.
+func (s *UserService) Refresh(ctx context.Context, refreshToken string) (*model.Token, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	refresh, err := txu.GetRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	session, err := txu.GetSession(refresh.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil {
+		return nil, errSessionRevoked
+	}
+	if refresh.UsedAt != nil {
+		if err = txu.RevokeSession(session.Id); err != nil {
+			return nil, fmt.Errorf("RevokeSession: %w", err)
+		}
+		if err = txu.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, &store.Error{
+			Kind:    store.KindToken,
+			Err:     store.ErrUnauthorized,
+			Message: "refresh token reused, session revoked",
+		}
+	}
+	if refresh.ExpiresAt != nil && refresh.ExpiresAt.Before(time.Now()) {
+		return nil, &store.Error{
+			Kind:    store.KindToken,
+			Err:     store.ErrUnauthorized,
+			Message: "refresh token expired",
+		}
+	}
+
+	if err = txu.UseRefreshToken(refresh.Id); err != nil {
+		return nil, err
+	}
+	token, err := s.issueTokens(txu, session.UserId, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+var errSessionRevoked = &store.Error{
+	Kind:    store.KindSession,
+	Err:     store.ErrUnauthorized,
+	Message: "session revoked",
+}
+
+// Logout revokes the session, invalidating all of its tokens.
+func (s *UserService) Logout(ctx context.Context, sessionId int) error {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	if err = txu.RevokeSession(sessionId); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return errSessionRevoked
+		}
+		return err
+	}
+	return txu.Commit()
+}
+
+// ListSessions returns the active sessions of the user, marking the current one.
+func (s *UserService) ListSessions(ctx context.Context, userId, currentId int) ([]model.Session, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	sessions, err := txu.ListSessions(userId)
+	if err != nil {
+		return nil, fmt.Errorf("ListSessions: %w", err)
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == currentId
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes a session owned by the user.
+func (s *UserService) RevokeSession(ctx context.Context, userId, sessionId int) error {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	session, err := txu.GetSession(sessionId)
+	if err != nil {
+		return err
+	}
+	if session.UserId != userId || session.RevokedAt != nil {
+		return &store.Error{
+			Kind:    store.KindSession,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("session '%d' not found", sessionId),
+		}
+	}
+	if err = txu.RevokeSession(sessionId); err != nil {
+		return err
+	}
+	return txu.Commit()
+}
+
+// RevokeOtherSessions revokes every active session of the user except the
+// current one and returns how many were revoked.
+func (s *UserService) RevokeOtherSessions(ctx context.Context, userId, currentId int) (int, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer txu.Rollback()
+
+	n, err := revokeSessionsExcept(txu, userId, currentId)
+	if err != nil {
+		return 0, err
+	}
+	if err = txu.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func revokeSessionsExcept(txu store.TxUser, userId, keepId int) (int, error) {
+	sessions, err := txu.ListSessions(userId)
+	if err != nil {
+		return 0, fmt.Errorf("ListSessions: %w", err)
+	}
+	n := 0
+	for _, session := range sessions {
+		if session.Id == keepId {
+			continue
+		}
+		if err = txu.RevokeSession(session.Id); err != nil {
+			return 0, fmt.Errorf("RevokeSession: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// SweepExpiredTokens purges expired access tokens and returns how many were removed.
+func (s *UserService) SweepExpiredTokens(ctx context.Context) (int64, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer txu.Rollback()
+
+	n, err := txu.DeleteExpiredTokens()
+	if err != nil {
+		return 0, fmt.Errorf("DeleteExpiredTokens: %w", err)
+	}
+	if err = txu.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RunSweeper purges expired access tokens every interval until ctx is done.
+func (s *UserService) RunSweeper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.SweepExpiredTokens(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("sweep expired tokens")
+				continue
+			}
+			if n > 0 {
+				log.Info().Int64("tokens", n).Msg("swept expired tokens")
+			}
+		}
+	}
+}
@@ -1,12 +1,25 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
+	"net/http"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/jwt"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/notify"
+	"github.com/elug3/gochat/pkg/oidc/oidctest"
 	"github.com/elug3/gochat/pkg/store"
 	"github.com/elug3/gochat/pkg/store/user/sqlite"
+	"github.com/elug3/gochat/pkg/totp"
 )
 
 func newTestUserService() (*UserService, error) {
@@ -16,7 +29,7 @@ func newTestUserService() (*UserService, error) {
 	if err != nil {
 		return nil, err
 	}
-	s, err := NewUserService(store)
+	s, err := NewUserService(store, config.AuthConfig{}, notify.LogNotifier{})
 	if err != nil {
 		return nil, err
 	}
@@ -57,6 +70,9 @@ func TestUserService_Auth(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if _, err = s.Register(t.Context(), "test", "password"); err != nil {
+		t.Fatal(err)
+	}
 
 	testCases := map[string]struct {
 		usernameIn   string
@@ -69,7 +85,7 @@ func TestUserService_Auth(t *testing.T) {
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			result, err := s.Login(t.Context(), tc.usernameIn, tc.passwordIn)
+			result, err := s.Login(t.Context(), tc.usernameIn, tc.passwordIn, model.Device{})
 			if err != nil {
 				if errors.Is(err, tc.wantErr) {
 					t.SkipNow()
@@ -82,3 +98,684 @@ func TestUserService_Auth(t *testing.T) {
 
 	}
 }
+
+func TestUserService_Refresh(t *testing.T) {
+	s, err := newTestUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Register(t.Context(), "test", "password"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.Login(t.Context(), "test", "password", model.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Login(t.Context(), "test", "password", model.Device{})
+	if err != nil {
+		t.Fatalf("second session: %v", err)
+	}
+	if first.SessionId == second.SessionId {
+		t.Fatalf("expected separate sessions, but got %d twice", first.SessionId)
+	}
+
+	rotated, err := s.Refresh(t.Context(), first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.SessionId != first.SessionId || rotated.RefreshToken == first.RefreshToken {
+		t.Fatalf("expected rotated token of session %d, but got %v", first.SessionId, rotated)
+	}
+	if _, err = s.Authenticate(t.Context(), rotated.AccessToken); err != nil {
+		t.Fatalf("rotated token: %v", err)
+	}
+
+	// reusing the old refresh token revokes the session
+	if _, err = s.Refresh(t.Context(), first.RefreshToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Fatalf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+	if _, err = s.Authenticate(t.Context(), rotated.AccessToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected revoked token, but got %v", err)
+	}
+	if _, err = s.Refresh(t.Context(), rotated.RefreshToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected revoked refresh token, but got %v", err)
+	}
+
+	if err = s.Logout(t.Context(), second.SessionId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Authenticate(t.Context(), second.AccessToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected logged out token, but got %v", err)
+	}
+}
+
+func TestUserService_Sessions(t *testing.T) {
+	s, err := newTestUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.Register(t.Context(), "test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := []model.Device{
+		{UserAgent: "laptop", IP: "10.0.0.1"},
+		{UserAgent: "phone", IP: "10.0.0.2"},
+		{UserAgent: "tablet", IP: "10.0.0.3"},
+	}
+	tokens := make([]*model.Token, 0, len(devices))
+	for _, device := range devices {
+		token, err := s.Login(t.Context(), "test", "password", device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	current := tokens[0].SessionId
+
+	sessions, err := s.ListSessions(t.Context(), user.Id, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != len(devices) {
+		t.Fatalf("expected %d sessions, but got %v", len(devices), sessions)
+	}
+	for _, session := range sessions {
+		if session.Current != (session.Id == current) {
+			t.Errorf("unexpected current flag: %v", session)
+		}
+		if session.UserAgent == "" || session.IP == "" {
+			t.Errorf("expected device metadata, but got %v", session)
+		}
+	}
+
+	if err = s.RevokeSession(t.Context(), user.Id+1, tokens[1].SessionId); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q for foreign session, but got %q", store.ErrNotFound, err)
+	}
+	if err = s.RevokeSession(t.Context(), user.Id, tokens[1].SessionId); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.RevokeOtherSessions(t.Context(), user.Id, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 revoked session, but got %d", n)
+	}
+	if _, err = s.Authenticate(t.Context(), tokens[0].AccessToken); err != nil {
+		t.Errorf("current session revoked: %v", err)
+	}
+	if _, err = s.Authenticate(t.Context(), tokens[2].AccessToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected revoked token, but got %v", err)
+	}
+}
+
+func TestUserService_TokenExpiry(t *testing.T) {
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]struct {
+		auth    config.AuthConfig
+		wait    time.Duration
+		wantErr error
+	}{
+		"no expiry":   {auth: config.AuthConfig{}},
+		"valid token": {auth: config.AuthConfig{AccessTokenTTL: time.Hour}},
+		"expired":     {auth: config.AuthConfig{AccessTokenTTL: time.Millisecond}, wait: 10 * time.Millisecond, wantErr: store.ErrExpired},
+		"sliding":     {auth: config.AuthConfig{AccessTokenTTL: time.Hour, SlidingExpiration: true}},
+	}
+	s, err := NewUserService(userStore, config.AuthConfig{}, notify.LogNotifier{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Register(t.Context(), "test", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewUserService(userStore, tc.auth, notify.LogNotifier{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := s.Login(t.Context(), "test", "password", model.Device{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(tc.wait)
+			_, err = s.Authenticate(t.Context(), token.AccessToken)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error %q, but got %q", tc.wantErr, err)
+			}
+		})
+	}
+
+	n, err := s.SweepExpiredTokens(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 swept token, but got %d", n)
+	}
+}
+
+func TestUserService_JWT(t *testing.T) {
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := func(b byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, ed25519.SeedSize))
+	}
+	auth := config.AuthConfig{
+		AccessTokenTTL: time.Hour,
+		JWT: config.JWTConfig{
+			Enabled:    true,
+			SigningKey: "k1",
+			Keys:       []config.JWTKeyConfig{{Id: "k1", Alg: "EdDSA", PrivateKey: seed(1)}},
+		},
+	}
+	s, err := NewUserService(userStore, auth, notify.LogNotifier{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Register(t.Context(), "test", "password"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.Login(t.Context(), "test", "password", model.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jwt.IsToken(token.AccessToken) {
+		t.Fatalf("expected a signed token, but got %q", token.AccessToken)
+	}
+	authenticated, err := s.Authenticate(t.Context(), token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authenticated.UserId != token.UserId || authenticated.SessionId != token.SessionId {
+		t.Errorf("expected token %+v, but got %+v", token, authenticated)
+	}
+
+	// rotate: k2 signs, k1 only verifies
+	auth.JWT.SigningKey = "k2"
+	auth.JWT.Keys = append(auth.JWT.Keys, config.JWTKeyConfig{Id: "k2", Alg: "EdDSA", PrivateKey: seed(2)})
+	rotated, err := NewUserService(userStore, auth, notify.LogNotifier{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwks, _ := rotated.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("expected 2 published keys, but got %d", len(jwks.Keys))
+	}
+	if _, err = rotated.Authenticate(t.Context(), token.AccessToken); err != nil {
+		t.Errorf("token signed before rotation: %v", err)
+	}
+
+	if err = rotated.Logout(t.Context(), token.SessionId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rotated.Authenticate(t.Context(), token.AccessToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+}
+
+type recordNotifier struct {
+	msgs []notify.Message
+}
+
+func (n *recordNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	n.msgs = append(n.msgs, msg)
+	return nil
+}
+
+func TestUserService_Password(t *testing.T) {
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := &recordNotifier{}
+	auth := config.AuthConfig{
+		Password:     config.PasswordConfig{MinLength: 8, RequireDigit: true},
+		ResetCodeTTL: time.Minute,
+		ResetRate:    1,
+		ResetBurst:   1,
+	}
+	s, err := NewUserService(userStore, auth, notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"short1", "longenough"} {
+		if _, err = s.Register(t.Context(), "weak", password); !errors.Is(err, store.ErrBadRequest) {
+			t.Errorf("password %q: expected error %q, but got %q", password, store.ErrBadRequest, err)
+		}
+	}
+	user, err := s.Register(t.Context(), "test", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := s.Login(t.Context(), "test", "password1", model.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Login(t.Context(), "test", "password1", model.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.ChangePassword(t.Context(), user.Id, current.SessionId, "wrong", "password2"); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+	if err = s.ChangePassword(t.Context(), user.Id, current.SessionId, "password1", "password2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Authenticate(t.Context(), current.AccessToken); err != nil {
+		t.Errorf("current session: %v", err)
+	}
+	if _, err = s.Authenticate(t.Context(), other.AccessToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("other session: expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+
+	// unknown users are not reported and get no code
+	if err = s.RequestPasswordReset(t.Context(), "unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RequestPasswordReset(t.Context(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.msgs) != 1 || notifier.msgs[0].UserId != user.Id {
+		t.Fatalf("expected 1 message to user %d, but got %+v", user.Id, notifier.msgs)
+	}
+	code := regexp.MustCompile(`[A-Z2-7]{26}`).FindString(notifier.msgs[0].Body)
+
+	// requests over the rate limit are dropped silently
+	if err = s.RequestPasswordReset(t.Context(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.msgs) != 1 {
+		t.Fatalf("expected the request to be dropped, but got %+v", notifier.msgs)
+	}
+
+	if err = s.ResetPassword(t.Context(), code, "weak"); !errors.Is(err, store.ErrBadRequest) {
+		t.Errorf("expected error %q, but got %q", store.ErrBadRequest, err)
+	}
+	if err = s.ResetPassword(t.Context(), code, "password3"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ResetPassword(t.Context(), code, "password4"); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("reused code: expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+	if _, err = s.Authenticate(t.Context(), current.AccessToken); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("after reset: expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+	if _, err = s.Login(t.Context(), "test", "password3", model.Device{}); err != nil {
+		t.Errorf("login with new password: %v", err)
+	}
+}
+
+func TestUserService_Lockout(t *testing.T) {
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := config.AuthConfig{
+		Lockout: config.LockoutConfig{MaxAttempts: 3, BaseDelay: time.Hour, Duration: time.Hour, Window: time.Hour},
+	}
+	s, err := NewUserService(userStore, auth, notify.LogNotifier{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.Register(t.Context(), "test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := model.Device{IP: "192.0.2.1"}
+
+	if _, err = s.Login(t.Context(), "test", "wrong", device); !errors.Is(err, store.ErrUnauthorized) {
+		t.Fatalf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+	// the right password is refused while backing off
+	_, err = s.Login(t.Context(), "test", "password", device)
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("expected throttled error, but got %q", err)
+	}
+	// other addresses are throttled by the username too
+	if _, err = s.Login(t.Context(), "test", "password", model.Device{IP: "192.0.2.2"}); !errors.Is(err, store.ErrTooManyRequests) {
+		t.Errorf("expected error %q, but got %q", store.ErrTooManyRequests, err)
+	}
+	// and other usernames by the address
+	if _, err = s.Login(t.Context(), "other", "password", device); !errors.Is(err, store.ErrTooManyRequests) {
+		t.Errorf("expected error %q, but got %q", store.ErrTooManyRequests, err)
+	}
+
+	audits, err := s.ListFailedLogins(t.Context(), user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 3 || audits[2].Reason != model.LoginFailedPassword || audits[0].Reason != model.LoginFailedLocked {
+		t.Errorf("unexpected audits %+v", audits)
+	}
+
+	testCases := map[string]struct {
+		failures int
+		want     time.Duration
+	}{
+		"first":   {failures: 1, want: time.Second},
+		"doubles": {failures: 2, want: 2 * time.Second},
+		"capped":  {failures: 8, want: 10 * time.Second},
+		"locked":  {failures: 10, want: time.Hour},
+	}
+	s.auth.Lockout = config.LockoutConfig{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second, Duration: time.Hour}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := s.backoff(tc.failures); got != tc.want {
+				t.Errorf("expected %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestUserService_TOTP(t *testing.T) {
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := config.AuthConfig{
+		TwoFactor: config.TwoFactorConfig{Issuer: "gochat", ChallengeTTL: time.Minute},
+	}
+	s, err := NewUserService(userStore, auth, notify.LogNotifier{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.Register(t.Context(), "test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := s.EnrollTOTP(t.Context(), user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fix the counter so that the test does not depend on crossing a step
+	counter := totp.Counter(time.Now())
+	code := func(step int64) string {
+		c, err := totp.Code(enrollment.Secret, counter+step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	login := func() string {
+		_, err := s.Login(t.Context(), "test", "password", model.Device{})
+		var challenge *ChallengeError
+		if !errors.As(err, &challenge) {
+			t.Fatalf("expected challenge, but got %v", err)
+		}
+		return challenge.Challenge.Token
+	}
+
+	if _, err = s.ActivateTOTP(t.Context(), user.Id, "000000"); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+	recoveryCodes, err := s.ActivateTOTP(t.Context(), user.Id, code(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, but got %d", recoveryCodeCount, len(recoveryCodes))
+	}
+
+	testCases := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{name: "replayed code", code: code(0), wantErr: store.ErrUnauthorized},
+		{name: "next code", code: code(1)},
+		{name: "recovery code", code: strings.ToUpper(recoveryCodes[0])},
+		{name: "used recovery code", code: recoveryCodes[0], wantErr: store.ErrUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := s.CompleteLogin(t.Context(), login(), tc.code, model.Device{})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %q, but got %q", tc.wantErr, err)
+			}
+			if err == nil && token.UserId != user.Id {
+				t.Errorf("expected token of user %d, but got %+v", user.Id, token)
+			}
+		})
+	}
+
+	// a challenge is dropped after too many wrong codes
+	challenge := login()
+	for range maxChallengeAttempts {
+		s.CompleteLogin(t.Context(), challenge, "000000", model.Device{})
+	}
+	if _, err = s.CompleteLogin(t.Context(), challenge, recoveryCodes[1], model.Device{}); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+
+	if err = s.DisableTOTP(t.Context(), user.Id, recoveryCodes[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Login(t.Context(), "test", "password", model.Device{}); err != nil {
+		t.Errorf("login without 2FA: %v", err)
+	}
+}
+
+type recordProfiles struct {
+	names map[int]string
+	err   error
+}
+
+func (p *recordProfiles) CreateProfile(userId int, name string) (*model.Profile, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.names[userId] = name
+	return &model.Profile{Id: userId, Name: name}, nil
+}
+
+func TestUserService_OIDC(t *testing.T) {
+	provider, err := oidctest.NewProvider("gochat", "secret", oidctest.Identity{
+		Subject:           "alice-1",
+		Name:              "Alice",
+		PreferredUsername: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	userStore, err := sqlite.NewUserStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := config.AuthConfig{
+		OIDC: config.OIDCConfig{
+			Enabled:      true,
+			Issuer:       provider.Issuer(),
+			ClientId:     "gochat",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost/api/v1/auth/oidc/callback",
+			StateTTL:     time.Minute,
+		},
+	}
+	s, err := NewUserService(userStore, auth, notify.LogNotifier{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles := &recordProfiles{names: make(map[int]string), err: errors.New("unavailable")}
+	s.SetProfileCreator(profiles)
+	// a local user already owns the preferred username
+	if _, err = s.Register(t.Context(), "test", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	// login follows the provider redirect back to the callback
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	callback := func() (state, code string) {
+		authURL, err := s.BeginOIDC(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return location.Query().Get("state"), location.Query().Get("code")
+	}
+
+	// the user is not created without a profile
+	state, code := callback()
+	if _, err = s.CompleteOIDC(t.Context(), state, code, model.Device{}); err == nil {
+		t.Fatal("expected the profile error")
+	}
+	profiles.err = nil
+
+	state, code = callback()
+	first, err := s.CompleteOIDC(t.Context(), state, code, model.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Created || first.User.Username != "test2" || first.Name != "Alice" {
+		t.Errorf("unexpected first login %+v", first)
+	}
+	if name := profiles.names[first.User.Id]; name != "Alice" {
+		t.Errorf("expected profile %q, but got %q", "Alice", name)
+	}
+	if _, err = s.Authenticate(t.Context(), first.Token.AccessToken); err != nil {
+		t.Errorf("Authenticate: %v", err)
+	}
+	if _, err = s.CompleteOIDC(t.Context(), state, code, model.Device{}); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("reused state: expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+
+	state, code = callback()
+	second, err := s.CompleteOIDC(t.Context(), state, code, model.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Created || second.User.Id != first.User.Id {
+		t.Errorf("expected login of user %d, but got %+v", first.User.Id, second)
+	}
+
+	// users with 2FA get the challenge of a password login
+	enrollment, err := s.EnrollTOTP(t.Context(), first.User.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totpCode, err := totp.Code(enrollment.Secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ActivateTOTP(t.Context(), first.User.Id, totpCode); err != nil {
+		t.Fatal(err)
+	}
+	state, code = callback()
+	_, err = s.CompleteOIDC(t.Context(), state, code, model.Device{})
+	var challenge *ChallengeError
+	if !errors.As(err, &challenge) {
+		t.Errorf("expected challenge, but got %v", err)
+	}
+
+	// unknown codes are refused by the provider
+	state, code = callback()
+	if _, err = s.CompleteOIDC(t.Context(), state, code+"x", model.Device{}); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("wrong code: expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+}
+
+func TestUserService_Bots(t *testing.T) {
+	s, err := newTestUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := s.Register(t.Context(), "owner", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Register(t.Context(), "other", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot, err := s.CreateBot(t.Context(), owner.Id, "helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bot.Bot || bot.OwnerId != owner.Id {
+		t.Fatalf("unexpected bot %+v", bot)
+	}
+	// bots have no password
+	if _, err = s.Login(t.Context(), "helper", "", model.Device{}); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+
+	testCases := map[string]struct {
+		ownerId int
+		scopes  []string
+		err     error
+	}{
+		"no scopes":     {ownerId: owner.Id, err: store.ErrBadRequest},
+		"unknown scope": {ownerId: owner.Id, scopes: []string{"admin"}, err: store.ErrBadRequest},
+		"not the owner": {ownerId: other.Id, scopes: []string{model.ScopeMessagesWrite}, err: store.ErrNotFound},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.CreateAPIKey(t.Context(), tc.ownerId, bot.Id, "", tc.scopes, nil); !errors.Is(err, tc.err) {
+				t.Errorf("expected error %q, but got %q", tc.err, err)
+			}
+		})
+	}
+
+	key, err := s.CreateAPIKey(t.Context(), owner.Id, bot.Id, "deploy", []string{model.ScopeMessagesWrite}, []int{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.Authenticate(t.Context(), key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserId != bot.Id || token.APIKey == nil {
+		t.Fatalf("unexpected token %+v", token)
+	}
+	if !token.APIKey.Allows(model.ScopeMessagesWrite, 7) {
+		t.Error("expected the key to allow writing to group 7")
+	}
+	if token.APIKey.Allows(model.ScopeMessagesWrite, 8) || token.APIKey.Allows(model.ScopeMessagesRead, 7) {
+		t.Error("expected the key to be limited to its scopes and groups")
+	}
+
+	keys, err := s.ListAPIKeys(t.Context(), owner.Id, bot.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Key != "" {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if err = s.RevokeAPIKey(t.Context(), owner.Id, bot.Id, key.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Authenticate(t.Context(), key.Key); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+
+	if err = s.DeleteBot(t.Context(), other.Id, bot.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected error %q, but got %q", store.ErrNotFound, err)
+	}
+	if err = s.DeleteBot(t.Context(), owner.Id, bot.Id); err != nil {
+		t.Fatal(err)
+	}
+	if bots, err := s.ListBots(t.Context(), owner.Id); err != nil || len(bots) != 0 {
+		t.Errorf("expected no bots, but got %+v, %v", bots, err)
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/totp"
+)
+
+const (
+	recoveryCodeCount       = 10
+	maxChallengeAttempts    = 5
+	twoFactorMethodTOTP     = "totp"
+	twoFactorMethodRecovery = "recovery_code"
+)
+
+// ChallengeError is returned by Login when the password was right but the
+// account requires a second factor. The challenge is exchanged for a token
+// with CompleteLogin.
+type ChallengeError struct {
+	Challenge model.LoginChallenge
+}
+
+func (err *ChallengeError) Error() string {
+	return "two-factor authentication required"
+}
+
+func (err *ChallengeError) Unwrap() error {
+	return store.ErrUnauthorized
+}
+
+var errInvalidCode = &store.Error{
+	Kind:    store.KindTOTP,
+	Err:     store.ErrUnauthorized,
+	Message: "invalid code",
+}
+
+// challenge starts the second step of a login if the user enabled 2FA.
+func (s *UserService) challenge(txu store.TxUser, userId int) (*model.LoginChallenge, error) {
+	otp, err := txu.GetTOTP(userId)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("GetTOTP: %w", err)
+	}
+	if otp.EnabledAt == nil {
+		return nil, nil
+	}
+	challenge, err := txu.CreateChallenge(userId, s.auth.TwoFactor.ChallengeTTL)
+	if err != nil {
+		return nil, fmt.Errorf("CreateChallenge: %w", err)
+	}
+	challenge.Methods = []string{twoFactorMethodTOTP, twoFactorMethodRecovery}
+	return challenge, nil
+}
+
+// CompleteLogin exchanges a login challenge and a TOTP or recovery code for
+// a new session.
+func (s *UserService) CompleteLogin(ctx context.Context, challenge, code string, device model.Device) (*model.Token, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	userId, err := txu.GetChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+	user, err := txu.GetUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetUser: %w", err)
+	}
+	now := time.Now()
+	keys := throttleKeys(user.Username, device)
+	if err = s.checkThrottle(txu, keys, now); err != nil {
+		return nil, err
+	}
+	if err = s.verifySecondFactor(txu, userId, code, now); err != nil {
+		if !errors.Is(err, store.ErrUnauthorized) {
+			return nil, err
+		}
+		if ferr := txu.FailChallenge(challenge, maxChallengeAttempts); ferr != nil {
+			return nil, fmt.Errorf("FailChallenge: %w", ferr)
+		}
+		return nil, s.failLogin(txu, user.Username, model.LoginFailedCode, device, keys, now, err)
+	}
+	if err = txu.DeleteChallenge(challenge); err != nil {
+		return nil, fmt.Errorf("DeleteChallenge: %w", err)
+	}
+	session, err := txu.CreateSession(userId, device)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSession: %w", err)
+	}
+	token, err := s.issueTokens(txu, userId, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code.
+func (s *UserService) verifySecondFactor(txu store.TxUser, userId int, code string, now time.Time) error {
+	otp, err := txu.GetTOTP(userId)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return errInvalidCode
+		}
+		return fmt.Errorf("GetTOTP: %w", err)
+	}
+	if otp.EnabledAt == nil {
+		return errInvalidCode
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return useTOTPCode(txu, otp, code, now)
+	}
+	return txu.UseRecoveryCode(userId, normalizeRecoveryCode(code))
+}
+
+func useTOTPCode(txu store.TxUser, otp *model.TOTP, code string, now time.Time) error {
+	counter, ok := totp.Validate(otp.Secret, code, now, 1)
+	if !ok {
+		return errInvalidCode
+	}
+	return txu.UseTOTPCounter(otp.UserId, counter)
+}
+
+// EnrollTOTP creates a new authenticator secret for the user. It only takes
+// effect once activated with a code.
+func (s *UserService) EnrollTOTP(ctx context.Context, userId int) (*model.TOTPEnrollment, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	if otp, err := txu.GetTOTP(userId); err == nil && otp.EnabledAt != nil {
+		return nil, &store.Error{
+			Kind:    store.KindTOTP,
+			Err:     store.ErrExists,
+			Message: "two-factor authentication is already enabled",
+		}
+	}
+	user, err := txu.GetUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetUser: %w", err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.SetTOTP(userId, secret); err != nil {
+		return nil, fmt.Errorf("SetTOTP: %w", err)
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return &model.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(s.auth.TwoFactor.Issuer, user.Username, secret),
+	}, nil
+}
+
+// ActivateTOTP enables 2FA after the user proved the authenticator works and
+// returns the recovery codes. They are shown only once.
+func (s *UserService) ActivateTOTP(ctx context.Context, userId int, code string) ([]string, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	otp, err := txu.GetTOTP(userId)
+	if err != nil {
+		return nil, err
+	}
+	if otp.EnabledAt != nil {
+		return nil, &store.Error{
+			Kind:    store.KindTOTP,
+			Err:     store.ErrExists,
+			Message: "two-factor authentication is already enabled",
+		}
+	}
+	if err = useTOTPCode(txu, otp, code, time.Now()); err != nil {
+		return nil, err
+	}
+	if err = txu.EnableTOTP(userId); err != nil {
+		return nil, fmt.Errorf("EnableTOTP: %w", err)
+	}
+	codes, err := s.newRecoveryCodes(txu, userId)
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after
+// checking a second factor.
+func (s *UserService) RegenerateRecoveryCodes(ctx context.Context, userId int, code string) ([]string, error) {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txu.Rollback()
+
+	if err = s.verifySecondFactor(txu, userId, code, time.Now()); err != nil {
+		return nil, err
+	}
+	codes, err := s.newRecoveryCodes(txu, userId)
+	if err != nil {
+		return nil, err
+	}
+	if err = txu.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off after checking a second factor.
+func (s *UserService) DisableTOTP(ctx context.Context, userId int, code string) error {
+	txu, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txu.Rollback()
+
+	if err = s.verifySecondFactor(txu, userId, code, time.Now()); err != nil {
+		return err
+	}
+	if err = txu.DeleteTOTP(userId); err != nil {
+		return fmt.Errorf("DeleteTOTP: %w", err)
+	}
+	return txu.Commit()
+}
+
+func (s *UserService) newRecoveryCodes(txu store.TxUser, userId int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashed := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := strings.ToLower(rand.Text()[:10])
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashed[i] = raw
+	}
+	if err := txu.SetRecoveryCodes(userId, hashed); err != nil {
+		return nil, fmt.Errorf("SetRecoveryCodes: %w", err)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes with or without separators
+// and in any case.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+const (
+	maxWebhookUsername    = 32
+	maxWebhookAttachments = 10
+)
+
+// IncomingParams is the body posted to an incoming webhook.
+type IncomingParams struct {
+	Text        string             `json:"text"`
+	Username    string             `json:"username"`
+	Attachments []model.Attachment `json:"attachments"`
+}
+
+// CreateIncoming creates an incoming webhook for the group. The returned
+// webhook carries its token.
+func (s *WebhookService) CreateIncoming(userId, groupId int, name string) (*model.IncomingWebhook, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxWebhookUsername {
+		return nil, &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("name must be 1 to %d characters long", maxWebhookUsername),
+		}
+	}
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkIncoming(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	webhook, err := txc.CreateIncomingWebhook(groupId, userId, name)
+	if err != nil {
+		return nil, fmt.Errorf("CreateIncomingWebhook: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) ListIncoming(userId, groupId int) ([]model.IncomingWebhook, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkIncoming(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	return txc.GetIncomingWebhooks(groupId)
+}
+
+// RotateIncoming issues a new token for the webhook and invalidates the old one.
+func (s *WebhookService) RotateIncoming(userId, groupId, webhookId int) (*model.IncomingWebhook, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	webhook, err := s.getIncoming(txc, groupId, userId, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.Token, err = txc.RotateIncomingWebhook(webhookId); err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) RevokeIncoming(userId, groupId, webhookId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if _, err = s.getIncoming(txc, groupId, userId, webhookId); err != nil {
+		return err
+	}
+	if err = txc.DeleteIncomingWebhook(webhookId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+// PostIncoming posts a message to the group of the webhook with the token.
+// Messages are posted as a bot named after the webhook unless the params
+// name another one. The webhook posts with the rights of its creator, so it
+// stops working once the creator may no longer manage it or post.
+func (s *WebhookService) PostIncoming(token string, params IncomingParams) (*model.Message, error) {
+	username := strings.TrimSpace(params.Username)
+	if len(username) > maxWebhookUsername {
+		return nil, &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("username must be at most %d characters long", maxWebhookUsername),
+		}
+	}
+	if len(params.Attachments) > maxWebhookAttachments {
+		return nil, &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrBadRequest,
+			Message: fmt.Sprintf("at most %d attachments are allowed", maxWebhookAttachments),
+		}
+	}
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	webhook, err := txc.GetIncomingWebhookByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.limiter.allow(webhook.Id, time.Now()); err != nil {
+		return nil, err
+	}
+	if err = s.checkIncoming(txc, webhook.GroupId, webhook.CreatedBy); err != nil {
+		return nil, errCreatorDenied
+	}
+	if err = txc.TouchIncomingWebhook(webhook.Id); err != nil {
+		return nil, fmt.Errorf("TouchIncomingWebhook: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	if err = s.messages.Contacts.CanPost(webhook.GroupId, webhook.CreatedBy); err != nil {
+		return nil, errCreatorDenied
+	}
+
+	if username == "" {
+		username = webhook.Name
+	}
+	return s.messages.Post(PostParams{
+		ChatId:      webhook.GroupId,
+		SenderId:    webhook.SenderId(),
+		Username:    username,
+		Content:     params.Text,
+		Attachments: params.Attachments,
+	})
+}
+
+var errCreatorDenied = &store.Error{
+	Kind:    store.KindWebhook,
+	Err:     store.ErrPermissionDenied,
+	Message: "the creator of the webhook cannot post to the group",
+}
+
+// checkIncoming fails unless the user may manage the incoming webhooks of
+// the group.
+func (s *WebhookService) checkIncoming(txc store.TxContacts, groupId, userId int) error {
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("cannot find group %d for user %d", groupId, userId),
+		}
+	}
+	if !s.access.Can(member.Role, member.Role, access.ActionIncomingHook) {
+		return &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrPermissionDenied,
+			Message: "only managers and owners can manage incoming webhooks",
+		}
+	}
+	return nil
+}
+
+func (s *WebhookService) getIncoming(txc store.TxContacts, groupId, userId, webhookId int) (*model.IncomingWebhook, error) {
+	if err := s.checkIncoming(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	webhook, err := txc.GetIncomingWebhook(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.GroupId != groupId {
+		return nil, &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("webhook '%d' not found", webhookId),
+		}
+	}
+	return webhook, nil
+}
@@ -0,0 +1,460 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/event"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// deliveryBatch is the number of due deliveries attempted per poll.
+	deliveryBatch = 20
+	// deliveryLogLimit is the number of deliveries listed per webhook.
+	deliveryLogLimit = 50
+)
+
+// WebhookService posts group events to the webhooks registered by group
+// owners, and posts messages received by incoming webhooks. Events are queued
+// in the contacts store and delivered by Start, so pending deliveries survive
+// restarts.
+type WebhookService struct {
+	store    store.ContactsStore
+	access   access.ContactsAccess
+	messages *MessageService
+	events   *event.EventHandler
+	cfg      config.WebhookConfig
+	client   *http.Client
+	limiter  *rateLimiter
+	// wake triggers a delivery run when new deliveries are queued
+	wake chan struct{}
+}
+
+func NewWebhookService(contactsStore store.ContactsStore, messages *MessageService, events *event.EventHandler, cfg config.WebhookConfig) (*WebhookService, error) {
+	s := WebhookService{
+		store:    contactsStore,
+		messages: messages,
+		events:   events,
+		cfg:      cfg,
+		client:   newOutboundClient(cfg.Timeout, cfg.AllowInternal),
+		limiter:  newRateLimiter(cfg.IncomingRate, cfg.IncomingBurst),
+		wake:     make(chan struct{}, 1),
+	}
+	return &s, nil
+}
+
+// CreateWebhook registers a webhook for the events of the group. No events
+// subscribes to all of them. The returned webhook carries its signing secret.
+func (s *WebhookService) CreateWebhook(userId, groupId int, rawURL string, events []string) (*model.Webhook, error) {
+	if err := checkOutboundURL(rawURL, s.cfg.AllowInternal); err != nil {
+		return nil, &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrBadRequest,
+			Message: err.Error(),
+		}
+	}
+	if len(events) == 0 {
+		events = model.WebhookEvents
+	}
+	for _, e := range events {
+		if !slices.Contains(model.WebhookEvents, e) {
+			return nil, &store.Error{
+				Kind:    store.KindWebhook,
+				Err:     store.ErrBadRequest,
+				Message: fmt.Sprintf("unknown event %q", e),
+			}
+		}
+	}
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkManage(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	webhook, err := txc.CreateWebhook(groupId, userId, rawURL, events)
+	if err != nil {
+		return nil, fmt.Errorf("CreateWebhook: %w", err)
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) ListWebhooks(userId, groupId int) ([]model.Webhook, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if err = s.checkManage(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	webhooks, err := txc.GetWebhooks(groupId)
+	if err != nil {
+		return nil, fmt.Errorf("GetWebhooks: %w", err)
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// SetDisabled stops or resumes deliveries to a webhook. Deliveries queued
+// while it is disabled are sent once it is enabled again.
+func (s *WebhookService) SetDisabled(userId, groupId, webhookId int, disabled bool) (*model.Webhook, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if _, err = s.getWebhook(txc, groupId, userId, webhookId); err != nil {
+		return nil, err
+	}
+	if err = txc.SetWebhookDisabled(webhookId, disabled); err != nil {
+		return nil, err
+	}
+	webhook, err := txc.GetWebhook(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if err = txc.Commit(); err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	if !disabled {
+		s.trigger()
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) DeleteWebhook(userId, groupId, webhookId int) error {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	if _, err = s.getWebhook(txc, groupId, userId, webhookId); err != nil {
+		return err
+	}
+	if err = txc.DeleteWebhook(webhookId); err != nil {
+		return err
+	}
+	return txc.Commit()
+}
+
+// ListDeliveries returns the latest deliveries of a webhook, newest first.
+func (s *WebhookService) ListDeliveries(userId, groupId, webhookId int) ([]model.WebhookDelivery, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txc.Rollback()
+
+	if _, err = s.getWebhook(txc, groupId, userId, webhookId); err != nil {
+		return nil, err
+	}
+	return txc.GetDeliveries(webhookId, deliveryLogLimit)
+}
+
+// checkManage fails unless the user may manage the webhooks of the group.
+func (s *WebhookService) checkManage(txc store.TxContacts, groupId, userId int) error {
+	member, err := txc.GetMember(groupId, userId)
+	if err != nil {
+		return &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("cannot find group %d for user %d", groupId, userId),
+		}
+	}
+	if !s.access.Can(member.Role, member.Role, access.ActionManageHooks) {
+		return &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrPermissionDenied,
+			Message: "only the group owner can manage webhooks",
+		}
+	}
+	return nil
+}
+
+func (s *WebhookService) getWebhook(txc store.TxContacts, groupId, userId, webhookId int) (*model.Webhook, error) {
+	if err := s.checkManage(txc, groupId, userId); err != nil {
+		return nil, err
+	}
+	webhook, err := txc.GetWebhook(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.GroupId != groupId {
+		return nil, &store.Error{
+			Kind:    store.KindWebhook,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("webhook '%d' not found", webhookId),
+		}
+	}
+	return webhook, nil
+}
+
+// Start subscribes to the published events and delivers due deliveries in
+// the background until ctx is done.
+func (s *WebhookService) Start(ctx context.Context) error {
+	for _, pattern := range model.WebhookEvents {
+		err := s.events.Register(ctx, pattern, func(e *event.Event) error {
+			ce, ok := e.Data.(model.ChatEvent)
+			if !ok {
+				return nil
+			}
+			if err := s.enqueue(ce); err != nil {
+				log.Error().Err(err).Str("event", ce.Type).Int("groupId", ce.GroupId).Msg("queue webhook deliveries")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	go s.run(ctx)
+	return nil
+}
+
+func (s *WebhookService) run(ctx context.Context) {
+	interval := s.cfg.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.DeliverPending(ctx); err != nil {
+			log.Error().Err(err).Msg("deliver webhooks")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *WebhookService) trigger() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue queues the event for every enabled webhook of its group that
+// subscribed to it.
+func (s *WebhookService) enqueue(ce model.ChatEvent) error {
+	payload, err := json.Marshal(model.WebhookPayload{
+		Event:     ce.Type,
+		GroupId:   ce.GroupId,
+		ActorId:   ce.ActorId,
+		Data:      ce.Data,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	txc, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer txc.Rollback()
+
+	webhooks, err := txc.GetWebhooks(ce.GroupId)
+	if err != nil {
+		return fmt.Errorf("GetWebhooks: %w", err)
+	}
+	queued := 0
+	for _, webhook := range webhooks {
+		if webhook.DisabledAt != nil || !slices.Contains(webhook.Events, ce.Type) {
+			continue
+		}
+		if _, err = txc.CreateDelivery(webhook.Id, ce.Type, string(payload)); err != nil {
+			return fmt.Errorf("CreateDelivery: %w", err)
+		}
+		queued++
+	}
+	if queued == 0 {
+		return nil
+	}
+	if err = txc.Commit(); err != nil {
+		return err
+	}
+	s.trigger()
+	return nil
+}
+
+// DeliverPending attempts the deliveries that are due and returns how many
+// were delivered. A delivery that cannot be recorded is logged and left for
+// the next run.
+func (s *WebhookService) DeliverPending(ctx context.Context) (int, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	deliveries, err := txc.GetDueDeliveries(deliveryBatch)
+	txc.Rollback()
+	if err != nil {
+		return 0, fmt.Errorf("GetDueDeliveries: %w", err)
+	}
+
+	delivered := 0
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			break
+		}
+		ok, err := s.attempt(ctx, delivery)
+		if err != nil {
+			log.Error().Err(err).Int("deliveryId", delivery.Id).Msg("deliver webhook")
+			continue
+		}
+		if ok {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// attempt posts one delivery and records the outcome.
+func (s *WebhookService) attempt(ctx context.Context, delivery model.WebhookDelivery) (bool, error) {
+	txc, err := s.store.Begin()
+	if err != nil {
+		return false, err
+	}
+	webhook, err := txc.GetWebhook(delivery.WebhookId)
+	txc.Rollback()
+	if err != nil {
+		return false, fmt.Errorf("GetWebhook: %w", err)
+	}
+
+	statusCode, postErr := s.post(ctx, webhook, delivery)
+
+	txc, err = s.store.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txc.Rollback()
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.StatusCode = statusCode
+	delivery.Error = ""
+	failures := webhook.Failures
+	if postErr == nil {
+		delivery.Status = model.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		failures = 0
+	} else {
+		delivery.Error = postErr.Error()
+		if s.cfg.MaxAttempts > 0 && delivery.Attempts >= s.cfg.MaxAttempts {
+			delivery.Status = model.DeliveryFailed
+			failures++
+		} else {
+			delivery.NextAttemptAt = now.Add(s.backoff(delivery.Attempts))
+		}
+	}
+	if err = txc.UpdateDelivery(delivery); err != nil {
+		return false, fmt.Errorf("UpdateDelivery: %w", err)
+	}
+	if failures != webhook.Failures {
+		if err = txc.SetWebhookFailures(webhook.Id, failures); err != nil {
+			return false, fmt.Errorf("SetWebhookFailures: %w", err)
+		}
+	}
+	disable := s.cfg.DisableAfter > 0 && failures >= s.cfg.DisableAfter
+	if disable {
+		if err = txc.SetWebhookDisabled(webhook.Id, true); err != nil {
+			return false, fmt.Errorf("SetWebhookDisabled: %w", err)
+		}
+	}
+	if err = txc.Commit(); err != nil {
+		return false, err
+	}
+	if disable {
+		log.Warn().Int("webhookId", webhook.Id).Int("failures", failures).Msg("disabled failing webhook")
+	}
+	return postErr == nil, nil
+}
+
+// post sends the delivery to the webhook. Any response other than 2xx is an
+// error.
+func (s *WebhookService) post(ctx context.Context, webhook *model.Webhook, delivery model.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gochat-webhook")
+	req.Header.Set("X-Gochat-Event", delivery.Event)
+	req.Header.Set("X-Gochat-Delivery", strconv.Itoa(delivery.Id))
+	req.Header.Set("X-Gochat-Timestamp", timestamp)
+	req.Header.Set("X-Gochat-Signature", signPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload returns the X-Gochat-Signature of a delivery: the hex HMAC-SHA256
+// of "<timestamp>.<payload>" keyed with the webhook secret.
+func signPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay before the next attempt after the given number
+// of failed attempts.
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	cfg := s.cfg
+	if cfg.BaseDelay <= 0 {
+		return 0
+	}
+	delay := cfg.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if cfg.MaxDelay > 0 && delay >= cfg.MaxDelay {
+			return cfg.MaxDelay
+		}
+	}
+	if cfg.MaxDelay > 0 && delay > cfg.MaxDelay {
+		return cfg.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elug3/gochat/pkg/access"
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/store/message/sqlite"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   string
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var webhookPreset = &Preset{
+	profiles: map[string]presetProfile{
+		"p1": {userId: 1, name: "p1"},
+		"p2": {userId: 2, name: "p2"},
+		"p3": {userId: 3, name: "p3"},
+	},
+	groups: map[string]presetGroup{
+		"g1": {name: "test group", owner: "p1", manager: []string{"p3"}, member: []string{"p2"}},
+	},
+}
+
+func newTestWebhookService(t *testing.T, cfg config.WebhookConfig) (*WebhookService, *MessageService, *PresetResult) {
+	t.Helper()
+	contacts, result, err := setup(t, webhookPreset)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	messageStore, err := sqlite.NewMessageStore(&config.Config{NoSave: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := NewMessageService(messageStore, contacts, contacts.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := NewWebhookService(contacts.store, messages, contacts.events, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webhooks, messages, result
+}
+
+func TestWebhook_Deliver(t *testing.T) {
+	webhooks, messages, result := newTestWebhookService(t, config.WebhookConfig{
+		Timeout:       time.Second,
+		PollInterval:  10 * time.Millisecond,
+		MaxAttempts:   2,
+		DisableAfter:  1,
+		AllowInternal: true,
+	})
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	g1, _ := result.GetGroup("g1")
+
+	var failing atomic.Bool
+	requests := make(chan webhookRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{header: r.Header, body: string(body)}
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	testCases := map[string]struct {
+		userId int
+		url    string
+		events []string
+		err    error
+	}{
+		"member":        {userId: p2.Id, url: srv.URL, err: store.ErrPermissionDenied},
+		"not a member":  {userId: 99, url: srv.URL, err: store.ErrNotFound},
+		"relative url":  {userId: p1.Id, url: "/hook", err: store.ErrBadRequest},
+		"unknown event": {userId: p1.Id, url: srv.URL, events: []string{"group.created"}, err: store.ErrBadRequest},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := webhooks.CreateWebhook(tc.userId, g1.Id, tc.url, tc.events); !errors.Is(err, tc.err) {
+				t.Errorf("expected error %q, but got %q", tc.err, err)
+			}
+		})
+	}
+
+	webhook, err := webhooks.CreateWebhook(p1.Id, g1.Id, srv.URL, []string{model.EventMessageCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Secret == "" {
+		t.Fatal("expected a signing secret")
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err = webhooks.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := messages.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req webhookRequest
+	select {
+	case req = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	if got := req.header.Get("X-Gochat-Event"); got != model.EventMessageCreated {
+		t.Errorf("expected event %q, but got %q", model.EventMessageCreated, got)
+	}
+	want := signPayload(webhook.Secret, req.header.Get("X-Gochat-Timestamp"), req.body)
+	if got := req.header.Get("X-Gochat-Signature"); got != want {
+		t.Errorf("expected signature %q, but got %q", want, got)
+	}
+	var payload struct {
+		Event   string        `json:"event"`
+		GroupId int           `json:"group_id"`
+		ActorId int           `json:"actor_id"`
+		Data    model.Message `json:"data"`
+	}
+	if err = json.Unmarshal([]byte(req.body), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.GroupId != g1.Id || payload.ActorId != p2.Id || payload.Data.Id != msg.Id {
+		t.Errorf("unexpected payload %s", req.body)
+	}
+	waitFor(t, func() bool {
+		deliveries, err := webhooks.ListDeliveries(p1.Id, g1.Id, webhook.Id)
+		return err == nil && len(deliveries) == 1 && deliveries[0].Status == model.DeliveryDelivered
+	})
+
+	// a failing endpoint is retried, given up and then disabled
+	failing.Store(true)
+	if _, err = messages.Send(p2.Id, SendParams{ChatId: g1.Id, Content: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		hooks, err := webhooks.ListWebhooks(p1.Id, g1.Id)
+		return err == nil && len(hooks) == 1 && hooks[0].DisabledAt != nil
+	})
+	if len(requests) != 2 {
+		t.Errorf("expected 2 attempts, but got %d", len(requests))
+	}
+	deliveries, err := webhooks.ListDeliveries(p1.Id, g1.Id, webhook.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := deliveries[0]; d.Status != model.DeliveryFailed || d.Attempts != 2 || d.StatusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+
+	hook, err := webhooks.SetDisabled(p1.Id, g1.Id, webhook.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.DisabledAt != nil || hook.Failures != 0 || hook.Secret != "" {
+		t.Errorf("unexpected webhook %+v", hook)
+	}
+}
+
+func TestWebhook_InternalAddress(t *testing.T) {
+	webhooks, _, result := newTestWebhookService(t, config.WebhookConfig{Timeout: time.Second})
+	p1, _ := result.GetProfile("p1")
+	g1, _ := result.GetGroup("g1")
+
+	testCases := map[string]struct {
+		url string
+		err error
+	}{
+		"loopback":   {url: "http://127.0.0.1:8080/hook", err: store.ErrBadRequest},
+		"localhost":  {url: "http://localhost/hook", err: store.ErrBadRequest},
+		"private":    {url: "https://10.1.2.3/hook", err: store.ErrBadRequest},
+		"metadata":   {url: "http://169.254.169.254/latest/meta-data", err: store.ErrBadRequest},
+		"ipv6":       {url: "http://[::1]/hook", err: store.ErrBadRequest},
+		"mapped":     {url: "http://[::ffff:192.168.0.1]/hook", err: store.ErrBadRequest},
+		"unresolved": {url: "http://gochat.invalid/hook", err: store.ErrBadRequest},
+		"public":     {url: "https://93.184.215.14/hook"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := webhooks.CreateWebhook(p1.Id, g1.Id, tc.url, nil); !errors.Is(err, tc.err) {
+				t.Errorf("expected error %q, but got %q", tc.err, err)
+			}
+		})
+	}
+
+	// hosts resolving to an internal address later are refused when dialing
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, err := webhooks.post(t.Context(), &model.Webhook{URL: srv.URL}, model.WebhookDelivery{})
+	if !errors.Is(err, errInternalAddress) {
+		t.Errorf("expected error %q, but got %q", errInternalAddress, err)
+	}
+}
+
+func TestWebhook_Incoming(t *testing.T) {
+	webhooks, messages, result := newTestWebhookService(t, config.WebhookConfig{
+		IncomingRate:  60,
+		IncomingBurst: 2,
+	})
+	p1, _ := result.GetProfile("p1")
+	p2, _ := result.GetProfile("p2")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	if _, err := webhooks.CreateIncoming(p2.Id, g1.Id, "alerts"); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	webhook, err := webhooks.CreateIncoming(p3.Id, g1.Id, "alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := IncomingParams{
+		Text:        "disk full",
+		Attachments: []model.Attachment{{Title: "db-1", URL: "https://example.com/db-1"}},
+	}
+	msg, err := webhooks.PostIncoming(webhook.Token, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := messages.List(p1.Id, g1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Id != msg.Id {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	if got := msgs[0]; !got.Bot || got.Username != "alerts" || got.SenderId != webhook.SenderId() || len(got.Attachments) != 1 {
+		t.Errorf("unexpected message %+v", got)
+	}
+
+	rotated, err := webhooks.RotateIncoming(p1.Id, g1.Id, webhook.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = webhooks.PostIncoming(webhook.Token, params); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+	if _, err = webhooks.PostIncoming(rotated.Token, IncomingParams{Text: "ok", Username: "monitor"}); err != nil {
+		t.Fatal(err)
+	}
+	// the burst of two is used up
+	_, err = webhooks.PostIncoming(rotated.Token, params)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || limited.RetryAfter <= 0 {
+		t.Errorf("expected rate limit error, but got %q", err)
+	}
+
+	if err = webhooks.RevokeIncoming(p3.Id, g1.Id, webhook.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = webhooks.PostIncoming(rotated.Token, params); !errors.Is(err, store.ErrUnauthorized) {
+		t.Errorf("expected error %q, but got %q", store.ErrUnauthorized, err)
+	}
+}
+
+func TestWebhook_IncomingCreator(t *testing.T) {
+	webhooks, messages, result := newTestWebhookService(t, config.WebhookConfig{})
+	p1, _ := result.GetProfile("p1")
+	p3, _ := result.GetProfile("p3")
+	g1, _ := result.GetGroup("g1")
+
+	webhook, err := webhooks.CreateIncoming(p3.Id, g1.Id, "alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := IncomingParams{Text: "disk full"}
+
+	// the group only lets the owner post
+	owner := model.PermissionOwner
+	if _, err = messages.Contacts.UpdateGroup(g1.Id, p1.Id, GroupUpdate{Settings: &SettingsUpdate{Post: &owner}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = webhooks.PostIncoming(webhook.Token, params); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("posting mode: expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+	everyone := model.PermissionEveryone
+	if _, err = messages.Contacts.UpdateGroup(g1.Id, p1.Id, GroupUpdate{Settings: &SettingsUpdate{Post: &everyone}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = webhooks.PostIncoming(webhook.Token, params); err != nil {
+		t.Fatal(err)
+	}
+
+	// the creator is demoted
+	if _, err = messages.Contacts.SetRole(g1.Id, p1.Id, p3.Id, access.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = webhooks.PostIncoming(webhook.Token, params); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("demoted creator: expected error %q, but got %q", store.ErrPermissionDenied, err)
+	}
+}
@@ -0,0 +1,255 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// GetBlocks returns the users blocked by the user.
+func (txc *TxContacts) GetBlocks(userId int) ([]model.Block, error) {
+	rows, err := txc.tx.Query(`
+	SELECT user_id, blocked_id, created_at
+	FROM block
+	WHERE user_id = ?
+	ORDER BY created_at;
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	blocks := make([]model.Block, 0)
+	for rows.Next() {
+		var block model.Block
+		if err = rows.Scan(&block.UserId, &block.BlockedId, &block.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		blocks = append(blocks, block)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return blocks, nil
+}
+
+func (txc *TxContacts) BlockExists(userId, blockedId int) (bool, error) {
+	var exists bool
+	err := txc.tx.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM block WHERE user_id = ? AND blocked_id = ?);
+	`, userId, blockedId).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (txc *TxContacts) CreateBlock(userId, blockedId int) (*model.Block, error) {
+	if exists, err := txc.BlockExists(userId, blockedId); err != nil || exists {
+		if err != nil {
+			return nil, err
+		}
+		return nil, &store.Error{
+			Kind:    store.KindBlock,
+			Err:     store.ErrExists,
+			Message: fmt.Sprintf("user '%d' is already blocked", blockedId),
+		}
+	}
+
+	var block model.Block
+	err := txc.tx.QueryRow(`
+	INSERT INTO block (user_id, blocked_id)
+	VALUES (?, ?)
+	RETURNING user_id, blocked_id, created_at;
+	`, userId, blockedId).Scan(&block.UserId, &block.BlockedId, &block.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (txc *TxContacts) DeleteBlock(userId, blockedId int) error {
+	result, err := txc.tx.Exec(`
+	DELETE FROM block
+	WHERE user_id = ? AND blocked_id = ?;
+	`, userId, blockedId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindBlock,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("user '%d' is not blocked", blockedId),
+		}
+	}
+	return nil
+}
+
+// RejectContactRequests rejects all pending requests between the two users.
+func (txc *TxContacts) RejectContactRequests(userId, peerId int) error {
+	_, err := txc.tx.Exec(`
+	UPDATE contact_request
+	SET status = ?
+	WHERE status = ? AND (
+		(from_id = ? AND to_id = ?) OR
+		(from_id = ? AND to_id = ?)
+	);
+	`, model.ContactRequestRejected, model.ContactRequestPending, userId, peerId, peerId, userId)
+	return err
+}
+
+// GetMute returns the active mute of the user on the group.
+func (txc *TxContacts) GetMute(userId, groupId int) (*model.Mute, error) {
+	var mute model.Mute
+	var until sql.NullTime
+	err := txc.tx.QueryRow(`
+	SELECT user_id, group_id, until, created_at
+	FROM mute
+	WHERE user_id = ? AND group_id = ? AND (
+		until IS NULL OR
+		datetime(until) > datetime('now')
+	);
+	`, userId, groupId).Scan(&mute.UserId, &mute.GroupId, &until, &mute.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindMute,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("group '%d' is not muted", groupId),
+			}
+		}
+		return nil, err
+	}
+	if until.Valid {
+		mute.Until = &until.Time
+	}
+	return &mute, nil
+}
+
+// SetMute mutes the group for the user, replacing an existing mute.
+// A nil until mutes the group until it is unmuted.
+func (txc *TxContacts) SetMute(userId, groupId int, until *time.Time) (*model.Mute, error) {
+	var untilAt sql.NullTime
+	if until != nil {
+		untilAt = sql.NullTime{Time: until.UTC(), Valid: true}
+	}
+	_, err := txc.tx.Exec(`
+	INSERT INTO mute (user_id, group_id, until)
+	VALUES (?, ?, ?)
+	ON CONFLICT(user_id, group_id) DO UPDATE SET
+		until = excluded.until,
+		created_at = datetime('now');
+	`, userId, groupId, untilAt)
+	if err != nil {
+		return nil, err
+	}
+	return txc.GetMute(userId, groupId)
+}
+
+func (txc *TxContacts) DeleteMute(userId, groupId int) error {
+	result, err := txc.tx.Exec(`
+	DELETE FROM mute
+	WHERE user_id = ? AND group_id = ?;
+	`, userId, groupId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindMute,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("group '%d' is not muted", groupId),
+		}
+	}
+	return nil
+}
+
+// GetRestriction returns the active restriction of the user in the group.
+func (txc *TxContacts) GetRestriction(userId, groupId int) (*model.Restriction, error) {
+	var restriction model.Restriction
+	var until sql.NullTime
+	err := txc.tx.QueryRow(`
+	SELECT user_id, group_id, until, created_by, created_at
+	FROM restriction
+	WHERE user_id = ? AND group_id = ? AND (
+		until IS NULL OR
+		julianday(until) > julianday('now')
+	);
+	`, userId, groupId).Scan(&restriction.UserId, &restriction.GroupId, &until, &restriction.CreatedBy, &restriction.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindRestrict,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("user '%d' is not restricted in group '%d'", userId, groupId),
+			}
+		}
+		return nil, err
+	}
+	if until.Valid {
+		restriction.Until = &until.Time
+	}
+	return &restriction, nil
+}
+
+// SetRestriction restricts the user in the group, replacing an existing
+// restriction. A nil until restricts the user until the restriction is
+// deleted.
+func (txc *TxContacts) SetRestriction(userId, groupId, createdBy int, until *time.Time) (*model.Restriction, error) {
+	var untilAt sql.NullTime
+	if until != nil {
+		untilAt = sql.NullTime{Time: until.UTC(), Valid: true}
+	}
+	var restriction model.Restriction
+	err := txc.tx.QueryRow(`
+	INSERT INTO restriction (user_id, group_id, until, created_by)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(user_id, group_id) DO UPDATE SET
+		until = excluded.until,
+		created_by = excluded.created_by,
+		created_at = datetime('now')
+	RETURNING user_id, group_id, until, created_by, created_at;
+	`, userId, groupId, untilAt, createdBy).Scan(&restriction.UserId, &restriction.GroupId, &untilAt, &restriction.CreatedBy, &restriction.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if untilAt.Valid {
+		restriction.Until = &untilAt.Time
+	}
+	return &restriction, nil
+}
+
+func (txc *TxContacts) DeleteRestriction(userId, groupId int) error {
+	result, err := txc.tx.Exec(`
+	DELETE FROM restriction
+	WHERE user_id = ? AND group_id = ?;
+	`, userId, groupId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindRestrict,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("user '%d' is not restricted in group '%d'", userId, groupId),
+		}
+	}
+	return nil
+}
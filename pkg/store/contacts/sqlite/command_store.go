@@ -0,0 +1,132 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// CreateCommand registers an external command of a group with a new signing
+// secret.
+func (txc *TxContacts) CreateCommand(command model.Command) (*model.Command, error) {
+	if _, err := txc.GetCommandByName(command.GroupId, command.Name); err == nil {
+		return nil, &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrExists,
+			Message: fmt.Sprintf("command '/%s' already exists", command.Name),
+		}
+	}
+	return scanCommand(txc.tx.QueryRow(`
+	INSERT INTO command (group_id, name, description, usage, url, secret, created_by)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING `+commandColumns+`;
+	`, command.GroupId, command.Name, command.Description, command.Usage, command.URL, rand.Text(), command.CreatedBy))
+}
+
+const commandColumns = `id, group_id, name, description, usage, url, secret, created_by, created_at`
+
+func scanCommand(row interface{ Scan(...any) error }) (*model.Command, error) {
+	var command model.Command
+	err := row.Scan(
+		&command.Id,
+		&command.GroupId,
+		&command.Name,
+		&command.Description,
+		&command.Usage,
+		&command.URL,
+		&command.Secret,
+		&command.CreatedBy,
+		&command.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+func (txc *TxContacts) GetCommand(id int) (*model.Command, error) {
+	command, err := scanCommand(txc.tx.QueryRow(`
+	SELECT `+commandColumns+`
+	FROM command
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindCommand,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("command '%d' not found", id),
+			}
+		}
+		return nil, err
+	}
+	return command, nil
+}
+
+func (txc *TxContacts) GetCommandByName(groupId int, name string) (*model.Command, error) {
+	command, err := scanCommand(txc.tx.QueryRow(`
+	SELECT `+commandColumns+`
+	FROM command
+	WHERE group_id = ? AND name = ?;
+	`, groupId, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindCommand,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("command '/%s' not found", name),
+			}
+		}
+		return nil, err
+	}
+	return command, nil
+}
+
+func (txc *TxContacts) GetCommands(groupId int) ([]model.Command, error) {
+	rows, err := txc.tx.Query(`
+	SELECT `+commandColumns+`
+	FROM command
+	WHERE group_id = ?
+	ORDER BY name;
+	`, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	commands := make([]model.Command, 0)
+	for rows.Next() {
+		command, err := scanCommand(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		commands = append(commands, *command)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+func (txc *TxContacts) DeleteCommand(id int) error {
+	result, err := txc.tx.Exec(`DELETE FROM command WHERE id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindCommand,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("command '%d' not found", id),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// directKey returns the key identifying the direct group between two users,
+// independent of the order of the ids.
+func directKey(userId, peerId int) string {
+	if peerId < userId {
+		userId, peerId = peerId, userId
+	}
+	return fmt.Sprintf("%d:%d", userId, peerId)
+}
+
+func (txc *TxContacts) GetDirectGroup(userId, peerId int) (*model.Group, error) {
+	group, err := scanGroup(txc.tx.QueryRow(`
+	SELECT `+groupColumns+`
+	FROM groups
+	WHERE direct_key = ?;
+	`, directKey(userId, peerId)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindGroup,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("direct group between '%d' and '%d' not found", userId, peerId),
+			}
+		}
+		return nil, err
+	}
+	return group, nil
+}
+
+// CreateDirectGroup creates the group for a conversation between two users.
+// Members are added by the caller.
+func (txc *TxContacts) CreateDirectGroup(userId, peerId int) (*model.Group, error) {
+	return scanGroup(txc.tx.QueryRow(`
+	INSERT INTO groups (name, direct_key)
+	VALUES ('direct', ?)
+	RETURNING `+groupColumns+`;
+	`, directKey(userId, peerId)))
+}
+
+func (txc *TxContacts) GetContacts(userId int) ([]model.Contact, error) {
+	rows, err := txc.tx.Query(`
+	SELECT p.user_id, p.name, p.last_seen, c.created_at
+	FROM contact c
+	JOIN profile p ON p.user_id = c.contact_id
+	WHERE c.user_id = ?
+	ORDER BY p.name;
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := make([]model.Contact, 0)
+	for rows.Next() {
+		var contact model.Contact
+		var lastSeen sql.NullTime
+		if err = rows.Scan(&contact.UserId, &contact.Name, &lastSeen, &contact.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		if lastSeen.Valid {
+			contact.LastSeen = &lastSeen.Time
+		}
+		contacts = append(contacts, contact)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return contacts, nil
+}
+
+func (txc *TxContacts) ContactExists(userId, contactId int) (bool, error) {
+	var exists bool
+	err := txc.tx.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM contact WHERE user_id = ? AND contact_id = ?);
+	`, userId, contactId).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// CreateContact links both users as contacts of each other.
+func (txc *TxContacts) CreateContact(userId, contactId int) error {
+	for _, id := range []int{userId, contactId} {
+		exists, err := txc.profileExists(id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return &store.Error{
+				Kind:    store.KindProfile,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("profile '%d' not found", id),
+			}
+		}
+	}
+
+	_, err := txc.tx.Exec(`
+	INSERT OR IGNORE INTO contact (user_id, contact_id)
+	VALUES (?, ?), (?, ?);
+	`, userId, contactId, contactId, userId)
+	return err
+}
+
+// DeleteContact removes the contact in both directions.
+func (txc *TxContacts) DeleteContact(userId, contactId int) error {
+	result, err := txc.tx.Exec(`
+	DELETE FROM contact
+	WHERE (user_id = ? AND contact_id = ?) OR (user_id = ? AND contact_id = ?);
+	`, userId, contactId, contactId, userId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &store.Error{
+			Kind:    store.KindContact,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("contact '%d' not found", contactId),
+		}
+	}
+	return nil
+}
+
+func scanContactRequest(row interface{ Scan(...any) error }) (*model.ContactRequest, error) {
+	var req model.ContactRequest
+	if err := row.Scan(&req.Id, &req.FromId, &req.ToId, &req.Status, &req.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (txc *TxContacts) GetContactRequest(id int) (*model.ContactRequest, error) {
+	req, err := scanContactRequest(txc.tx.QueryRow(`
+	SELECT id, from_id, to_id, status, created_at
+	FROM contact_request
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindRequest,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("contact request '%d' not found", id),
+			}
+		}
+		return nil, err
+	}
+	return req, nil
+}
+
+// GetContactRequests returns the pending requests sent or received by the user.
+func (txc *TxContacts) GetContactRequests(userId int) ([]model.ContactRequest, error) {
+	rows, err := txc.tx.Query(`
+	SELECT id, from_id, to_id, status, created_at
+	FROM contact_request
+	WHERE (from_id = ? OR to_id = ?) AND status = ?
+	ORDER BY created_at DESC;
+	`, userId, userId, model.ContactRequestPending)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	reqs := make([]model.ContactRequest, 0)
+	for rows.Next() {
+		req, err := scanContactRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		reqs = append(reqs, *req)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return reqs, nil
+}
+
+func (txc *TxContacts) GetPendingContactRequest(fromId, toId int) (*model.ContactRequest, error) {
+	req, err := scanContactRequest(txc.tx.QueryRow(`
+	SELECT id, from_id, to_id, status, created_at
+	FROM contact_request
+	WHERE from_id = ? AND to_id = ? AND status = ?;
+	`, fromId, toId, model.ContactRequestPending))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindRequest,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("no pending contact request from '%d' to '%d'", fromId, toId),
+			}
+		}
+		return nil, err
+	}
+	return req, nil
+}
+
+func (txc *TxContacts) CreateContactRequest(fromId, toId int) (*model.ContactRequest, error) {
+	for _, id := range []int{fromId, toId} {
+		exists, err := txc.profileExists(id)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, &store.Error{
+				Kind:    store.KindProfile,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("profile '%d' not found", id),
+			}
+		}
+	}
+
+	return scanContactRequest(txc.tx.QueryRow(`
+	INSERT INTO contact_request (from_id, to_id)
+	VALUES (?, ?)
+	RETURNING id, from_id, to_id, status, created_at;
+	`, fromId, toId))
+}
+
+func (txc *TxContacts) UpdateContactRequest(id int, status model.ContactRequestStatus) error {
+	result, err := txc.tx.Exec(`
+	UPDATE contact_request
+	SET status = ?
+	WHERE id = ?;
+	`, status, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("contact request '%d' not found", id),
+		}
+	}
+	return nil
+}
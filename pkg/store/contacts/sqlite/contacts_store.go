@@ -4,10 +4,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 
-	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/pkg/config"
 	"github.com/elug3/gochat/pkg/model"
 	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/store/schema"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -47,17 +49,52 @@ func (txc *TxContacts) Commit() error {
 	return txc.tx.Commit()
 }
 
-func (txc *TxContacts) GetGroup(groupId int) (*model.Group, error) {
+const groupColumns = `id, name, description, avatar, direct_key IS NOT NULL, retention, post_permission, invite_permission, join_approval, public, created_at, updated_at`
+
+func scanGroup(row interface{ Scan(...any) error }) (*model.Group, error) {
 	var group model.Group
-	err := txc.tx.QueryRow(`
-	SELECT id, name, created_at
+	var updatedAt sql.NullTime
+	err := row.Scan(
+		&group.Id,
+		&group.Name,
+		&group.Description,
+		&group.Avatar,
+		&group.Direct,
+		&group.Retention,
+		&group.Settings.Post,
+		&group.Settings.Invite,
+		&group.Settings.JoinApproval,
+		&group.Settings.Public,
+		&group.CreatedAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	group.UpdatedAt = group.CreatedAt
+	if updatedAt.Valid {
+		group.UpdatedAt = updatedAt.Time
+	}
+	return &group, nil
+}
+
+func (txc *TxContacts) GetGroup(groupId int) (*model.Group, error) {
+	group, err := scanGroup(txc.tx.QueryRow(`
+	SELECT `+groupColumns+`
 	FROM groups
 	WHERE id = ?
-	`, groupId).Scan(&group.Id, &group.Name, &group.CreatedAt)
+	`, groupId))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindGroup,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("group '%d' not found", groupId),
+			}
+		}
 		return nil, err
 	}
-	return &group, nil
+	return group, nil
 }
 
 func (txc *TxContacts) GetGroups(userId int) ([]model.Group, error) {
@@ -89,10 +126,9 @@ func (txc *TxContacts) GetGroups(userId int) ([]model.Group, error) {
 
 func (txc *TxContacts) getGroups(userId int) ([]model.Group, error) {
 	rows, err := txc.tx.Query(`
-	SELECT g.id, g.name, g.created_at
-	FROM groups g
-	JOIN member m ON g.id = m.group_id
-	WHERE m.user_id = ?`, userId)
+	SELECT `+groupColumns+`
+	FROM groups
+	WHERE id IN (SELECT group_id FROM member WHERE user_id = ?)`, userId)
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
 	}
@@ -100,11 +136,11 @@ func (txc *TxContacts) getGroups(userId int) ([]model.Group, error) {
 
 	groups := make([]model.Group, 0)
 	for rows.Next() {
-		var group model.Group
-		if err = rows.Scan(&group.Id, &group.Name, &group.CreatedAt); err != nil {
+		group, err := scanGroup(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan: %w", err)
 		}
-		groups = append(groups, group)
+		groups = append(groups, *group)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows: %w", err)
@@ -113,6 +149,42 @@ func (txc *TxContacts) getGroups(userId int) ([]model.Group, error) {
 	return groups, nil
 }
 
+// SearchGroups returns up to limit public groups whose name or description
+// contains the query, ordered by name.
+func (txc *TxContacts) SearchGroups(query string, limit int) ([]model.Group, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	rows, err := txc.tx.Query(`
+	SELECT `+groupColumns+`
+	FROM groups
+	WHERE public AND direct_key IS NULL AND (
+		name LIKE ? ESCAPE '\' OR
+		description LIKE ? ESCAPE '\'
+	)
+	ORDER BY name, id
+	LIMIT ?;
+	`, pattern, pattern, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make([]model.Group, 0)
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		groups = append(groups, *group)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return groups, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 func (txc *TxContacts) CreateGroup(name string) (*model.Group, error) {
 	if len(name) < 2 {
 		return nil, &store.Error{
@@ -121,16 +193,67 @@ func (txc *TxContacts) CreateGroup(name string) (*model.Group, error) {
 			Message: "Group name must be at least two characters long",
 		}
 	}
-	row := txc.tx.QueryRow(`
+	return scanGroup(txc.tx.QueryRow(`
 	INSERT INTO groups (name)
 	VALUES (?)
-	RETURNING id, name, created_at`, name)
-	var group model.Group
-	err := row.Scan(&group.Id, &group.Name, &group.CreatedAt)
+	RETURNING `+groupColumns, name))
+}
+
+// SetRetention sets how many seconds messages of the group are kept, or
+// keeps them forever if retention is 0.
+func (txc *TxContacts) SetRetention(id, retention int) error {
+	result, err := txc.tx.Exec(`
+	UPDATE groups
+	SET retention = ?
+	WHERE id = ?;
+	`, retention, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &group, nil
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("group '%d' not found", id),
+		}
+	}
+	return nil
+}
+
+// UpdateGroup saves the name, description, avatar, settings and update time
+// of the group.
+func (txc *TxContacts) UpdateGroup(group model.Group) error {
+	if len(group.Name) < 2 {
+		return &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrBadRequest,
+			Message: "Group name must be at least two characters long",
+		}
+	}
+	result, err := txc.tx.Exec(`
+	UPDATE groups
+	SET name = ?, description = ?, avatar = ?, post_permission = ?, invite_permission = ?, join_approval = ?, public = ?, updated_at = ?
+	WHERE id = ?;
+	`, group.Name, group.Description, group.Avatar, group.Settings.Post, group.Settings.Invite, group.Settings.JoinApproval, group.Settings.Public, group.UpdatedAt.UTC(), group.Id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindGroup,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("group '%d' not found", group.Id),
+		}
+	}
+	return nil
 }
 
 func (txc *TxContacts) DeleteGroup(id int) error {
@@ -262,6 +385,29 @@ func (txc *TxContacts) GetMembers(groupId int) ([]model.Member, error) {
 
 }
 
+func (txc *TxContacts) SetMemberRole(groupId, userId int, role model.Role) error {
+	result, err := txc.tx.Exec(`
+	UPDATE member
+	SET role = ?
+	WHERE group_id = ? AND user_id = ?;
+	`, role, groupId, userId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindMember,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("user '%d' not exists in group '%d'", userId, groupId),
+		}
+	}
+	return nil
+}
+
 func (txc *TxContacts) DeleteMember(groupId, userId int) error {
 	exists, err := txc.MemberExists(groupId, userId)
 	if err != nil {
@@ -328,6 +474,89 @@ func (txc *TxContacts) CreateProfile(userId int, name string) (*model.Profile, e
 	return &profile, nil
 }
 
+func (txc *TxContacts) GetProfile(userId int) (*model.Profile, error) {
+	var profile model.Profile
+	var lastSeen sql.NullTime
+	err := txc.tx.QueryRow(`
+	SELECT user_id, name, last_seen, contacts_only, bot
+	FROM profile
+	WHERE user_id = ?;
+	`, userId).Scan(&profile.Id, &profile.Name, &lastSeen, &profile.ContactsOnly, &profile.Bot)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindProfile,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("profile '%d' not found", userId),
+			}
+		}
+		return nil, err
+	}
+	if lastSeen.Valid {
+		profile.LastSeen = &lastSeen.Time
+	}
+	return &profile, nil
+}
+
+// UpdateLastSeen records activity for the profile.
+// Writes are throttled to at most one per minute.
+func (txc *TxContacts) UpdateLastSeen(userId int) error {
+	_, err := txc.tx.Exec(`
+	UPDATE profile
+	SET last_seen = datetime('now')
+	WHERE user_id = ? AND (
+		last_seen IS NULL OR
+		datetime(last_seen) < datetime('now', '-1 minute')
+	);
+	`, userId)
+	return err
+}
+
+func (txc *TxContacts) SetContactsOnly(userId int, contactsOnly bool) error {
+	result, err := txc.tx.Exec(`
+	UPDATE profile
+	SET contacts_only = ?
+	WHERE user_id = ?;
+	`, contactsOnly, userId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		if err != nil {
+			return err
+		}
+		return &store.Error{
+			Kind:    store.KindProfile,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("profile '%d' not found", userId),
+		}
+	}
+	return nil
+}
+
+// SetBot marks the profile as belonging to a bot.
+func (txc *TxContacts) SetBot(userId int) error {
+	result, err := txc.tx.Exec(`
+	UPDATE profile
+	SET bot = 1
+	WHERE user_id = ?;
+	`, userId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		if err != nil {
+			return err
+		}
+		return &store.Error{
+			Kind:    store.KindProfile,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("profile '%d' not found", userId),
+		}
+	}
+	return nil
+}
+
 func (txc *TxContacts) DeleteProfile(id int) error {
 	if exists, err := txc.profileExists(id); !exists {
 		if err != nil {
@@ -364,7 +593,15 @@ func openDB(cfg *config.Config) (*sql.DB, error) {
 	} else {
 		path = "file:" + cfg.SaveDir + "/contacts.db"
 	}
-	return sql.Open("sqlite3", path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// every connection to :memory: opens a separate database
+	if cfg.NoSave {
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
 }
 
 func initDB(db *sql.DB) error {
@@ -403,10 +640,268 @@ func initDB(db *sql.DB) error {
 	_, err = db.Exec(`
 	CREATE TABLE IF NOT EXISTS profile (
 	user_id INTEGER PRIMARY KEY,
-	name varchar(20) NOT NULL
+	name varchar(20) NOT NULL,
+	last_seen TIMESTAMP,
+	contacts_only BOOLEAN NOT NULL DEFAULT 0
 	);`)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("create table profile: %w", err))
 	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS contact (
+	user_id INTEGER NOT NULL,
+	contact_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	FOREIGN KEY(user_id) REFERENCES profile(user_id) ON DELETE CASCADE,
+	FOREIGN KEY(contact_id) REFERENCES profile(user_id) ON DELETE CASCADE,
+	PRIMARY KEY(user_id, contact_id)
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table contact: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS contact_request (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	from_id INTEGER NOT NULL,
+	to_id INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	FOREIGN KEY(from_id) REFERENCES profile(user_id) ON DELETE CASCADE,
+	FOREIGN KEY(to_id) REFERENCES profile(user_id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table contact_request: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS block (
+	user_id INTEGER NOT NULL,
+	blocked_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	FOREIGN KEY(user_id) REFERENCES profile(user_id) ON DELETE CASCADE,
+	PRIMARY KEY(user_id, blocked_id)
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table block: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mute (
+	user_id INTEGER NOT NULL,
+	group_id INTEGER NOT NULL,
+	until TIMESTAMP,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	FOREIGN KEY(user_id, group_id) REFERENCES member(user_id, group_id) ON DELETE CASCADE,
+	PRIMARY KEY(user_id, group_id)
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table mute: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS restriction (
+	user_id INTEGER NOT NULL,
+	group_id INTEGER NOT NULL,
+	until TIMESTAMP,
+	created_by INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	PRIMARY KEY(user_id, group_id)
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table restriction: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS join_request (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	group_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	decided_by INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT (datetime('now'))
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table join_request: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE UNIQUE INDEX IF NOT EXISTS join_request_pending ON join_request(group_id, user_id) WHERE status = 'pending';
+	`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create index join_request_pending: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS webhook (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	group_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL,
+	failures INTEGER NOT NULL DEFAULT 0,
+	disabled_at TIMESTAMP,
+	created_by INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table webhook: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS webhook_delivery (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT (datetime('now')),
+	status_code INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	delivered_at TIMESTAMP,
+	FOREIGN KEY(webhook_id) REFERENCES webhook(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table webhook_delivery: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS webhook_delivery_due ON webhook_delivery(status, next_attempt_at);
+	`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create index webhook_delivery_due: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS incoming_webhook (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	group_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
+	created_by INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	last_used_at TIMESTAMP,
+	FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table incoming_webhook: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS command (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	group_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	usage TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	created_by INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	UNIQUE(group_id, name),
+	FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table command: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS poll (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	group_id INTEGER NOT NULL,
+	message_id TEXT NOT NULL DEFAULT '',
+	question TEXT NOT NULL,
+	multiple BOOLEAN NOT NULL DEFAULT 0,
+	anonymous BOOLEAN NOT NULL DEFAULT 0,
+	created_by INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	closes_at TIMESTAMP,
+	closed_at TIMESTAMP,
+	FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table poll: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS poll_option (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	poll_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	text TEXT NOT NULL,
+	FOREIGN KEY(poll_id) REFERENCES poll(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table poll_option: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS poll_vote (
+	poll_id INTEGER NOT NULL,
+	option_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	PRIMARY KEY(option_id, user_id),
+	FOREIGN KEY(option_id) REFERENCES poll_option(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table poll_vote: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS scheduled_job (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	group_id INTEGER NOT NULL,
+	message_id TEXT NOT NULL DEFAULT '',
+	content TEXT NOT NULL DEFAULT '',
+	run_at TIMESTAMP NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT (datetime('now'))
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table scheduled_job: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS scheduled_job_due ON scheduled_job(status, run_at);
+	`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create index scheduled_job_due: %w", err))
+	}
+
+	// columns added after the initial schema
+	columns := []struct{ table, name, def string }{
+		{"groups", "direct_key", "TEXT"},
+		{"profile", "last_seen", "TIMESTAMP"},
+		{"profile", "contacts_only", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"profile", "bot", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"groups", "retention", "INTEGER NOT NULL DEFAULT 0"},
+		{"groups", "description", "TEXT NOT NULL DEFAULT ''"},
+		{"groups", "avatar", "TEXT NOT NULL DEFAULT ''"},
+		{"groups", "post_permission", "TEXT NOT NULL DEFAULT 'everyone'"},
+		{"groups", "invite_permission", "TEXT NOT NULL DEFAULT 'owner'"},
+		{"groups", "join_approval", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"groups", "updated_at", "TIMESTAMP"},
+		{"groups", "public", "BOOLEAN NOT NULL DEFAULT 0"},
+	}
+	for _, col := range columns {
+		if err = schema.EnsureColumn(db, col.table, col.name, col.def); err != nil {
+			errs = append(errs, fmt.Errorf("ensure column %s.%s: %w", col.table, col.name, err))
+		}
+	}
+
+	_, err = db.Exec(`
+	CREATE UNIQUE INDEX IF NOT EXISTS groups_direct_key ON groups(direct_key);
+	`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create index groups_direct_key: %w", err))
+	}
 	return errors.Join(errs...)
 }
@@ -3,7 +3,7 @@ package sqlite
 import (
 	"testing"
 
-	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/pkg/config"
 )
 
 func newTestContactsStore() (*ContactsStore, error) {
@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+const joinRequestColumns = `id, group_id, user_id, status, decided_by, created_at`
+
+func scanJoinRequest(row interface{ Scan(...any) error }) (*model.JoinRequest, error) {
+	var req model.JoinRequest
+	if err := row.Scan(&req.Id, &req.GroupId, &req.UserId, &req.Status, &req.DecidedBy, &req.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (txc *TxContacts) GetJoinRequest(id int) (*model.JoinRequest, error) {
+	req, err := scanJoinRequest(txc.tx.QueryRow(`
+	SELECT `+joinRequestColumns+`
+	FROM join_request
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errJoinRequestNotFound(id)
+		}
+		return nil, err
+	}
+	return req, nil
+}
+
+// GetJoinRequests returns the pending requests to join the group, oldest
+// first.
+func (txc *TxContacts) GetJoinRequests(groupId int) ([]model.JoinRequest, error) {
+	rows, err := txc.tx.Query(`
+	SELECT `+joinRequestColumns+`
+	FROM join_request
+	WHERE group_id = ? AND status = ?
+	ORDER BY created_at, id;
+	`, groupId, model.JoinRequestPending)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	reqs := make([]model.JoinRequest, 0)
+	for rows.Next() {
+		req, err := scanJoinRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		reqs = append(reqs, *req)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return reqs, nil
+}
+
+// PendingJoinRequestExists reports whether the user has a pending request to
+// join the group.
+func (txc *TxContacts) PendingJoinRequestExists(groupId, userId int) (bool, error) {
+	var exists bool
+	err := txc.tx.QueryRow(`
+	SELECT EXISTS(
+		SELECT 1 FROM join_request
+		WHERE group_id = ? AND user_id = ? AND status = ?
+	);
+	`, groupId, userId, model.JoinRequestPending).Scan(&exists)
+	return exists, err
+}
+
+func (txc *TxContacts) CreateJoinRequest(groupId, userId int) (*model.JoinRequest, error) {
+	if exists, err := txc.PendingJoinRequestExists(groupId, userId); err != nil || exists {
+		if err != nil {
+			return nil, err
+		}
+		return nil, &store.Error{
+			Kind:    store.KindRequest,
+			Err:     store.ErrExists,
+			Message: fmt.Sprintf("request to join group '%d' is already pending", groupId),
+		}
+	}
+	return scanJoinRequest(txc.tx.QueryRow(`
+	INSERT INTO join_request (group_id, user_id)
+	VALUES (?, ?)
+	RETURNING `+joinRequestColumns+`;
+	`, groupId, userId))
+}
+
+// UpdateJoinRequest records the decision of a manager on the request.
+func (txc *TxContacts) UpdateJoinRequest(id int, status model.JoinRequestStatus, decidedBy int) error {
+	result, err := txc.tx.Exec(`
+	UPDATE join_request
+	SET status = ?, decided_by = ?
+	WHERE id = ?;
+	`, status, decidedBy, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errJoinRequestNotFound(id)
+	}
+	return nil
+}
+
+// DeleteJoinRequests deletes the pending requests of the user.
+func (txc *TxContacts) DeleteJoinRequests(userId int) error {
+	_, err := txc.tx.Exec(`
+	DELETE FROM join_request
+	WHERE user_id = ? AND status = ?;
+	`, userId, model.JoinRequestPending)
+	return err
+}
+
+// DeleteGroupJoinRequests deletes all requests to join the group.
+func (txc *TxContacts) DeleteGroupJoinRequests(groupId int) error {
+	_, err := txc.tx.Exec(`
+	DELETE FROM join_request
+	WHERE group_id = ?;
+	`, groupId)
+	return err
+}
+
+func errJoinRequestNotFound(id int) error {
+	return &store.Error{
+		Kind:    store.KindRequest,
+		Err:     store.ErrNotFound,
+		Message: fmt.Sprintf("join request '%d' not found", id),
+	}
+}
@@ -0,0 +1,223 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// CreatePoll stores the poll with its options, in the given order.
+func (txc *TxContacts) CreatePoll(poll model.Poll) (*model.Poll, error) {
+	var id int
+	err := txc.tx.QueryRow(`
+	INSERT INTO poll (group_id, question, multiple, anonymous, created_by, closes_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	RETURNING id;
+	`, poll.GroupId, poll.Question, poll.Multiple, poll.Anonymous, poll.CreatedBy, poll.ClosesAt).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	for i, option := range poll.Options {
+		_, err = txc.tx.Exec(`
+		INSERT INTO poll_option (poll_id, position, text)
+		VALUES (?, ?, ?);
+		`, id, i, option.Text)
+		if err != nil {
+			return nil, fmt.Errorf("insert option: %w", err)
+		}
+	}
+	return txc.GetPoll(id)
+}
+
+// GetPoll returns the poll with the votes and voters of its options.
+func (txc *TxContacts) GetPoll(id int) (*model.Poll, error) {
+	var poll model.Poll
+	var closesAt, closedAt sql.NullTime
+	err := txc.tx.QueryRow(`
+	SELECT id, group_id, message_id, question, multiple, anonymous, created_by, created_at, closes_at, closed_at
+	FROM poll
+	WHERE id = ?;
+	`, id).Scan(
+		&poll.Id,
+		&poll.GroupId,
+		&poll.MessageId,
+		&poll.Question,
+		&poll.Multiple,
+		&poll.Anonymous,
+		&poll.CreatedBy,
+		&poll.CreatedAt,
+		&closesAt,
+		&closedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errPollNotFound(id)
+		}
+		return nil, err
+	}
+	if closesAt.Valid {
+		poll.ClosesAt = &closesAt.Time
+	}
+	if closedAt.Valid {
+		poll.ClosedAt = &closedAt.Time
+	}
+
+	rows, err := txc.tx.Query(`
+	SELECT id, text
+	FROM poll_option
+	WHERE poll_id = ?
+	ORDER BY position;
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query options: %w", err)
+	}
+	defer rows.Close()
+
+	index := make(map[int]int)
+	for rows.Next() {
+		var option model.PollOption
+		if err = rows.Scan(&option.Id, &option.Text); err != nil {
+			return nil, fmt.Errorf("scan option: %w", err)
+		}
+		index[option.Id] = len(poll.Options)
+		poll.Options = append(poll.Options, option)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	votes, err := txc.tx.Query(`
+	SELECT option_id, user_id
+	FROM poll_vote
+	WHERE poll_id = ?
+	ORDER BY created_at, user_id;
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query votes: %w", err)
+	}
+	defer votes.Close()
+
+	for votes.Next() {
+		var optionId, userId int
+		if err = votes.Scan(&optionId, &userId); err != nil {
+			return nil, fmt.Errorf("scan vote: %w", err)
+		}
+		if i, ok := index[optionId]; ok {
+			poll.Options[i].Votes++
+			poll.Options[i].Voters = append(poll.Options[i].Voters, userId)
+		}
+	}
+	if err = votes.Err(); err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+func (txc *TxContacts) SetPollMessage(id int, messageId string) error {
+	_, err := txc.tx.Exec(`UPDATE poll SET message_id = ? WHERE id = ?;`, messageId, id)
+	return err
+}
+
+func (txc *TxContacts) ClosePoll(id int) error {
+	_, err := txc.tx.Exec(`
+	UPDATE poll
+	SET closed_at = datetime('now')
+	WHERE id = ? AND closed_at IS NULL;
+	`, id)
+	return err
+}
+
+// DeletePoll deletes the poll with its options and votes.
+func (txc *TxContacts) DeletePoll(id int) error {
+	queries := []string{
+		`DELETE FROM poll_vote WHERE poll_id = ?;`,
+		`DELETE FROM poll_option WHERE poll_id = ?;`,
+		`DELETE FROM poll WHERE id = ?;`,
+	}
+	for _, query := range queries {
+		if _, err := txc.tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetVotes returns the options of the poll the user voted for.
+func (txc *TxContacts) GetVotes(pollId, userId int) ([]int, error) {
+	rows, err := txc.tx.Query(`
+	SELECT option_id
+	FROM poll_vote
+	WHERE poll_id = ? AND user_id = ?
+	ORDER BY option_id;
+	`, pollId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	optionIds := make([]int, 0)
+	for rows.Next() {
+		var optionId int
+		if err = rows.Scan(&optionId); err != nil {
+			return nil, err
+		}
+		optionIds = append(optionIds, optionId)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return optionIds, nil
+}
+
+// CreateVote records a vote of the user. Voting twice for an option is a
+// no-op.
+func (txc *TxContacts) CreateVote(pollId, optionId, userId int) error {
+	_, err := txc.tx.Exec(`
+	INSERT INTO poll_vote (poll_id, option_id, user_id)
+	VALUES (?, ?, ?)
+	ON CONFLICT(option_id, user_id) DO NOTHING;
+	`, pollId, optionId, userId)
+	return err
+}
+
+func (txc *TxContacts) DeleteVote(pollId, optionId, userId int) error {
+	result, err := txc.tx.Exec(`
+	DELETE FROM poll_vote
+	WHERE poll_id = ? AND option_id = ? AND user_id = ?;
+	`, pollId, optionId, userId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindPoll,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("no vote for option '%d'", optionId),
+		}
+	}
+	return nil
+}
+
+// DeleteVotes deletes all votes of the user on the poll.
+func (txc *TxContacts) DeleteVotes(pollId, userId int) error {
+	_, err := txc.tx.Exec(`
+	DELETE FROM poll_vote
+	WHERE poll_id = ? AND user_id = ?;
+	`, pollId, userId)
+	return err
+}
+
+func errPollNotFound(id int) error {
+	return &store.Error{
+		Kind:    store.KindPoll,
+		Err:     store.ErrNotFound,
+		Message: fmt.Sprintf("poll '%d' not found", id),
+	}
+}
@@ -0,0 +1,184 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func (txc *TxContacts) CreateJob(job model.ScheduledJob) (*model.ScheduledJob, error) {
+	return scanJob(txc.tx.QueryRow(`
+	INSERT INTO scheduled_job (kind, user_id, group_id, message_id, content, run_at, status)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING `+jobColumns+`;
+	`, job.Kind, job.UserId, job.GroupId, job.MessageId, job.Content, job.RunAt.UTC(), model.JobPending))
+}
+
+const jobColumns = `id, kind, user_id, group_id, message_id, content, run_at, status, error, created_at`
+
+func scanJob(row interface{ Scan(...any) error }) (*model.ScheduledJob, error) {
+	var job model.ScheduledJob
+	err := row.Scan(
+		&job.Id,
+		&job.Kind,
+		&job.UserId,
+		&job.GroupId,
+		&job.MessageId,
+		&job.Content,
+		&job.RunAt,
+		&job.Status,
+		&job.Error,
+		&job.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (txc *TxContacts) GetJob(id int) (*model.ScheduledJob, error) {
+	job, err := scanJob(txc.tx.QueryRow(`
+	SELECT `+jobColumns+`
+	FROM scheduled_job
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errJobNotFound(id)
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJobs returns the jobs of the user with the status, next due first.
+func (txc *TxContacts) GetJobs(userId int, status model.JobStatus) ([]model.ScheduledJob, error) {
+	return txc.queryJobs(`
+	SELECT `+jobColumns+`
+	FROM scheduled_job
+	WHERE user_id = ? AND status = ?
+	ORDER BY run_at, id;
+	`, userId, status)
+}
+
+// GetDueJobs returns pending jobs whose time has come, oldest first.
+func (txc *TxContacts) GetDueJobs(limit int) ([]model.ScheduledJob, error) {
+	return txc.queryJobs(`
+	SELECT `+jobColumns+`
+	FROM scheduled_job
+	WHERE status = ? AND julianday(run_at) <= julianday('now')
+	ORDER BY run_at, id
+	LIMIT ?;
+	`, model.JobPending, limit)
+}
+
+func (txc *TxContacts) queryJobs(query string, args ...any) ([]model.ScheduledJob, error) {
+	rows, err := txc.tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]model.ScheduledJob, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// UpdateJob changes the content and time of a pending job.
+func (txc *TxContacts) UpdateJob(id int, content string, runAt time.Time) (*model.ScheduledJob, error) {
+	job, err := scanJob(txc.tx.QueryRow(`
+	UPDATE scheduled_job
+	SET content = ?, run_at = ?
+	WHERE id = ? AND status = ?
+	RETURNING `+jobColumns+`;
+	`, content, runAt.UTC(), id, model.JobPending))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errJobNotFound(id)
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// ClaimJob marks a pending job as done before it is run, so that it runs at
+// most once. It reports false if the job is no longer pending.
+func (txc *TxContacts) ClaimJob(id int) (bool, error) {
+	result, err := txc.tx.Exec(`
+	UPDATE scheduled_job
+	SET status = ?
+	WHERE id = ? AND status = ?;
+	`, model.JobDone, id, model.JobPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (txc *TxContacts) FailJob(id int, reason string) error {
+	_, err := txc.tx.Exec(`
+	UPDATE scheduled_job
+	SET status = ?, error = ?
+	WHERE id = ?;
+	`, model.JobFailed, reason, id)
+	return err
+}
+
+func (txc *TxContacts) SetJobStatus(id int, status model.JobStatus) error {
+	result, err := txc.tx.Exec(`
+	UPDATE scheduled_job
+	SET status = ?
+	WHERE id = ?;
+	`, status, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errJobNotFound(id)
+	}
+	return nil
+}
+
+func (txc *TxContacts) DeleteJob(id int) error {
+	result, err := txc.tx.Exec(`DELETE FROM scheduled_job WHERE id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errJobNotFound(id)
+	}
+	return nil
+}
+
+func errJobNotFound(id int) error {
+	return &store.Error{
+		Kind:    store.KindJob,
+		Err:     store.ErrNotFound,
+		Message: fmt.Sprintf("scheduled job '%d' not found", id),
+	}
+}
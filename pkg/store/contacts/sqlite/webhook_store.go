@@ -0,0 +1,393 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"crypto/sha3"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// CreateWebhook registers a webhook of the group with a new signing secret.
+func (txc *TxContacts) CreateWebhook(groupId, userId int, url string, events []string) (*model.Webhook, error) {
+	eventsJSON, err := json.Marshal(events)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhook(txc.tx.QueryRow(`
+	INSERT INTO webhook (group_id, url, secret, events, created_by)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING `+webhookColumns+`;
+	`, groupId, url, rand.Text(), string(eventsJSON), userId))
+}
+
+const webhookColumns = `id, group_id, url, secret, events, failures, disabled_at, created_by, created_at`
+
+func scanWebhook(row interface{ Scan(...any) error }) (*model.Webhook, error) {
+	var webhook model.Webhook
+	var events string
+	var disabledAt sql.NullTime
+	err := row.Scan(
+		&webhook.Id,
+		&webhook.GroupId,
+		&webhook.URL,
+		&webhook.Secret,
+		&events,
+		&webhook.Failures,
+		&disabledAt,
+		&webhook.CreatedBy,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(events), &webhook.Events); err != nil {
+		return nil, fmt.Errorf("events: %w", err)
+	}
+	if disabledAt.Valid {
+		webhook.DisabledAt = &disabledAt.Time
+	}
+	return &webhook, nil
+}
+
+func (txc *TxContacts) GetWebhook(id int) (*model.Webhook, error) {
+	webhook, err := scanWebhook(txc.tx.QueryRow(`
+	SELECT `+webhookColumns+`
+	FROM webhook
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errWebhookNotFound(id)
+		}
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (txc *TxContacts) GetWebhooks(groupId int) ([]model.Webhook, error) {
+	rows, err := txc.tx.Query(`
+	SELECT `+webhookColumns+`
+	FROM webhook
+	WHERE group_id = ?
+	ORDER BY id;
+	`, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]model.Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return webhooks, nil
+}
+
+// SetWebhookDisabled disables the webhook, or enables it again with its
+// failure count reset.
+func (txc *TxContacts) SetWebhookDisabled(id int, disabled bool) error {
+	query := `UPDATE webhook SET disabled_at = NULL, failures = 0 WHERE id = ?;`
+	if disabled {
+		query = `UPDATE webhook SET disabled_at = COALESCE(disabled_at, datetime('now')) WHERE id = ?;`
+	}
+	result, err := txc.tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		if err != nil {
+			return err
+		}
+		return errWebhookNotFound(id)
+	}
+	return nil
+}
+
+// SetWebhookFailures records the number of deliveries given up in a row.
+func (txc *TxContacts) SetWebhookFailures(id, failures int) error {
+	_, err := txc.tx.Exec(`
+	UPDATE webhook
+	SET failures = ?
+	WHERE id = ?;
+	`, failures, id)
+	return err
+}
+
+// DeleteWebhook deletes the webhook and its delivery log.
+func (txc *TxContacts) DeleteWebhook(id int) error {
+	result, err := txc.tx.Exec(`DELETE FROM webhook WHERE id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		if err != nil {
+			return err
+		}
+		return errWebhookNotFound(id)
+	}
+	_, err = txc.tx.Exec(`DELETE FROM webhook_delivery WHERE webhook_id = ?;`, id)
+	return err
+}
+
+func errWebhookNotFound(id int) error {
+	return &store.Error{
+		Kind:    store.KindWebhook,
+		Err:     store.ErrNotFound,
+		Message: fmt.Sprintf("webhook '%d' not found", id),
+	}
+}
+
+// CreateDelivery queues the payload for delivery to the webhook.
+func (txc *TxContacts) CreateDelivery(webhookId int, event, payload string) (*model.WebhookDelivery, error) {
+	return scanDelivery(txc.tx.QueryRow(`
+	INSERT INTO webhook_delivery (webhook_id, event, payload, next_attempt_at)
+	VALUES (?, ?, ?, ?)
+	RETURNING `+deliveryColumns+`;
+	`, webhookId, event, payload, time.Now().UTC()))
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at, status_code, error, created_at, delivered_at`
+
+func scanDelivery(row interface{ Scan(...any) error }) (*model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(
+		&delivery.Id,
+		&delivery.WebhookId,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.StatusCode,
+		&delivery.Error,
+		&delivery.CreatedAt,
+		&deliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return &delivery, nil
+}
+
+func (txc *TxContacts) queryDeliveries(query string, args ...any) ([]model.WebhookDelivery, error) {
+	rows, err := txc.tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]model.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return deliveries, nil
+}
+
+// GetDueDeliveries returns pending deliveries of enabled webhooks whose next
+// attempt is due, oldest first.
+func (txc *TxContacts) GetDueDeliveries(limit int) ([]model.WebhookDelivery, error) {
+	return txc.queryDeliveries(`
+	SELECT `+deliveryColumns+`
+	FROM webhook_delivery
+	WHERE status = ? AND julianday(next_attempt_at) <= julianday('now') AND webhook_id IN (
+		SELECT id FROM webhook WHERE disabled_at IS NULL
+	)
+	ORDER BY next_attempt_at, id
+	LIMIT ?;
+	`, model.DeliveryPending, limit)
+}
+
+// GetDeliveries returns the latest deliveries of the webhook, newest first.
+func (txc *TxContacts) GetDeliveries(webhookId, limit int) ([]model.WebhookDelivery, error) {
+	return txc.queryDeliveries(`
+	SELECT `+deliveryColumns+`
+	FROM webhook_delivery
+	WHERE webhook_id = ?
+	ORDER BY id DESC
+	LIMIT ?;
+	`, webhookId, limit)
+}
+
+// UpdateDelivery records the outcome of a delivery attempt.
+func (txc *TxContacts) UpdateDelivery(delivery model.WebhookDelivery) error {
+	var deliveredAt sql.NullTime
+	if delivery.DeliveredAt != nil {
+		deliveredAt = sql.NullTime{Time: delivery.DeliveredAt.UTC(), Valid: true}
+	}
+	_, err := txc.tx.Exec(`
+	UPDATE webhook_delivery
+	SET status = ?, attempts = ?, next_attempt_at = ?, status_code = ?, error = ?, delivered_at = ?
+	WHERE id = ?;
+	`, delivery.Status, delivery.Attempts, delivery.NextAttemptAt.UTC(), delivery.StatusCode, delivery.Error, deliveredAt, delivery.Id)
+	return err
+}
+
+func hashText(s string) string {
+	sum := sha3.Sum256([]byte(s))
+	return base32.HexEncoding.EncodeToString(sum[:])
+}
+
+// CreateIncomingWebhook creates an incoming webhook of the group with a new
+// token. Only the hash of the token is stored.
+func (txc *TxContacts) CreateIncomingWebhook(groupId, userId int, name string) (*model.IncomingWebhook, error) {
+	token := rand.Text()
+	webhook, err := scanIncomingWebhook(txc.tx.QueryRow(`
+	INSERT INTO incoming_webhook (group_id, name, hash, created_by)
+	VALUES (?, ?, ?, ?)
+	RETURNING `+incomingWebhookColumns+`;
+	`, groupId, name, hashText(token), userId))
+	if err != nil {
+		return nil, err
+	}
+	webhook.Token = token
+	return webhook, nil
+}
+
+const incomingWebhookColumns = `id, group_id, name, created_by, created_at, last_used_at`
+
+func scanIncomingWebhook(row interface{ Scan(...any) error }) (*model.IncomingWebhook, error) {
+	var webhook model.IncomingWebhook
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&webhook.Id, &webhook.GroupId, &webhook.Name, &webhook.CreatedBy, &webhook.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		webhook.LastUsedAt = &lastUsedAt.Time
+	}
+	return &webhook, nil
+}
+
+func (txc *TxContacts) GetIncomingWebhook(id int) (*model.IncomingWebhook, error) {
+	webhook, err := scanIncomingWebhook(txc.tx.QueryRow(`
+	SELECT `+incomingWebhookColumns+`
+	FROM incoming_webhook
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errWebhookNotFound(id)
+		}
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (txc *TxContacts) GetIncomingWebhookByToken(token string) (*model.IncomingWebhook, error) {
+	webhook, err := scanIncomingWebhook(txc.tx.QueryRow(`
+	SELECT `+incomingWebhookColumns+`
+	FROM incoming_webhook
+	WHERE hash = ?;
+	`, hashText(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindWebhook,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid webhook token",
+			}
+		}
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (txc *TxContacts) GetIncomingWebhooks(groupId int) ([]model.IncomingWebhook, error) {
+	rows, err := txc.tx.Query(`
+	SELECT `+incomingWebhookColumns+`
+	FROM incoming_webhook
+	WHERE group_id = ?
+	ORDER BY id;
+	`, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]model.IncomingWebhook, 0)
+	for rows.Next() {
+		webhook, err := scanIncomingWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return webhooks, nil
+}
+
+// RotateIncomingWebhook replaces the token of the webhook. The old token
+// stops working immediately.
+func (txc *TxContacts) RotateIncomingWebhook(id int) (string, error) {
+	token := rand.Text()
+	result, err := txc.tx.Exec(`
+	UPDATE incoming_webhook
+	SET hash = ?
+	WHERE id = ?;
+	`, hashText(token), id)
+	if err != nil {
+		return "", err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		if err != nil {
+			return "", err
+		}
+		return "", errWebhookNotFound(id)
+	}
+	return token, nil
+}
+
+// TouchIncomingWebhook records use of the webhook.
+// Writes are throttled to at most one per minute.
+func (txc *TxContacts) TouchIncomingWebhook(id int) error {
+	_, err := txc.tx.Exec(`
+	UPDATE incoming_webhook
+	SET last_used_at = datetime('now')
+	WHERE id = ? AND (
+		last_used_at IS NULL OR
+		datetime(last_used_at) < datetime('now', '-1 minute')
+	);
+	`, id)
+	return err
+}
+
+func (txc *TxContacts) DeleteIncomingWebhook(id int) error {
+	result, err := txc.tx.Exec(`DELETE FROM incoming_webhook WHERE id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		if err != nil {
+			return err
+		}
+		return errWebhookNotFound(id)
+	}
+	return nil
+}
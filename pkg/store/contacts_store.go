@@ -1,6 +1,10 @@
 package store
 
-import "github.com/elug3/gochat/pkg/model"
+import (
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+)
 
 type ContactsStore interface {
 	Begin() (TxContacts, error)
@@ -15,14 +19,103 @@ type TxContacts interface {
 	GetGroups(userId int) ([]model.Group, error)
 	GetGroup(id int) (*model.Group, error)
 	CreateGroup(name string) (*model.Group, error)
+	SetRetention(id, retention int) error
+	UpdateGroup(group model.Group) error
+	SearchGroups(query string, limit int) ([]model.Group, error)
 	DeleteGroup(id int) error
 
 	GetMembers(groupId int) ([]model.Member, error)
 	GetMember(groupId, userId int) (*model.Member, error)
 	CreateMember(groupId, userId int, role model.Role) (*model.Member, error)
+	SetMemberRole(groupId, userId int, role model.Role) error
 	DeleteMember(groupId, userId int) error
 	MemberExists(groupId, userId int) (bool, error)
 
 	CreateProfile(userId int, name string) (*model.Profile, error)
+	GetProfile(userId int) (*model.Profile, error)
 	DeleteProfile(userId int) error
+	UpdateLastSeen(userId int) error
+	SetContactsOnly(userId int, contactsOnly bool) error
+	SetBot(userId int) error
+
+	GetDirectGroup(userId, peerId int) (*model.Group, error)
+	CreateDirectGroup(userId, peerId int) (*model.Group, error)
+
+	GetContacts(userId int) ([]model.Contact, error)
+	ContactExists(userId, contactId int) (bool, error)
+	CreateContact(userId, contactId int) error
+	DeleteContact(userId, contactId int) error
+
+	GetContactRequest(id int) (*model.ContactRequest, error)
+	GetContactRequests(userId int) ([]model.ContactRequest, error)
+	GetPendingContactRequest(fromId, toId int) (*model.ContactRequest, error)
+	CreateContactRequest(fromId, toId int) (*model.ContactRequest, error)
+	UpdateContactRequest(id int, status model.ContactRequestStatus) error
+	RejectContactRequests(userId, peerId int) error
+
+	GetJoinRequest(id int) (*model.JoinRequest, error)
+	GetJoinRequests(groupId int) ([]model.JoinRequest, error)
+	PendingJoinRequestExists(groupId, userId int) (bool, error)
+	CreateJoinRequest(groupId, userId int) (*model.JoinRequest, error)
+	UpdateJoinRequest(id int, status model.JoinRequestStatus, decidedBy int) error
+	DeleteJoinRequests(userId int) error
+	DeleteGroupJoinRequests(groupId int) error
+
+	GetBlocks(userId int) ([]model.Block, error)
+	BlockExists(userId, blockedId int) (bool, error)
+	CreateBlock(userId, blockedId int) (*model.Block, error)
+	DeleteBlock(userId, blockedId int) error
+
+	GetMute(userId, groupId int) (*model.Mute, error)
+	SetMute(userId, groupId int, until *time.Time) (*model.Mute, error)
+	DeleteMute(userId, groupId int) error
+
+	GetRestriction(userId, groupId int) (*model.Restriction, error)
+	SetRestriction(userId, groupId, createdBy int, until *time.Time) (*model.Restriction, error)
+	DeleteRestriction(userId, groupId int) error
+
+	CreateWebhook(groupId, userId int, url string, events []string) (*model.Webhook, error)
+	GetWebhook(id int) (*model.Webhook, error)
+	GetWebhooks(groupId int) ([]model.Webhook, error)
+	SetWebhookDisabled(id int, disabled bool) error
+	SetWebhookFailures(id, failures int) error
+	DeleteWebhook(id int) error
+	CreateDelivery(webhookId int, event, payload string) (*model.WebhookDelivery, error)
+	GetDueDeliveries(limit int) ([]model.WebhookDelivery, error)
+	GetDeliveries(webhookId, limit int) ([]model.WebhookDelivery, error)
+	UpdateDelivery(delivery model.WebhookDelivery) error
+
+	CreateIncomingWebhook(groupId, userId int, name string) (*model.IncomingWebhook, error)
+	GetIncomingWebhook(id int) (*model.IncomingWebhook, error)
+	GetIncomingWebhookByToken(token string) (*model.IncomingWebhook, error)
+	GetIncomingWebhooks(groupId int) ([]model.IncomingWebhook, error)
+	RotateIncomingWebhook(id int) (string, error)
+	TouchIncomingWebhook(id int) error
+	DeleteIncomingWebhook(id int) error
+
+	CreateCommand(command model.Command) (*model.Command, error)
+	GetCommand(id int) (*model.Command, error)
+	GetCommandByName(groupId int, name string) (*model.Command, error)
+	GetCommands(groupId int) ([]model.Command, error)
+	DeleteCommand(id int) error
+
+	CreatePoll(poll model.Poll) (*model.Poll, error)
+	GetPoll(id int) (*model.Poll, error)
+	SetPollMessage(id int, messageId string) error
+	ClosePoll(id int) error
+	DeletePoll(id int) error
+	GetVotes(pollId, userId int) ([]int, error)
+	CreateVote(pollId, optionId, userId int) error
+	DeleteVote(pollId, optionId, userId int) error
+	DeleteVotes(pollId, userId int) error
+
+	CreateJob(job model.ScheduledJob) (*model.ScheduledJob, error)
+	GetJob(id int) (*model.ScheduledJob, error)
+	GetJobs(userId int, status model.JobStatus) ([]model.ScheduledJob, error)
+	GetDueJobs(limit int) ([]model.ScheduledJob, error)
+	UpdateJob(id int, content string, runAt time.Time) (*model.ScheduledJob, error)
+	ClaimJob(id int) (bool, error)
+	FailJob(id int, reason string) error
+	SetJobStatus(id int, status model.JobStatus) error
+	DeleteJob(id int) error
 }
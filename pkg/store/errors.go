@@ -11,15 +11,31 @@ var (
 	ErrNotFound         = errors.New("Notfound")
 	ErrExists           = errors.New("AlreadyExists")
 	ErrBadRequest       = errors.New("BadRequest")
+	ErrExpired          = errors.New("Expired")
+	ErrTooManyRequests  = errors.New("TooManyRequests")
 )
 
 type Kind string
 
 const (
-	KindUser    = "user"
-	KindProfile = "profile"
-	KindGroup   = "gruop"
-	KindMember  = "member"
+	KindUser     = "user"
+	KindToken    = "token"
+	KindSession  = "session"
+	KindTOTP     = "totp"
+	KindIdentity = "identity"
+	KindProfile  = "profile"
+	KindGroup    = "gruop"
+	KindMember   = "member"
+	KindContact  = "contact"
+	KindRequest  = "request"
+	KindBlock    = "block"
+	KindMute     = "mute"
+	KindRestrict = "restriction"
+	KindMessage  = "message"
+	KindWebhook  = "webhook"
+	KindCommand  = "command"
+	KindPoll     = "poll"
+	KindJob      = "job"
 )
 
 type Error struct {
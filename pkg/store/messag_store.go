@@ -1,5 +1,30 @@
 package store
 
+import (
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+)
+
 type MessageStore interface {
-	CreateMessage()
+	CreateMessage(msg model.Message) error
+	GetMessages(convId string) ([]model.Message, error)
+	GetMessage(id string) (*model.Message, error)
+	UpdateMessage(id, content string) (*model.Message, error)
+	DeleteMessage(id string) error
+	// SetPinned pins the message for the user, or unpins it if userId is 0.
+	SetPinned(id string, userId int) (*model.Message, error)
+	GetPinnedMessages(convId string) ([]model.Message, error)
+	// ExpireMessages replaces up to limit messages that expired before now
+	// with tombstones and returns them.
+	ExpireMessages(now time.Time, limit int) ([]model.Message, error)
+	CountExpiredMessages(now time.Time) (int, error)
+	// DeleteMessagesBefore deletes up to limit messages created before the
+	// time and returns them.
+	DeleteMessagesBefore(before time.Time, limit int) ([]model.Message, error)
+	CountMessagesBefore(before time.Time) (int, error)
+	GetMessagesBySender(senderId string) ([]model.Message, error)
+	// AnonymizeMessages replaces the sender of all messages of the sender
+	// and returns how many were changed.
+	AnonymizeMessages(senderId, anonymousId string) (int, error)
 }
@@ -1,15 +1,23 @@
 package scylladb
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
 	"github.com/gocql/gocql"
 	"github.com/google/uuid"
 )
 
+// messageLimit is the number of latest messages returned by GetMessages.
+const messageLimit = 50
+
+var _ store.MessageStore = (*MessageStore)(nil)
+
 type MessageStore struct {
 	cluster *gocql.ClusterConfig
 }
@@ -35,16 +43,21 @@ func initdb(cluster *gocql.ClusterConfig, keyspace string) error {
 	if err != nil {
 		return err
 	}
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
 	if !exists {
-		session, err := cluster.CreateSession()
-		if err != nil {
-			return err
-		}
 		if err = createTable(session); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err = ensureColumns(session, keyspace); err != nil {
+		return fmt.Errorf("ensureColumns: %w", err)
+	}
+	return createIndexes(session)
 }
 
 // ensureKeyspace ensures that the given keyspace exists in the cluster.
@@ -97,8 +110,8 @@ func createTable(session *gocql.Session) error {
 	err := session.Query(`
 	CREATE TABLE messages (
 	id UUID,
-	conversation_id UUID,
-	sender_id UUID,
+	conversation_id TEXT,
+	sender_id TEXT,
 	content TEXT,
 	created_at TIMESTAMP,
 	PRIMARY KEY (id, conversation_id)
@@ -106,6 +119,63 @@ func createTable(session *gocql.Session) error {
 	return err
 }
 
+// ensureColumns adds the columns added after the initial schema to the
+// messages table.
+func ensureColumns(session *gocql.Session, keyspace string) error {
+	columns := []struct{ name, def string }{
+		{"bot", "BOOLEAN"},
+		{"edited_at", "TIMESTAMP"},
+		{"username", "TEXT"},
+		{"attachments", "TEXT"},
+		{"type", "TEXT"},
+		{"poll_id", "INT"},
+		{"pinned_by", "INT"},
+		{"pinned_at", "TIMESTAMP"},
+		{"expires_at", "TIMESTAMP"},
+		{"deleted_at", "TIMESTAMP"},
+		{"system", "TEXT"},
+	}
+	existing := make(map[string]bool)
+	scanner := session.Query(`
+	SELECT column_name
+	FROM system_schema.columns
+	WHERE keyspace_name = ? AND table_name = 'messages';
+	`, keyspace).Iter().Scanner()
+	for scanner.Next() {
+		var name string
+		if err := scanner.Scan(&name); err != nil {
+			return err
+		}
+		existing[name] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, col := range columns {
+		if existing[col.name] {
+			continue
+		}
+		if err := session.Query(fmt.Sprintf(`ALTER TABLE messages ADD %s %s;`, col.name, col.def)).Exec(); err != nil {
+			errs = append(errs, fmt.Errorf("add column %s: %w", col.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// createIndexes indexes the columns the store looks messages up by besides
+// their id.
+func createIndexes(session *gocql.Session) error {
+	errs := make([]error, 0)
+	for _, column := range []string{"conversation_id", "sender_id"} {
+		if err := session.Query(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS ON messages (%s);`, column)).Exec(); err != nil {
+			errs = append(errs, fmt.Errorf("create index %s: %w", column, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func createKeyspace(session *gocql.Session, keyspace string) error {
 	sq := fmt.Sprintf(`CREATE KEYSPACE %s
 	WITH replication = {
@@ -117,48 +187,450 @@ func createKeyspace(session *gocql.Session, keyspace string) error {
 	return err
 }
 
-func (store *MessageStore) GetMessages(convId string) ([]model.Message, error) {
-	session, err := store.cluster.CreateSession()
+const messageColumns = `id, conversation_id, sender_id, content, created_at, bot, edited_at, username, attachments, type, poll_id, pinned_by, pinned_at, expires_at, deleted_at, system`
+
+func scanMessage(row interface{ Scan(...any) error }) (*model.Message, error) {
+	var msg model.Message
+	var attachments, system string
+	err := row.Scan(
+		&msg.Id,
+		&msg.ConvId,
+		&msg.SenderId,
+		&msg.Content,
+		&msg.CreatedAt,
+		&msg.Bot,
+		&msg.EditedAt,
+		&msg.Username,
+		&attachments,
+		&msg.Type,
+		&msg.PollId,
+		&msg.PinnedBy,
+		&msg.PinnedAt,
+		&msg.ExpiresAt,
+		&msg.DeletedAt,
+		&system,
+	)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
+	msg.Pinned = msg.PinnedAt != nil
+	if attachments != "" {
+		if err = json.Unmarshal([]byte(attachments), &msg.Attachments); err != nil {
+			return nil, fmt.Errorf("attachments: %w", err)
+		}
+	}
+	if system != "" {
+		msg.System = new(model.SystemPayload)
+		if err = json.Unmarshal([]byte(system), msg.System); err != nil {
+			return nil, fmt.Errorf("system: %w", err)
+		}
+	}
+	return &msg, nil
+}
 
-	scanner := session.Query(`
-	SELECT id, sender_id, content, created_at
-	FROM messages
-	WHERE conversation_id = ?
-	LIMIT 50
-	`, convId).Iter().Scanner()
+// queryMessages returns the messages matched by the query in no particular
+// order.
+func queryMessages(query *gocql.Query) ([]model.Message, error) {
+	scanner := query.Iter().Scanner()
 
 	msgs := make([]model.Message, 0)
 	for scanner.Next() {
-		var msg model.Message
-		if err = scanner.Scan(&msg.ConvId, &msg.SenderId, &msg.Content, &msg.CreatedAt); err != nil {
-			return nil, err
+		msg, err := scanMessage(scanner)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
 		}
-		msgs = append(msgs, msg)
+		msgs = append(msgs, *msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
-	if err = scanner.Err(); err != nil {
+	return msgs, nil
+}
+
+func sortByCreatedAt(msgs []model.Message) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
+	})
+}
+
+// GetMessages returns the latest messages of the conversation, oldest first.
+func (store *MessageStore) GetMessages(convId string) ([]model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
 		return nil, err
 	}
+	defer session.Close()
 
+	msgs, err := queryMessages(session.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE conversation_id = ?;
+	`, convId))
+	if err != nil {
+		return nil, err
+	}
+	sortByCreatedAt(msgs)
+	if len(msgs) > messageLimit {
+		msgs = msgs[len(msgs)-messageLimit:]
+	}
 	return msgs, nil
 }
 
 func (store *MessageStore) CreateMessage(msg model.Message) error {
+	attachments, err := marshalAttachments(msg.Attachments)
+	if err != nil {
+		return err
+	}
+	system, err := marshalSystem(msg.System)
+	if err != nil {
+		return err
+	}
 	session, err := store.cluster.CreateSession()
 	if err != nil {
 		return err
 	}
+	defer session.Close()
+
+	return session.Query(`
+	INSERT INTO messages (id, conversation_id, sender_id, content, created_at, bot, username, attachments, type, poll_id, expires_at, system)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`, msg.Id, msg.ConvId, msg.SenderId, msg.Content, msg.CreatedAt, msg.Bot, msg.Username, attachments, msg.Type, msg.PollId, msg.ExpiresAt, system).Exec()
+}
+
+// marshalAttachments encodes attachments for the attachments column, which
+// is empty for messages without any.
+func marshalAttachments(attachments []model.Attachment) (string, error) {
+	if len(attachments) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(attachments)
+	if err != nil {
+		return "", fmt.Errorf("attachments: %w", err)
+	}
+	return string(b), nil
+}
+
+func marshalSystem(system *model.SystemPayload) (string, error) {
+	if system == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(system)
+	if err != nil {
+		return "", fmt.Errorf("system: %w", err)
+	}
+	return string(b), nil
+}
+
+func (store *MessageStore) GetMessage(id string) (*model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return getMessage(session, id)
+}
+
+func getMessage(session *gocql.Session, id string) (*model.Message, error) {
+	msg, err := scanMessage(session.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return nil, errMessageNotFound(id)
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// UpdateMessage replaces the content of the message and stamps it as edited.
+func (store *MessageStore) UpdateMessage(id, content string) (*model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	msg, err := getMessage(session, id)
+	if err != nil {
+		return nil, err
+	}
+	editedAt := time.Now().UTC()
 	err = session.Query(`
-	INSERT INTO messages (id, conversation_id, sender_id, content, created_at)
-	VALUES (?, ?, ?, ?, ?);
-	`, msg.Id, msg.ConvId, msg.SenderId, msg.Content, msg.CreatedAt).Exec()
+	UPDATE messages
+	SET content = ?, edited_at = ?
+	WHERE id = ? AND conversation_id = ?;
+	`, content, editedAt, msg.Id, msg.ConvId).Exec()
+	if err != nil {
+		return nil, err
+	}
+	msg.Content = content
+	msg.EditedAt = &editedAt
+	return msg, nil
+}
+
+func (store *MessageStore) DeleteMessage(id string) error {
+	session, err := store.cluster.CreateSession()
 	if err != nil {
 		return err
 	}
-	return nil
+	defer session.Close()
+
+	msg, err := getMessage(session, id)
+	if err != nil {
+		return err
+	}
+	return deleteMessage(session, msg)
+}
+
+func deleteMessage(session *gocql.Session, msg *model.Message) error {
+	return session.Query(`
+	DELETE FROM messages
+	WHERE id = ? AND conversation_id = ?;
+	`, msg.Id, msg.ConvId).Exec()
+}
+
+// SetPinned pins the message for the user, or unpins it if userId is 0.
+func (store *MessageStore) SetPinned(id string, userId int) (*model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	msg, err := getMessage(session, id)
+	if err != nil {
+		return nil, err
+	}
+	var pinnedAt *time.Time
+	if userId != 0 {
+		now := time.Now().UTC()
+		pinnedAt = &now
+	}
+	err = session.Query(`
+	UPDATE messages
+	SET pinned_by = ?, pinned_at = ?
+	WHERE id = ? AND conversation_id = ?;
+	`, userId, pinnedAt, msg.Id, msg.ConvId).Exec()
+	if err != nil {
+		return nil, err
+	}
+	msg.PinnedBy = userId
+	msg.PinnedAt = pinnedAt
+	msg.Pinned = pinnedAt != nil
+	return msg, nil
+}
+
+// GetPinnedMessages returns the pinned messages of the conversation, latest
+// pinned first.
+func (store *MessageStore) GetPinnedMessages(convId string) ([]model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	// CQL cannot filter on null columns, so pinned_by tells pinned messages
+	// apart.
+	msgs, err := queryMessages(session.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE conversation_id = ? AND pinned_by > 0
+	ALLOW FILTERING;
+	`, convId))
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if !msgs[i].PinnedAt.Equal(*msgs[j].PinnedAt) {
+			return msgs[i].PinnedAt.After(*msgs[j].PinnedAt)
+		}
+		return msgs[i].CreatedAt.After(msgs[j].CreatedAt)
+	})
+	return msgs, nil
+}
+
+// expiredMessages returns the messages that expired before now and are not
+// tombstones yet, oldest expiry first.
+func expiredMessages(session *gocql.Session, now time.Time) ([]model.Message, error) {
+	msgs, err := queryMessages(session.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE expires_at <= ?
+	ALLOW FILTERING;
+	`, now.UTC()))
+	if err != nil {
+		return nil, err
+	}
+	expired := make([]model.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.DeletedAt == nil {
+			expired = append(expired, msg)
+		}
+	}
+	sort.SliceStable(expired, func(i, j int) bool {
+		return expired[i].ExpiresAt.Before(*expired[j].ExpiresAt)
+	})
+	return expired, nil
+}
+
+// ExpireMessages replaces up to limit messages that expired before now with
+// tombstones and returns them. Tombstones keep their id, sender and time but
+// lose their content, attachments and pin.
+func (store *MessageStore) ExpireMessages(now time.Time, limit int) ([]model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	msgs, err := expiredMessages(session, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	deletedAt := now.UTC()
+	for i := range msgs {
+		err = session.Query(`
+		UPDATE messages
+		SET content = '', attachments = '', system = '', pinned_by = 0, pinned_at = null, deleted_at = ?
+		WHERE id = ? AND conversation_id = ?;
+		`, deletedAt, msgs[i].Id, msgs[i].ConvId).Exec()
+		if err != nil {
+			return nil, err
+		}
+		msgs[i].Content = ""
+		msgs[i].Attachments = nil
+		msgs[i].System = nil
+		msgs[i].Pinned = false
+		msgs[i].PinnedBy = 0
+		msgs[i].PinnedAt = nil
+		msgs[i].DeletedAt = &deletedAt
+	}
+	return msgs, nil
+}
+
+// CountExpiredMessages returns how many messages expired before now and are
+// not tombstones yet.
+func (store *MessageStore) CountExpiredMessages(now time.Time) (int, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	msgs, err := expiredMessages(session, now)
+	return len(msgs), err
+}
+
+func messagesBefore(session *gocql.Session, before time.Time) ([]model.Message, error) {
+	msgs, err := queryMessages(session.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE created_at < ?
+	ALLOW FILTERING;
+	`, before.UTC()))
+	if err != nil {
+		return nil, err
+	}
+	sortByCreatedAt(msgs)
+	return msgs, nil
+}
+
+// DeleteMessagesBefore deletes up to limit messages created before the time,
+// oldest first, and returns them.
+func (store *MessageStore) DeleteMessagesBefore(before time.Time, limit int) ([]model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	msgs, err := messagesBefore(session, before)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	for i := range msgs {
+		if err = deleteMessage(session, &msgs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+func (store *MessageStore) CountMessagesBefore(before time.Time) (int, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	msgs, err := messagesBefore(session, before)
+	return len(msgs), err
+}
+
+// GetMessagesBySender returns all messages of the sender, oldest first.
+func (store *MessageStore) GetMessagesBySender(senderId string) ([]model.Message, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return messagesBySender(session, senderId)
+}
+
+func messagesBySender(session *gocql.Session, senderId string) ([]model.Message, error) {
+	msgs, err := queryMessages(session.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE sender_id = ?;
+	`, senderId))
+	if err != nil {
+		return nil, err
+	}
+	sortByCreatedAt(msgs)
+	return msgs, nil
+}
+
+func (store *MessageStore) AnonymizeMessages(senderId, anonymousId string) (int, error) {
+	session, err := store.cluster.CreateSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	msgs, err := messagesBySender(session, senderId)
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range msgs {
+		err = session.Query(`
+		UPDATE messages
+		SET sender_id = ?, username = ''
+		WHERE id = ? AND conversation_id = ?;
+		`, anonymousId, msg.Id, msg.ConvId).Exec()
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+func errMessageNotFound(id string) error {
+	return &store.Error{
+		Kind:    store.KindMessage,
+		Err:     store.ErrNotFound,
+		Message: fmt.Sprintf("message '%s' not found", id),
+	}
 }
 
 func newMessage(senderId, convId string, content string) (*model.Message, error) {
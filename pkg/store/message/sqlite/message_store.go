@@ -0,0 +1,420 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/config"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/store/schema"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// messageLimit is the number of latest messages returned by GetMessages.
+const messageLimit = 50
+
+type MessageStore struct {
+	db *sql.DB
+}
+
+func NewMessageStore(cfg *config.Config) (*MessageStore, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err = initDB(db); err != nil {
+		return nil, err
+	}
+	store := MessageStore{db: db}
+	return &store, nil
+}
+
+func (store *MessageStore) CreateMessage(msg model.Message) error {
+	attachments, err := marshalAttachments(msg.Attachments)
+	if err != nil {
+		return err
+	}
+	system, err := marshalSystem(msg.System)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(`
+	INSERT INTO messages (id, conversation_id, sender_id, content, created_at, bot, username, attachments, type, poll_id, expires_at, system)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`, msg.Id, msg.ConvId, msg.SenderId, msg.Content, msg.CreatedAt.UTC(), msg.Bot, msg.Username, attachments, msg.Type, msg.PollId, utcTime(msg.ExpiresAt), system)
+	return err
+}
+
+// utcTime converts an optional time for a nullable column.
+func utcTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// marshalAttachments encodes attachments for the attachments column, which
+// is empty for messages without any.
+func marshalAttachments(attachments []model.Attachment) (string, error) {
+	if len(attachments) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(attachments)
+	if err != nil {
+		return "", fmt.Errorf("attachments: %w", err)
+	}
+	return string(b), nil
+}
+
+func marshalSystem(system *model.SystemPayload) (string, error) {
+	if system == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(system)
+	if err != nil {
+		return "", fmt.Errorf("system: %w", err)
+	}
+	return string(b), nil
+}
+
+// GetMessages returns the latest messages of the conversation, oldest first.
+func (store *MessageStore) GetMessages(convId string) ([]model.Message, error) {
+	rows, err := store.db.Query(`
+	SELECT `+messageColumns+`
+	FROM (
+		SELECT * FROM messages
+		WHERE conversation_id = ?
+		ORDER BY created_at DESC
+		LIMIT ?
+	)
+	ORDER BY created_at;
+	`, convId, messageLimit)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+func scanMessages(rows *sql.Rows) ([]model.Message, error) {
+	defer rows.Close()
+
+	msgs := make([]model.Message, 0)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return msgs, nil
+}
+
+const messageColumns = `id, conversation_id, sender_id, content, created_at, bot, edited_at, username, attachments, type, poll_id, pinned_by, pinned_at, expires_at, deleted_at, system`
+
+func scanMessage(row interface{ Scan(...any) error }) (*model.Message, error) {
+	var msg model.Message
+	var editedAt, pinnedAt, expiresAt, deletedAt sql.NullTime
+	var attachments, system string
+	err := row.Scan(
+		&msg.Id,
+		&msg.ConvId,
+		&msg.SenderId,
+		&msg.Content,
+		&msg.CreatedAt,
+		&msg.Bot,
+		&editedAt,
+		&msg.Username,
+		&attachments,
+		&msg.Type,
+		&msg.PollId,
+		&msg.PinnedBy,
+		&pinnedAt,
+		&expiresAt,
+		&deletedAt,
+		&system,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if editedAt.Valid {
+		msg.EditedAt = &editedAt.Time
+	}
+	if pinnedAt.Valid {
+		msg.Pinned = true
+		msg.PinnedAt = &pinnedAt.Time
+	}
+	if expiresAt.Valid {
+		msg.ExpiresAt = &expiresAt.Time
+	}
+	if deletedAt.Valid {
+		msg.DeletedAt = &deletedAt.Time
+	}
+	if attachments != "" {
+		if err = json.Unmarshal([]byte(attachments), &msg.Attachments); err != nil {
+			return nil, fmt.Errorf("attachments: %w", err)
+		}
+	}
+	if system != "" {
+		msg.System = new(model.SystemPayload)
+		if err = json.Unmarshal([]byte(system), msg.System); err != nil {
+			return nil, fmt.Errorf("system: %w", err)
+		}
+	}
+	return &msg, nil
+}
+
+func (store *MessageStore) GetMessage(id string) (*model.Message, error) {
+	msg, err := scanMessage(store.db.QueryRow(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE id = ?;
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errMessageNotFound(id)
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// UpdateMessage replaces the content of the message and stamps it as edited.
+func (store *MessageStore) UpdateMessage(id, content string) (*model.Message, error) {
+	msg, err := scanMessage(store.db.QueryRow(`
+	UPDATE messages
+	SET content = ?, edited_at = ?
+	WHERE id = ?
+	RETURNING `+messageColumns+`;
+	`, content, time.Now().UTC(), id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errMessageNotFound(id)
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (store *MessageStore) DeleteMessage(id string) error {
+	result, err := store.db.Exec(`DELETE FROM messages WHERE id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errMessageNotFound(id)
+	}
+	return nil
+}
+
+// SetPinned pins the message for the user, or unpins it if userId is 0.
+func (store *MessageStore) SetPinned(id string, userId int) (*model.Message, error) {
+	var pinnedAt any
+	if userId != 0 {
+		pinnedAt = time.Now().UTC()
+	}
+	msg, err := scanMessage(store.db.QueryRow(`
+	UPDATE messages
+	SET pinned_by = ?, pinned_at = ?
+	WHERE id = ?
+	RETURNING `+messageColumns+`;
+	`, userId, pinnedAt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errMessageNotFound(id)
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GetPinnedMessages returns the pinned messages of the conversation, latest
+// pinned first.
+func (store *MessageStore) GetPinnedMessages(convId string) ([]model.Message, error) {
+	rows, err := store.db.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE conversation_id = ? AND pinned_at IS NOT NULL
+	ORDER BY pinned_at DESC, created_at DESC;
+	`, convId)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+// ExpireMessages replaces up to limit messages that expired before now with
+// tombstones and returns them. Tombstones keep their id, sender and time but
+// lose their content, attachments and pin.
+func (store *MessageStore) ExpireMessages(now time.Time, limit int) ([]model.Message, error) {
+	rows, err := store.db.Query(`
+	UPDATE messages
+	SET content = '', attachments = '', system = '', pinned_by = 0, pinned_at = NULL, deleted_at = ?
+	WHERE id IN (
+		SELECT id FROM messages
+		WHERE deleted_at IS NULL AND julianday(expires_at) <= julianday(?)
+		ORDER BY expires_at
+		LIMIT ?
+	)
+	RETURNING `+messageColumns+`;
+	`, now.UTC(), now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+// CountExpiredMessages returns how many messages expired before now and are
+// not tombstones yet.
+func (store *MessageStore) CountExpiredMessages(now time.Time) (int, error) {
+	var n int
+	err := store.db.QueryRow(`
+	SELECT count(*)
+	FROM messages
+	WHERE deleted_at IS NULL AND julianday(expires_at) <= julianday(?);
+	`, now.UTC()).Scan(&n)
+	return n, err
+}
+
+// DeleteMessagesBefore deletes up to limit messages created before the time,
+// oldest first, and returns them.
+func (store *MessageStore) DeleteMessagesBefore(before time.Time, limit int) ([]model.Message, error) {
+	rows, err := store.db.Query(`
+	DELETE FROM messages
+	WHERE id IN (
+		SELECT id FROM messages
+		WHERE julianday(created_at) < julianday(?)
+		ORDER BY created_at
+		LIMIT ?
+	)
+	RETURNING `+messageColumns+`;
+	`, before.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+func (store *MessageStore) CountMessagesBefore(before time.Time) (int, error) {
+	var n int
+	err := store.db.QueryRow(`
+	SELECT count(*)
+	FROM messages
+	WHERE julianday(created_at) < julianday(?);
+	`, before.UTC()).Scan(&n)
+	return n, err
+}
+
+// GetMessagesBySender returns all messages of the sender, oldest first.
+func (store *MessageStore) GetMessagesBySender(senderId string) ([]model.Message, error) {
+	rows, err := store.db.Query(`
+	SELECT `+messageColumns+`
+	FROM messages
+	WHERE sender_id = ?
+	ORDER BY created_at;
+	`, senderId)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+func (store *MessageStore) AnonymizeMessages(senderId, anonymousId string) (int, error) {
+	result, err := store.db.Exec(`
+	UPDATE messages
+	SET sender_id = ?, username = ''
+	WHERE sender_id = ?;
+	`, anonymousId, senderId)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func errMessageNotFound(id string) error {
+	return &store.Error{
+		Kind:    store.KindMessage,
+		Err:     store.ErrNotFound,
+		Message: fmt.Sprintf("message '%s' not found", id),
+	}
+}
+
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	var path string
+	if cfg.NoSave {
+		path = ":memory:"
+	} else {
+		path = "file:" + cfg.SaveDir + "/messages.db"
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// every connection to :memory: opens a separate database
+	if cfg.NoSave {
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+func initDB(db *sql.DB) error {
+	errs := make([]error, 0)
+
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS messages (
+	id TEXT PRIMARY KEY,
+	conversation_id TEXT NOT NULL,
+	sender_id TEXT NOT NULL,
+	content TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table messages: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS messages_conversation ON messages(conversation_id, created_at);
+	`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create index messages_conversation: %w", err))
+	}
+
+	// columns added after the initial schema
+	columns := []struct{ table, name, def string }{
+		{"messages", "bot", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"messages", "edited_at", "TIMESTAMP"},
+		{"messages", "username", "TEXT NOT NULL DEFAULT ''"},
+		{"messages", "attachments", "TEXT NOT NULL DEFAULT ''"},
+		{"messages", "type", "TEXT NOT NULL DEFAULT ''"},
+		{"messages", "poll_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"messages", "pinned_by", "INTEGER NOT NULL DEFAULT 0"},
+		{"messages", "pinned_at", "TIMESTAMP"},
+		{"messages", "expires_at", "TIMESTAMP"},
+		{"messages", "deleted_at", "TIMESTAMP"},
+		{"messages", "system", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, col := range columns {
+		if err = schema.EnsureColumn(db, col.table, col.name, col.def); err != nil {
+			errs = append(errs, fmt.Errorf("ensure column %s.%s: %w", col.table, col.name, err))
+		}
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS messages_expires ON messages(expires_at) WHERE expires_at IS NOT NULL AND deleted_at IS NULL;
+	`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create index messages_expires: %w", err))
+	}
+	return errors.Join(errs...)
+}
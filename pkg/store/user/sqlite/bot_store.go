@@ -0,0 +1,257 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+// CreateBot creates a bot user owned by the user.
+func (txu *TxUser) CreateBot(ownerId int, username string) (*model.User, error) {
+	user, err := txu.CreateUser(username)
+	if err != nil {
+		return nil, err
+	}
+	return scanUser(txu.tx.QueryRow(`
+	UPDATE users
+	SET bot = 1, owner_id = ?
+	WHERE id = ?
+	RETURNING `+userColumns+`;
+	`, ownerId, user.Id))
+}
+
+// ListBots returns the bots owned by the user.
+func (txu *TxUser) ListBots(ownerId int) ([]model.User, error) {
+	rows, err := txu.tx.Query(`
+	SELECT `+userColumns+`
+	FROM users
+	WHERE bot = 1 AND owner_id = ?
+	ORDER BY id;
+	`, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bots := make([]model.User, 0)
+	for rows.Next() {
+		bot, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		bots = append(bots, *bot)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return bots, nil
+}
+
+// DeleteUser deletes the user with its credentials, sessions and keys.
+func (txu *TxUser) DeleteUser(userId int) error {
+	result, err := txu.tx.Exec(`DELETE FROM users WHERE id = ?;`, userId)
+	if err != nil {
+		return err
+	}
+	if err = userAffected(result, userId); err != nil {
+		return err
+	}
+	return txu.deleteCredentials(userId)
+}
+
+// MarkDeleted deletes the credentials, sessions and keys of the user and
+// marks the account deleted. The account itself is kept, reserving the
+// username, until DeleteUser purges it.
+func (txu *TxUser) MarkDeleted(userId int) error {
+	result, err := txu.tx.Exec(`
+	UPDATE users
+	SET deleted_at = ?
+	WHERE id = ? AND deleted_at IS NULL;
+	`, time.Now().UTC(), userId)
+	if err != nil {
+		return err
+	}
+	if err = userAffected(result, userId); err != nil {
+		return err
+	}
+	return txu.deleteCredentials(userId)
+}
+
+func userAffected(result sql.Result, userId int) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindUser,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("user '%d' not found", userId),
+		}
+	}
+	return nil
+}
+
+func (txu *TxUser) deleteCredentials(userId int) error {
+	_, err := txu.tx.Exec(`
+	DELETE FROM refresh_token
+	WHERE session_id IN (SELECT id FROM session WHERE user_id = ?);
+	`, userId)
+	if err != nil {
+		return err
+	}
+	tables := []string{
+		"password",
+		"session",
+		"access_token",
+		"password_reset",
+		"totp",
+		"recovery_code",
+		"login_challenge",
+		"external_identity",
+		"api_key",
+	}
+	for _, table := range tables {
+		if _, err = txu.tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?;`, userId); err != nil {
+			return fmt.Errorf("delete %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// CreateAPIKey issues an API key for the user. Only its hash is stored.
+func (txu *TxUser) CreateAPIKey(userId int, name string, scopes []string, groupIds []int) (*model.APIKey, error) {
+	keyString := model.APIKeyPrefix + rand.Text()
+
+	scopesJSON, err := json.Marshal(scopes)
+	if err != nil {
+		return nil, err
+	}
+	if groupIds == nil {
+		groupIds = []int{}
+	}
+	groupIdsJSON, err := json.Marshal(groupIds)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scanAPIKey(txu.tx.QueryRow(`
+	INSERT INTO api_key (user_id, name, hash, scopes, group_ids)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING `+apiKeyColumns+`;
+	`, userId, name, hashText(keyString), string(scopesJSON), string(groupIdsJSON)))
+	if err != nil {
+		return nil, err
+	}
+	key.Key = keyString
+	return key, nil
+}
+
+const apiKeyColumns = `id, user_id, name, scopes, group_ids, created_at, last_used_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*model.APIKey, error) {
+	var key model.APIKey
+	var scopes, groupIds string
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&key.Id, &key.UserId, &key.Name, &scopes, &groupIds, &key.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return nil, fmt.Errorf("scopes: %w", err)
+	}
+	if err = json.Unmarshal([]byte(groupIds), &key.GroupIds); err != nil {
+		return nil, fmt.Errorf("group_ids: %w", err)
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return &key, nil
+}
+
+func (txu *TxUser) GetAPIKey(keyString string) (*model.APIKey, error) {
+	key, err := scanAPIKey(txu.tx.QueryRow(`
+	SELECT `+apiKeyColumns+`
+	FROM api_key
+	WHERE hash = ?;
+	`, hashText(keyString)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid api key",
+			}
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+func (txu *TxUser) ListAPIKeys(userId int) ([]model.APIKey, error) {
+	rows, err := txu.tx.Query(`
+	SELECT `+apiKeyColumns+`
+	FROM api_key
+	WHERE user_id = ?
+	ORDER BY id;
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]model.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// TouchAPIKey records use of the key.
+// Writes are throttled to at most one per minute.
+func (txu *TxUser) TouchAPIKey(keyId int) error {
+	_, err := txu.tx.Exec(`
+	UPDATE api_key
+	SET last_used_at = datetime('now')
+	WHERE id = ? AND (
+		last_used_at IS NULL OR
+		datetime(last_used_at) < datetime('now', '-1 minute')
+	);
+	`, keyId)
+	return err
+}
+
+func (txu *TxUser) DeleteAPIKey(userId, keyId int) error {
+	result, err := txu.tx.Exec(`
+	DELETE FROM api_key
+	WHERE id = ? AND user_id = ?;
+	`, keyId, userId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindToken,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("api key '%d' not found", keyId),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func (txu *TxUser) GetExternalIdentity(issuer, subject string) (*model.ExternalIdentity, error) {
+	identity := model.ExternalIdentity{Issuer: issuer, Subject: subject}
+	err := txu.tx.QueryRow(`
+	SELECT user_id, created_at
+	FROM external_identity
+	WHERE issuer = ? AND subject = ?;
+	`, issuer, subject).Scan(&identity.UserId, &identity.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindIdentity,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("identity '%s' of '%s' is not linked", subject, issuer),
+			}
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (txu *TxUser) CreateExternalIdentity(issuer, subject string, userId int) (*model.ExternalIdentity, error) {
+	identity := model.ExternalIdentity{Issuer: issuer, Subject: subject, UserId: userId}
+	err := txu.tx.QueryRow(`
+	INSERT INTO external_identity (issuer, subject, user_id)
+	VALUES (?, ?, ?)
+	RETURNING created_at;
+	`, issuer, subject, userId).Scan(&identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// CreateOIDCState stores the secrets of a provider login under its state.
+func (txu *TxUser) CreateOIDCState(state string, oidcState model.OIDCState, expiresIn time.Duration) error {
+	_, err := txu.tx.Exec(`
+	INSERT INTO oidc_state (hash, code_verifier, nonce, expires_at)
+	VALUES (?, ?, ?, ?);
+	`, hashText(state), oidcState.CodeVerifier, oidcState.Nonce, time.Now().Add(expiresIn).UTC())
+	return err
+}
+
+// UseOIDCState consumes an unexpired state.
+func (txu *TxUser) UseOIDCState(state string) (*model.OIDCState, error) {
+	var oidcState model.OIDCState
+	err := txu.tx.QueryRow(`
+	DELETE FROM oidc_state
+	WHERE hash = ? AND julianday(expires_at) > julianday('now')
+	RETURNING code_verifier, nonce;
+	`, hashText(state)).Scan(&oidcState.CodeVerifier, &oidcState.Nonce)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindIdentity,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid or expired state",
+			}
+		}
+		return nil, err
+	}
+	return &oidcState, nil
+}
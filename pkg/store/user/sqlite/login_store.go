@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/elug3/gochat/pkg/model"
+)
+
+// GetLoginThrottle returns the failure counter of the key, or a zero counter
+// if there were no recent failures.
+func (txu *TxUser) GetLoginThrottle(key string) (*model.LoginThrottle, error) {
+	throttle := model.LoginThrottle{Key: key}
+	err := txu.tx.QueryRow(`
+	SELECT failures, last_failure, blocked_until
+	FROM login_throttle
+	WHERE key = ?;
+	`, key).Scan(&throttle.Failures, &throttle.LastFailure, &throttle.BlockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (txu *TxUser) SetLoginThrottle(throttle model.LoginThrottle) error {
+	_, err := txu.tx.Exec(`
+	INSERT INTO login_throttle (key, failures, last_failure, blocked_until)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(key) DO UPDATE SET
+		failures = excluded.failures,
+		last_failure = excluded.last_failure,
+		blocked_until = excluded.blocked_until;
+	`, throttle.Key, throttle.Failures, throttle.LastFailure.UTC(), throttle.BlockedUntil.UTC())
+	return err
+}
+
+func (txu *TxUser) DeleteLoginThrottle(key string) error {
+	_, err := txu.tx.Exec(`DELETE FROM login_throttle WHERE key = ?;`, key)
+	return err
+}
+
+func (txu *TxUser) CreateLoginAudit(audit model.LoginAudit) error {
+	_, err := txu.tx.Exec(`
+	INSERT INTO login_audit (username, reason, user_agent, ip)
+	VALUES (?, ?, ?, ?);
+	`, audit.Username, audit.Reason, audit.UserAgent, audit.IP)
+	return err
+}
+
+// ListLoginAudits returns the latest failed logins for the username.
+func (txu *TxUser) ListLoginAudits(username string, limit int) ([]model.LoginAudit, error) {
+	rows, err := txu.tx.Query(`
+	SELECT id, username, reason, created_at, user_agent, ip
+	FROM login_audit
+	WHERE username = ?
+	ORDER BY id DESC
+	LIMIT ?;
+	`, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := make([]model.LoginAudit, 0)
+	for rows.Next() {
+		var audit model.LoginAudit
+		if err = rows.Scan(&audit.Id, &audit.Username, &audit.Reason, &audit.CreatedAt, &audit.UserAgent, &audit.IP); err != nil {
+			return nil, err
+		}
+		audits = append(audits, audit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
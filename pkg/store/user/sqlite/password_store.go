@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func (txu *TxUser) GetUserByName(username string) (*model.User, error) {
+	user, err := scanUser(txu.tx.QueryRow(`
+	SELECT `+userColumns+`
+	FROM users
+	WHERE username = ?;
+	`, username))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindUser,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("user '%s' not found", username),
+			}
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateResetCode issues a password reset code for the user. Earlier codes
+// of the user are invalidated.
+func (txu *TxUser) CreateResetCode(userId int, expiresIn time.Duration) (string, error) {
+	_, err := txu.tx.Exec(`
+	DELETE FROM password_reset
+	WHERE user_id = ?;
+	`, userId)
+	if err != nil {
+		return "", err
+	}
+	code := rand.Text()
+	_, err = txu.tx.Exec(`
+	INSERT INTO password_reset (user_id, hash, expires_at)
+	VALUES (?, ?, ?);
+	`, userId, hashText(code), time.Now().Add(expiresIn).UTC())
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// UseResetCode consumes a reset code and returns the user it was issued to.
+func (txu *TxUser) UseResetCode(code string) (int, error) {
+	var userId int
+	err := txu.tx.QueryRow(`
+	DELETE FROM password_reset
+	WHERE hash = ? AND julianday(expires_at) > julianday('now')
+	RETURNING user_id;
+	`, hashText(code)).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid or expired reset code",
+			}
+		}
+		return 0, err
+	}
+	return userId, nil
+}
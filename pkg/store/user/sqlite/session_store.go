@@ -0,0 +1,229 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func (txu *TxUser) CreateSession(userId int, device model.Device) (*model.Session, error) {
+	return scanSession(txu.tx.QueryRow(`
+	INSERT INTO session (user_id, user_agent, ip)
+	VALUES (?, ?, ?)
+	RETURNING `+sessionColumns+`;
+	`, userId, device.UserAgent, device.IP))
+}
+
+const sessionColumns = `id, user_id, issued_at, expires_at, revoked_at, last_used_at, user_agent, ip`
+
+func scanSession(row interface{ Scan(...any) error }) (*model.Session, error) {
+	var session model.Session
+	var expiresAt, revokedAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&session.Id,
+		&session.UserId,
+		&session.IssuedAt,
+		&expiresAt,
+		&revokedAt,
+		&lastUsedAt,
+		&session.UserAgent,
+		&session.IP,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		session.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	if lastUsedAt.Valid {
+		session.LastUsedAt = &lastUsedAt.Time
+	}
+	return &session, nil
+}
+
+func (txu *TxUser) GetSession(sessionId int) (*model.Session, error) {
+	session, err := scanSession(txu.tx.QueryRow(`
+	SELECT `+sessionColumns+`
+	FROM session
+	WHERE id = ?;
+	`, sessionId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindSession,
+				Err:     store.ErrNotFound,
+				Message: fmt.Sprintf("session '%d' not found", sessionId),
+			}
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// ListSessions returns the sessions of the user that are neither revoked nor expired.
+func (txu *TxUser) ListSessions(userId int) ([]model.Session, error) {
+	rows, err := txu.tx.Query(`
+	SELECT `+sessionColumns+`
+	FROM session
+	WHERE user_id = ? AND revoked_at IS NULL AND (
+		expires_at IS NULL OR
+		datetime(expires_at) > datetime('now')
+	)
+	ORDER BY issued_at DESC;
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]model.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// TouchSession records use of the session.
+// Writes are throttled to at most one per minute.
+func (txu *TxUser) TouchSession(sessionId int) error {
+	_, err := txu.tx.Exec(`
+	UPDATE session
+	SET last_used_at = datetime('now')
+	WHERE id = ? AND (
+		last_used_at IS NULL OR
+		datetime(last_used_at) < datetime('now', '-1 minute')
+	);
+	`, sessionId)
+	return err
+}
+
+// RevokeSession marks the session revoked and deletes all of its tokens.
+func (txu *TxUser) RevokeSession(sessionId int) error {
+	result, err := txu.tx.Exec(`
+	UPDATE session
+	SET revoked_at = datetime('now')
+	WHERE id = ? AND revoked_at IS NULL;
+	`, sessionId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindSession,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("session '%d' not found", sessionId),
+		}
+	}
+
+	if _, err = txu.tx.Exec(`DELETE FROM access_token WHERE session_id = ?;`, sessionId); err != nil {
+		return err
+	}
+	_, err = txu.tx.Exec(`DELETE FROM refresh_token WHERE session_id = ?;`, sessionId)
+	return err
+}
+
+// CreateRefreshToken issues a refresh token for the session and extends the
+// session to its expiry.
+func (txu *TxUser) CreateRefreshToken(sessionId int, expiresIn time.Duration) (*model.RefreshToken, error) {
+	tokenString := rand.Text()
+
+	var expiresAt sql.NullTime
+	if expiresIn != 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(expiresIn).UTC(), Valid: true}
+	}
+
+	var token model.RefreshToken
+	var scannedExpiresAt sql.NullTime
+	err := txu.tx.QueryRow(`
+	INSERT INTO refresh_token (session_id, hash, expires_at)
+	VALUES (?, ?, ?)
+	RETURNING id, session_id, expires_at, issued_at;
+	`, sessionId, hashText(tokenString), expiresAt).Scan(&token.Id, &token.SessionId, &scannedExpiresAt, &token.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	if scannedExpiresAt.Valid {
+		token.ExpiresAt = &scannedExpiresAt.Time
+	}
+
+	_, err = txu.tx.Exec(`
+	UPDATE session
+	SET expires_at = ?
+	WHERE id = ?;
+	`, expiresAt, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	token.Token = tokenString
+	return &token, nil
+}
+
+func (txu *TxUser) GetRefreshToken(tokenString string) (*model.RefreshToken, error) {
+	var token model.RefreshToken
+	var expiresAt, usedAt sql.NullTime
+	err := txu.tx.QueryRow(`
+	SELECT id, session_id, expires_at, issued_at, used_at
+	FROM refresh_token
+	WHERE hash = ?;
+	`, hashText(tokenString)).Scan(&token.Id, &token.SessionId, &expiresAt, &token.IssuedAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid refresh token",
+			}
+		}
+		return nil, err
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks the refresh token as exchanged.
+func (txu *TxUser) UseRefreshToken(id int) error {
+	result, err := txu.tx.Exec(`
+	UPDATE refresh_token
+	SET used_at = datetime('now')
+	WHERE id = ? AND used_at IS NULL;
+	`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindToken,
+			Err:     store.ErrUnauthorized,
+			Message: "refresh token already used",
+		}
+	}
+	return nil
+}
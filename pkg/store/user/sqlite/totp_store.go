@@ -0,0 +1,219 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/elug3/gochat/pkg/model"
+	"github.com/elug3/gochat/pkg/store"
+)
+
+func (txu *TxUser) GetTOTP(userId int) (*model.TOTP, error) {
+	totp := model.TOTP{UserId: userId}
+	var enabledAt sql.NullTime
+	err := txu.tx.QueryRow(`
+	SELECT secret, enabled_at, last_counter
+	FROM totp
+	WHERE user_id = ?;
+	`, userId).Scan(&totp.Secret, &enabledAt, &totp.LastCounter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindTOTP,
+				Err:     store.ErrNotFound,
+				Message: "two-factor authentication is not set up",
+			}
+		}
+		return nil, err
+	}
+	if enabledAt.Valid {
+		totp.EnabledAt = &enabledAt.Time
+	}
+	return &totp, nil
+}
+
+// SetTOTP stores a new secret for the user, pending until it is enabled.
+func (txu *TxUser) SetTOTP(userId int, secret string) error {
+	_, err := txu.tx.Exec(`
+	INSERT INTO totp (user_id, secret)
+	VALUES (?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		secret = excluded.secret,
+		enabled_at = NULL,
+		last_counter = 0;
+	`, userId, secret)
+	return err
+}
+
+func (txu *TxUser) EnableTOTP(userId int) error {
+	_, err := txu.tx.Exec(`
+	UPDATE totp
+	SET enabled_at = datetime('now')
+	WHERE user_id = ?;
+	`, userId)
+	return err
+}
+
+// UseTOTPCounter records the time step of an accepted code. A step can only
+// be used once, so an observed code cannot be replayed.
+func (txu *TxUser) UseTOTPCounter(userId int, counter int64) error {
+	result, err := txu.tx.Exec(`
+	UPDATE totp
+	SET last_counter = ?
+	WHERE user_id = ? AND last_counter < ?;
+	`, counter, userId, counter)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindTOTP,
+			Err:     store.ErrUnauthorized,
+			Message: "code already used",
+		}
+	}
+	return nil
+}
+
+// DeleteTOTP removes the authenticator and the recovery codes of the user.
+func (txu *TxUser) DeleteTOTP(userId int) error {
+	if _, err := txu.tx.Exec(`DELETE FROM totp WHERE user_id = ?;`, userId); err != nil {
+		return err
+	}
+	_, err := txu.tx.Exec(`DELETE FROM recovery_code WHERE user_id = ?;`, userId)
+	return err
+}
+
+// SetRecoveryCodes replaces the recovery codes of the user. Codes are hashed
+// like passwords.
+func (txu *TxUser) SetRecoveryCodes(userId int, codes []string) error {
+	if _, err := txu.tx.Exec(`DELETE FROM recovery_code WHERE user_id = ?;`, userId); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		hash, err := argon2id.CreateHash(code, argon2id.DefaultParams)
+		if err != nil {
+			return err
+		}
+		_, err = txu.tx.Exec(`
+		INSERT INTO recovery_code (user_id, hash)
+		VALUES (?, ?);
+		`, userId, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code of the user.
+func (txu *TxUser) UseRecoveryCode(userId int, code string) error {
+	rows, err := txu.tx.Query(`
+	SELECT id, hash
+	FROM recovery_code
+	WHERE user_id = ? AND used_at IS NULL;
+	`, userId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	matchId := 0
+	for rows.Next() {
+		var id int
+		var hash string
+		if err = rows.Scan(&id, &hash); err != nil {
+			return err
+		}
+		match, err := argon2id.ComparePasswordAndHash(code, hash)
+		if err != nil {
+			return err
+		}
+		if match {
+			matchId = id
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if matchId == 0 {
+		return &store.Error{
+			Kind:    store.KindTOTP,
+			Err:     store.ErrUnauthorized,
+			Message: "invalid code",
+		}
+	}
+	_, err = txu.tx.Exec(`
+	UPDATE recovery_code
+	SET used_at = datetime('now')
+	WHERE id = ?;
+	`, matchId)
+	return err
+}
+
+// CreateChallenge issues a token for the second step of a login.
+func (txu *TxUser) CreateChallenge(userId int, expiresIn time.Duration) (*model.LoginChallenge, error) {
+	token := rand.Text()
+	expiresAt := time.Now().Add(expiresIn).UTC()
+	_, err := txu.tx.Exec(`
+	INSERT INTO login_challenge (user_id, hash, expires_at)
+	VALUES (?, ?, ?);
+	`, userId, hashText(token), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &model.LoginChallenge{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// GetChallenge returns the user of an unexpired challenge.
+func (txu *TxUser) GetChallenge(token string) (userId int, err error) {
+	err = txu.tx.QueryRow(`
+	SELECT user_id
+	FROM login_challenge
+	WHERE hash = ? AND julianday(expires_at) > julianday('now');
+	`, hashText(token)).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid or expired challenge",
+			}
+		}
+		return 0, err
+	}
+	return userId, nil
+}
+
+// FailChallenge counts a wrong code for the challenge and deletes it after
+// maxAttempts.
+func (txu *TxUser) FailChallenge(token string, maxAttempts int) error {
+	hash := hashText(token)
+	_, err := txu.tx.Exec(`
+	UPDATE login_challenge
+	SET attempts = attempts + 1
+	WHERE hash = ?;
+	`, hash)
+	if err != nil {
+		return err
+	}
+	_, err = txu.tx.Exec(`
+	DELETE FROM login_challenge
+	WHERE hash = ? AND attempts >= ?;
+	`, hash, maxAttempts)
+	return err
+}
+
+func (txu *TxUser) DeleteChallenge(token string) error {
+	_, err := txu.tx.Exec(`DELETE FROM login_challenge WHERE hash = ?;`, hashText(token))
+	return err
+}
@@ -10,9 +10,10 @@ import (
 	"time"
 
 	"github.com/alexedwards/argon2id"
-	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/pkg/config"
 	"github.com/elug3/gochat/pkg/model"
 	"github.com/elug3/gochat/pkg/store"
+	"github.com/elug3/gochat/pkg/store/schema"
 )
 
 type UserStore struct {
@@ -83,26 +84,36 @@ func (txu *TxUser) CreateUser(username string) (*model.User, error) {
 }
 
 func (txu *TxUser) createUser(username string) (*model.User, error) {
-	var user model.User
-	err := txu.tx.QueryRow(`
+	return scanUser(txu.tx.QueryRow(`
 	INSERT INTO users (username)
 	VALUES (?)
-	RETURNING id, username;
-	`, username).Scan(&user.Id, &user.Username)
-	if err != nil {
+	RETURNING `+userColumns+`;
+	`, username))
+}
+
+const userColumns = `id, username, bot, owner_id, deleted_at`
+
+func scanUser(row interface{ Scan(...any) error }) (*model.User, error) {
+	var user model.User
+	var ownerId sql.NullInt64
+	var deletedAt sql.NullTime
+	if err := row.Scan(&user.Id, &user.Username, &user.Bot, &ownerId, &deletedAt); err != nil {
 		return nil, err
 	}
+	user.OwnerId = int(ownerId.Int64)
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
 	return &user, nil
 }
 
 func (txu *TxUser) GetUser(userId int) (*model.User, error) {
-	row := txu.tx.QueryRow(`
-	SELECT id, username 
-	FROM users 
-	WHERE id = ?
-	;`, userId)
-	var user model.User
-	if err := row.Scan(&user.Id, &user.Username); err != nil {
+	user, err := scanUser(txu.tx.QueryRow(`
+	SELECT `+userColumns+`
+	FROM users
+	WHERE id = ?;
+	`, userId))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, &store.Error{
 				Kind:    store.KindUser,
@@ -112,7 +123,7 @@ func (txu *TxUser) GetUser(userId int) (*model.User, error) {
 		}
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
 }
 
 func (txu *TxUser) UpdatePassword(userId int, password string) error {
@@ -140,6 +151,13 @@ func (txu *TxUser) ValidatePassword(username, password string) (userId int, err
 
 	var passwordHash string
 	if err = row.Scan(&userId, &passwordHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, &store.Error{
+				Kind:    store.KindUser,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid username or password",
+			}
+		}
 		return 0, err
 	}
 
@@ -150,8 +168,8 @@ func (txu *TxUser) ValidatePassword(username, password string) (userId int, err
 	if !match {
 		return 0, &store.Error{
 			Kind:    store.KindUser,
-			Err:     store.ErrBadRequest,
-			Message: "",
+			Err:     store.ErrUnauthorized,
+			Message: "invalid username or password",
 		}
 	}
 	return userId, nil
@@ -171,7 +189,7 @@ func hashText(s string) string {
 	return base32.HexEncoding.EncodeToString(sum[:])
 }
 
-func (txu *TxUser) CreateToken(userId int, expiresIn time.Duration) (*model.Token, error) {
+func (txu *TxUser) CreateToken(userId, sessionId int, expiresIn time.Duration) (*model.Token, error) {
 	tokenString := rand.Text()
 	hash := hashText(tokenString)
 
@@ -183,12 +201,12 @@ func (txu *TxUser) CreateToken(userId int, expiresIn time.Duration) (*model.Toke
 	}
 
 	row := txu.tx.QueryRow(`
-	INSERT INTO access_token (user_id, hash, expires_at)
-	VALUES (?, ?, ?)
-	RETURNING id, user_id, expires_at, issued_at;
-	`, userId, hash, expiresAt)
+	INSERT INTO access_token (user_id, session_id, hash, expires_at)
+	VALUES (?, ?, ?, ?)
+	RETURNING id, user_id, session_id, expires_at, issued_at;
+	`, userId, sessionId, hash, expiresAt)
 	var token model.Token
-	if err := row.Scan(&token.Id, &token.UserId, &token.ExpiresAt, &token.IssuedAt); err != nil {
+	if err := row.Scan(&token.Id, &token.UserId, &token.SessionId, &token.ExpiresAt, &token.IssuedAt); err != nil {
 		return nil, err
 	}
 	token.AccessToken = tokenString
@@ -199,39 +217,85 @@ func (txu *TxUser) GetToken(tokenString string) (*model.Token, error) {
 	var token model.Token
 	hash := hashText(tokenString)
 	err := txu.tx.QueryRow(`
-	SELECT id, user_id, expires_at, issued_at
+	SELECT id, user_id, session_id, expires_at, issued_at
 	FROM access_token 
-	WHERE hash = ?;`, hash).Scan(&token.Id, &token.UserId, &token.ExpiresAt, &token.IssuedAt)
+	WHERE hash = ?;`, hash).Scan(&token.Id, &token.UserId, &token.SessionId, &token.ExpiresAt, &token.IssuedAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &store.Error{
+				Kind:    store.KindToken,
+				Err:     store.ErrUnauthorized,
+				Message: "invalid token",
+			}
+		}
 		return nil, err
 	}
 	return &token, nil
 
 }
 
-func (txu *TxUser) ListTokens(userId int) ([]model.Token, error) {
+// ExtendToken moves the expiry of the access token to expiresIn from now.
+func (txu *TxUser) ExtendToken(tokenId string, expiresIn time.Duration) error {
+	result, err := txu.tx.Exec(`
+	UPDATE access_token
+	SET expires_at = ?
+	WHERE id = ?;
+	`, time.Now().Add(expiresIn).UTC(), tokenId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return &store.Error{
+			Kind:    store.KindToken,
+			Err:     store.ErrNotFound,
+			Message: fmt.Sprintf("token '%s' not found", tokenId),
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredTokens deletes access tokens past their expiry and returns
+// how many were deleted.
+func (txu *TxUser) DeleteExpiredTokens() (int64, error) {
+	result, err := txu.tx.Exec(`
+	DELETE FROM access_token
+	WHERE expires_at IS NOT NULL AND julianday(expires_at) < julianday('now');
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetDeletedUsers returns the ids of accounts deleted before the time.
+func (txu *TxUser) GetDeletedUsers(before time.Time) ([]int, error) {
 	rows, err := txu.tx.Query(`
-	SELECT id, user_id, expires_at, issued_at
-	FROM access_token
-	WHERE user_id = ?;
-	`, userId)
+	SELECT id
+	FROM users
+	WHERE deleted_at IS NOT NULL AND julianday(deleted_at) <= julianday(?)
+	ORDER BY id;
+	`, before.UTC())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("query: %w", err)
 	}
 	defer rows.Close()
 
-	tokens := make([]model.Token, 0)
+	ids := make([]int, 0)
 	for rows.Next() {
-		var token model.Token
-		if err := rows.Scan(&token.Id, &token.UserId, &token.ExpiresAt, &token.IssuedAt); err != nil {
-			return nil, err
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
 		}
-		tokens = append(tokens, token)
+		ids = append(ids, id)
 	}
-	if err := rows.Err(); err != nil {
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	return tokens, nil
+	return ids, nil
 }
 
 // splitEdge returns the first and last 4 characters of the given string.
@@ -272,17 +336,202 @@ func initDB(db *sql.DB) error {
 		errs = append(errs, fmt.Errorf("create table password: %w", err))
 	}
 
+	if err = dropLegacyAccessToken(db); err != nil {
+		errs = append(errs, fmt.Errorf("drop legacy access_token: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS session (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	issued_at TIMESTAMP DEFAULT (datetime('now')),
+	expires_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table session: %w", err))
+	}
+
 	_, err = db.Exec(`
 	CREATE TABLE IF NOT EXISTS access_token (
 	id INTEGER PRIMARY KEY,
-	user_id INTEGER NOT NULL UNIQUE,
-	hash TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	session_id INTEGER NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
 	expires_at TIMESTAMP,
 	issued_at TIMESTAMP DEFAULT (datetime('now')),
-	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY(session_id) REFERENCES session(id) ON DELETE CASCADE
 	);`)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("create table acces_tokens: %w", err))
 	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS refresh_token (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id INTEGER NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMP,
+	issued_at TIMESTAMP DEFAULT (datetime('now')),
+	used_at TIMESTAMP,
+	FOREIGN KEY(session_id) REFERENCES session(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table refresh_token: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS password_reset (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table password_reset: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS login_throttle (
+	key TEXT PRIMARY KEY,
+	failures INTEGER NOT NULL,
+	last_failure TIMESTAMP NOT NULL,
+	blocked_until TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table login_throttle: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS login_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT (datetime('now'))
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table login_audit: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS totp (
+	user_id INTEGER PRIMARY KEY,
+	secret TEXT NOT NULL,
+	enabled_at TIMESTAMP,
+	last_counter INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table totp: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS recovery_code (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table recovery_code: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS login_challenge (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table login_challenge: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS external_identity (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	UNIQUE(issuer, subject),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table external_identity: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS oidc_state (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash TEXT NOT NULL UNIQUE,
+	code_verifier TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table oidc_state: %w", err))
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS api_key (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	group_ids TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT (datetime('now')),
+	last_used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("create table api_key: %w", err))
+	}
+
+	// columns added after the initial schema
+	columns := []struct{ table, name, def string }{
+		{"session", "last_used_at", "TIMESTAMP"},
+		{"session", "user_agent", "TEXT NOT NULL DEFAULT ''"},
+		{"session", "ip", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "bot", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"users", "owner_id", "INTEGER REFERENCES users(id) ON DELETE CASCADE"},
+		{"users", "deleted_at", "TIMESTAMP"},
+	}
+	for _, col := range columns {
+		if err = schema.EnsureColumn(db, col.table, col.name, col.def); err != nil {
+			errs = append(errs, fmt.Errorf("ensure column %s.%s: %w", col.table, col.name, err))
+		}
+	}
 	return errors.Join(errs...)
 }
+
+// dropLegacyAccessToken drops the access_token table created before sessions,
+// which allowed one token per user. Existing tokens are discarded and users
+// have to log in again.
+func dropLegacyAccessToken(db *sql.DB) error {
+	var legacy bool
+	err := db.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM sqlite_master
+		WHERE type = 'table' AND name = 'access_token' AND sql NOT LIKE '%session_id%'
+	);`).Scan(&legacy)
+	if err != nil || !legacy {
+		return err
+	}
+	_, err = db.Exec(`DROP TABLE access_token;`)
+	return err
+}
@@ -4,7 +4,7 @@ import (
 	"errors"
 	"testing"
 
-	"github.com/elug3/gochat/internal/config"
+	"github.com/elug3/gochat/pkg/config"
 	"github.com/elug3/gochat/pkg/store"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -17,9 +17,59 @@ type TxUser interface {
 	Commit() error
 
 	GetUser(userId int) (*model.User, error)
+	GetUserByName(username string) (*model.User, error)
 	CreateUser(username string) (*model.User, error)
 	UpdatePassword(userId int, password string) error
 	ValidatePassword(username, password string) (userId int, err error)
-	CreateToken(userId int, expiresIn time.Duration) (*model.Token, error)
+	CreateResetCode(userId int, expiresIn time.Duration) (string, error)
+	UseResetCode(code string) (userId int, err error)
+
+	GetLoginThrottle(key string) (*model.LoginThrottle, error)
+	SetLoginThrottle(throttle model.LoginThrottle) error
+	DeleteLoginThrottle(key string) error
+	CreateLoginAudit(audit model.LoginAudit) error
+	ListLoginAudits(username string, limit int) ([]model.LoginAudit, error)
+
+	GetTOTP(userId int) (*model.TOTP, error)
+	SetTOTP(userId int, secret string) error
+	EnableTOTP(userId int) error
+	UseTOTPCounter(userId int, counter int64) error
+	DeleteTOTP(userId int) error
+	SetRecoveryCodes(userId int, codes []string) error
+	UseRecoveryCode(userId int, code string) error
+	CreateChallenge(userId int, expiresIn time.Duration) (*model.LoginChallenge, error)
+	GetChallenge(token string) (userId int, err error)
+	FailChallenge(token string, maxAttempts int) error
+	DeleteChallenge(token string) error
+
+	GetExternalIdentity(issuer, subject string) (*model.ExternalIdentity, error)
+	CreateExternalIdentity(issuer, subject string, userId int) (*model.ExternalIdentity, error)
+	CreateOIDCState(state string, oidcState model.OIDCState, expiresIn time.Duration) error
+	UseOIDCState(state string) (*model.OIDCState, error)
+
+	CreateBot(ownerId int, username string) (*model.User, error)
+	ListBots(ownerId int) ([]model.User, error)
+	DeleteUser(userId int) error
+	MarkDeleted(userId int) error
+	GetDeletedUsers(before time.Time) ([]int, error)
+	CreateAPIKey(userId int, name string, scopes []string, groupIds []int) (*model.APIKey, error)
+	GetAPIKey(keyString string) (*model.APIKey, error)
+	ListAPIKeys(userId int) ([]model.APIKey, error)
+	TouchAPIKey(keyId int) error
+	DeleteAPIKey(userId, keyId int) error
+
+	CreateToken(userId, sessionId int, expiresIn time.Duration) (*model.Token, error)
 	GetToken(tokenString string) (*model.Token, error)
+	ExtendToken(tokenId string, expiresIn time.Duration) error
+	DeleteExpiredTokens() (int64, error)
+
+	CreateSession(userId int, device model.Device) (*model.Session, error)
+	GetSession(sessionId int) (*model.Session, error)
+	ListSessions(userId int) ([]model.Session, error)
+	TouchSession(sessionId int) error
+	RevokeSession(sessionId int) error
+
+	CreateRefreshToken(sessionId int, expiresIn time.Duration) (*model.RefreshToken, error)
+	GetRefreshToken(tokenString string) (*model.RefreshToken, error)
+	UseRefreshToken(id int) error
 }
@@ -0,0 +1,54 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// test vectors of RFC 6238 appendix B, truncated to 6 digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	testCases := map[string]struct {
+		unix int64
+		want string
+	}{
+		"59":         {unix: 59, want: "287082"},
+		"1111111109": {unix: 1111111109, want: "081804"},
+		"1234567890": {unix: 1234567890, want: "005924"},
+		"2000000000": {unix: 2000000000, want: "279037"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := Code(secret, Counter(time.Unix(tc.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := Code(secret, Counter(now.Add(-Period*time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(secret, code, now, 1); !ok {
+		t.Error("expected code of the previous step to be accepted")
+	}
+	if _, ok := Validate(secret, code, now, 0); ok {
+		t.Error("expected code of the previous step to be rejected without skew")
+	}
+	if uri := URI("gochat", "test", secret); !strings.HasPrefix(uri, "otpauth://totp/gochat:test?") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}